@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const retryDelay = 5 * time.Second
+
+var (
+	// errNotFound is returned when the API has no plugin or theme with the
+	// requested slug.
+	errNotFound = errors.New("not found")
+
+	// errLimitReached stops enumeration once --max-downloads plugins were selected.
+	errLimitReached = errors.New("download limit reached")
+)
+
+func (s *Scraper) fetchPluginList(ctx context.Context, pageNumber int) (PluginList, error) {
+	var pluginList PluginList
+	err := s.getJSON(ctx, s.limiter, s.apiURL(s.listQuery(pageNumber)), &pluginList)
+	return pluginList, err
+}
+
+// listQuery builds the query_plugins or query_themes request for a page, narrowed down on
+// the server side by the query settings.
+func (s *Scraper) listQuery(pageNumber int) url.Values {
+	query := url.Values{
+		"action":        {s.dir.listAction},
+		"request[page]": {strconv.Itoa(pageNumber)},
+	}
+	if s.cfg.Query.PerPage > 0 {
+		query.Set("request[per_page]", strconv.Itoa(s.cfg.Query.PerPage))
+	}
+	if s.cfg.Query.Browse != "" {
+		query.Set("request[browse]", s.cfg.Query.Browse)
+	}
+	if s.cfg.Query.Search != "" {
+		query.Set("request[search]", s.cfg.Query.Search)
+	}
+	if s.cfg.Query.Tag != "" {
+		query.Set("request[tag]", s.cfg.Query.Tag)
+	}
+	if s.cfg.Query.Author != "" {
+		query.Set("request[author]", s.cfg.Query.Author)
+	}
+	s.addCommon(query)
+	return query
+}
+
+// addCommon adds the settings shared by every API request to query.
+func (s *Scraper) addCommon(query url.Values) {
+	if s.cfg.Query.Locale != "" {
+		query.Set("request[locale]", s.cfg.Query.Locale)
+	}
+	addFields(query, s.dir.fields)
+	addFields(query, s.cfg.Query.Fields)
+	if s.cfg.AllVersions {
+		query.Set("request[fields][versions]", "true")
+	}
+}
+
+// addFields adds the request[fields] switches to query. Fields that are
+// not requested simply decode to their zero value.
+func addFields(query url.Values, fields map[string]bool) {
+	for name, enabled := range fields {
+		query.Set("request[fields]["+name+"]", strconv.FormatBool(enabled))
+	}
+}
+
+// fetchPluginInfo looks up a single plugin or theme with the
+// plugin_information or theme_information action.
+func (s *Scraper) fetchPluginInfo(ctx context.Context, slug string) (Plugin, error) {
+	var plugin Plugin
+	if err := s.getJSON(ctx, s.limiter, s.apiURL(s.infoQuery(slug)), &plugin); err != nil {
+		return plugin, fmt.Errorf("%s: %w", slug, err)
+	}
+	return plugin, nil
+}
+
+// infoQuery builds the plugin_information or theme_information request for
+// slug.
+func (s *Scraper) infoQuery(slug string) url.Values {
+	query := url.Values{
+		"action":        {s.dir.infoAction},
+		"request[slug]": {slug},
+	}
+	s.addCommon(query)
+	return query
+}
+
+// apiURL returns the URL of the directory API request described by query.
+func (s *Scraper) apiURL(query url.Values) string {
+	return s.dir.apiURL + "?" + query.Encode()
+}
+
+// getJSON requests rawURL, paced by limiter, and decodes the response into
+// v, retrying failed requests up to the configured number of attempts.
+func (s *Scraper) getJSON(ctx context.Context, limiter *rateLimiter, rawURL string, v any) error {
+	var err error
+	for attempt := 1; attempt <= s.cfg.Retries; attempt++ {
+		err = s.getJSONOnce(ctx, limiter, rawURL, v)
+		if err == nil || errors.Is(err, errNotFound) || ctx.Err() != nil {
+			return err
+		}
+		if attempt < s.cfg.Retries {
+			slog.Warn("API request failed", "url", rawURL, "attempt", attempt, "attempts", s.cfg.Retries, "error", err)
+			if err := sleep(ctx, retryDelay); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+func (s *Scraper) getJSONOnce(ctx context.Context, limiter *rateLimiter, rawURL string, v any) error {
+	resp, err := s.get(ctx, limiter, rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s.statusError(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// Unknown slugs are sometimes reported as {"error": "..."} with a 200.
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+		return fmt.Errorf("%w: %s", errNotFound, apiErr.Error)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// each calls fn for every plugin selected by the configuration: the plugins
+// named by --slug and --slugs-file if any are given, otherwise every plugin
+// in the directory that passes the filters. Plugins excluded by the
+// allowlist or blocklist are skipped, --top or --sample narrow the
+// selection down to the most installed plugins or a random sample, and at
+// most --max-downloads plugins are passed to fn, enriched with their full
+// plugin_information metadata if --enrich is set.
+func (s *Scraper) each(ctx context.Context, fn func(Plugin) error) error {
+	slugs, err := s.requestedSlugs()
+	if err != nil {
+		return err
+	}
+
+	source := func(fn func(Plugin) error) error {
+		return s.walk(ctx, fn)
+	}
+	if len(slugs) > 0 {
+		source = func(fn func(Plugin) error) error {
+			return s.resolveSlugs(ctx, slugs, fn)
+		}
+	}
+
+	if s.allow != nil || s.block != nil {
+		next := source
+		source = func(fn func(Plugin) error) error {
+			return next(func(plugin Plugin) error {
+				if !s.listed(plugin.Slug) {
+					slog.Debug("skipping plugin excluded by allowlist or blocklist", "slug", plugin.Slug)
+					return nil
+				}
+				return fn(plugin)
+			})
+		}
+	}
+
+	if s.cfg.Top > 0 {
+		next := source
+		source = func(fn func(Plugin) error) error {
+			return selectTop(next, s.cfg.Top, fn)
+		}
+	}
+
+	if s.cfg.Enrich && len(slugs) == 0 {
+		// Slugs are already resolved with plugin_information.
+		next := fn
+		fn = func(plugin Plugin) error {
+			return next(s.enrich(ctx, plugin))
+		}
+	}
+
+	if s.cfg.Sample > 0 {
+		next := source
+		source = func(fn func(Plugin) error) error {
+			return selectSample(next, s.cfg.Sample, s.cfg.Seed, fn)
+		}
+	}
+
+	if limit := s.cfg.MaxDownloads; limit > 0 {
+		var selected int
+		next := fn
+		fn = func(plugin Plugin) error {
+			if selected >= limit {
+				return errLimitReached
+			}
+			selected++
+			return next(plugin)
+		}
+	}
+
+	err = source(fn)
+	if errors.Is(err, errLimitReached) {
+		return nil
+	}
+	return err
+}
+
+// walk pages through the plugin directory from --start-page to --end-page
+// and calls fn for every plugin that passes the configured filters. It stops
+// at the first error of fn. A page that cannot be fetched opens the circuit
+// breaker, which requests the page again once the API recovers and stops the
+// walk if it does not within --breaker-timeout. Pages are decoded as a
+// stream; with --prefetch-pages the following pages are instead requested in
+// full while fn processes the current one.
+func (s *Scraper) walk(ctx context.Context, fn func(Plugin) error) error {
+	page := s.streamPluginList
+	if s.cfg.PrefetchPages > 0 {
+		prefetch := newPrefetcher(ctx, s, s.cfg.PrefetchPages)
+		page = func(ctx context.Context, pageNumber int, fn func(Plugin) error) (PageInfo, int, error) {
+			pluginList, err := prefetch.fetch(pageNumber)
+			if err != nil {
+				return PageInfo{}, 0, fmt.Errorf("fetch page %d: %w", pageNumber, err)
+			}
+			items := pluginList.items()
+			for _, plugin := range items {
+				if err := fn(plugin); err != nil {
+					return pluginList.Info, len(items), err
+				}
+			}
+			return pluginList.Info, len(items), nil
+		}
+	}
+
+	first := s.cfg.StartPage
+	if resume := s.checkpoint.resumePage(); resume > first {
+		first = resume
+	}
+	breaker := newCircuitBreaker(time.Duration(s.cfg.BreakerCooldown), time.Duration(s.cfg.BreakerTimeout))
+	for pageNumber := first; s.cfg.EndPage == 0 || pageNumber <= s.cfg.EndPage; pageNumber++ {
+		s.checkpoint.enter(pageNumber)
+		// Plugins passed on before a failed request are not passed again
+		// when the page is probed.
+		var delivered int
+		var info PageInfo
+		var items int
+		var err error
+		for {
+			var seen int
+			var stopped error
+			info, items, err = page(ctx, pageNumber, func(plugin Plugin) error {
+				seen++
+				if seen <= delivered {
+					return nil
+				}
+				delivered++
+				if !s.matches(plugin) {
+					return nil
+				}
+				stopped = fn(plugin)
+				return stopped
+			})
+			if err == nil || stopped != nil || ctx.Err() != nil {
+				break
+			}
+			if err := breaker.failed(ctx, pageNumber, err); err != nil {
+				return err
+			}
+		}
+		if err != nil || items == 0 {
+			return err
+		}
+		breaker.succeeded(pageNumber)
+		if info.Pages > 0 && pageNumber >= info.Pages {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// tmpSuffix marks files that are still being written. They are renamed to
+// their final name only once they are complete, so that an interrupted run
+// never leaves truncated files that look finished.
+const tmpSuffix = ".tmp"
+
+// writeAtomic creates fileName with the content produced by write. The data
+// goes to a temporary file next to fileName, which replaces fileName only if
+// write and the close succeed.
+func writeAtomic(fileName string, write func(io.Writer) error) error {
+	tmpName := fileName + tmpSuffix
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// writeFileAtomic is the atomic counterpart of os.WriteFile.
+func writeFileAtomic(fileName string, data []byte) error {
+	return writeAtomic(fileName, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
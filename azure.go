@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// azureVersion is the Blob service REST API version of the requests.
+	azureVersion = "2021-08-06"
+	// azureResource is the audience of managed identity tokens for storage.
+	azureResource = "https://storage.azure.com/"
+	// azureIMDSURL is the token endpoint of the instance metadata service.
+	azureIMDSURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	// maxAzureBlocks is the most blocks a block blob may consist of.
+	maxAzureBlocks = 50000
+)
+
+// azureStorage stores files as block blobs in an Azure Storage container.
+// Files larger than --azure-block-size are staged block by block and
+// committed with a block list.
+type azureStorage struct {
+	client    *http.Client
+	container url.URL
+	prefix    string
+	// sas is the SAS token appended to every request; without one the
+	// requests carry a managed identity token.
+	sas       url.Values
+	blockSize int64
+}
+
+// newAzureStorage returns the storage of an azure://account/container/prefix
+// target. It authenticates with the SAS token of --azure-sas or
+// AZURE_STORAGE_SAS_TOKEN, or else with the managed identity of the host,
+// the user-assigned one of --azure-client-id if given.
+func newAzureStorage(cfg Config, client *http.Client, target *url.URL) (*azureStorage, error) {
+	container, prefix, _ := strings.Cut(strings.TrimPrefix(target.Path, "/"), "/")
+	a := &azureStorage{
+		client:    client,
+		container: url.URL{Scheme: "https", Host: target.Host + ".blob.core.windows.net", Path: "/" + container},
+		prefix:    strings.Trim(prefix, "/"),
+		blockSize: int64(cfg.AzureBlockSize),
+	}
+
+	sas := cfg.AzureSAS
+	if sas == "" {
+		sas = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	}
+	if sas != "" {
+		values, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("azure-sas: %w", err)
+		}
+		a.sas = values
+		return a, nil
+	}
+
+	source := oauth2.ReuseTokenSource(nil, &managedIdentity{client: client, clientID: cfg.AzureClientID})
+	a.client = &http.Client{
+		Transport: &oauth2.Transport{Source: source, Base: client.Transport},
+		Timeout:   client.Timeout,
+	}
+	return a, nil
+}
+
+// blobURL returns the URL of the blob name below the prefix, with the
+// given query parameters and the SAS token.
+func (a *azureStorage) blobURL(name string, query url.Values) string {
+	target := a.container
+	target.Path += "/" + path.Join(a.prefix, name)
+	target.RawQuery = a.query(query).Encode()
+	return target.String()
+}
+
+// query returns the query parameters of a request with the SAS token.
+func (a *azureStorage) query(query url.Values) url.Values {
+	values := url.Values{}
+	for key, value := range a.sas {
+		values[key] = value
+	}
+	for key, value := range query {
+		values[key] = value
+	}
+	return values
+}
+
+// Put stores r as the block blob name, with meta as its metadata.
+func (a *azureStorage) Put(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error {
+	if size <= a.blockSize {
+		req, err := a.newRequest(ctx, http.MethodPut, a.blobURL(name, nil), section(r, 0, size), size)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+		req.Header.Set("Content-Type", contentType(name))
+		setBlobMeta(req, meta)
+		return a.send(req)
+	}
+
+	// Blocks are streamed from r one at a time and only become
+	// the content of the blob once the block list is committed.
+	blockSize := max(a.blockSize, (size+maxAzureBlocks-1)/maxAzureBlocks)
+	var list struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string
+	}
+	for number, offset := 0, int64(0); offset < size; number, offset = number+1, offset+blockSize {
+		// Block IDs of a blob must all have the same length.
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", number)))
+		n := min(blockSize, size-offset)
+		query := url.Values{"comp": {"block"}, "blockid": {id}}
+		req, err := a.newRequest(ctx, http.MethodPut, a.blobURL(name, query), section(r, offset, n), n)
+		if err != nil {
+			return err
+		}
+		if err := a.send(req); err != nil {
+			return fmt.Errorf("put block %d: %w", number, err)
+		}
+		list.Latest = append(list.Latest, id)
+	}
+
+	body, err := xml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	req, err := a.newRequest(ctx, http.MethodPut, a.blobURL(name, url.Values{"comp": {"blocklist"}}),
+		bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Ms-Blob-Content-Type", contentType(name))
+	setBlobMeta(req, meta)
+	if err := a.send(req); err != nil {
+		return fmt.Errorf("put block list: %w", err)
+	}
+	return nil
+}
+
+func (a *azureStorage) Exists(ctx context.Context, name string) (bool, error) {
+	return exists(a.Stat(ctx, name))
+}
+
+func (a *azureStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	req, err := a.newRequest(ctx, http.MethodHead, a.blobURL(name, nil), nil, 0)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp, err := a.do(req)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return FileInfo{Name: name, Size: resp.ContentLength, Modified: modified}, nil
+}
+
+func (a *azureStorage) Delete(ctx context.Context, name string) error {
+	req, err := a.newRequest(ctx, http.MethodDelete, a.blobURL(name, nil), nil, 0)
+	if err != nil {
+		return err
+	}
+	err = a.send(req)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List pages through the blobs below the prefix with List Blobs.
+func (a *azureStorage) List(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	root := ""
+	if a.prefix != "" {
+		root = a.prefix + "/"
+	}
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {root + prefix}}
+	for {
+		target := a.container
+		target.RawQuery = a.query(query).Encode()
+		req, err := a.newRequest(ctx, http.MethodGet, target.String(), nil, 0)
+		if err != nil {
+			return err
+		}
+		resp, err := a.do(req)
+		if err != nil {
+			return fmt.Errorf("list blobs: %w", err)
+		}
+		var page struct {
+			Blobs []struct {
+				Name       string
+				Properties struct {
+					ContentLength int64  `xml:"Content-Length"`
+					LastModified  string `xml:"Last-Modified"`
+				}
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("list blobs: %w", err)
+		}
+		for _, blob := range page.Blobs {
+			modified, _ := http.ParseTime(blob.Properties.LastModified)
+			info := FileInfo{Name: strings.TrimPrefix(blob.Name, root), Size: blob.Properties.ContentLength, Modified: modified}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if page.NextMarker == "" {
+			return nil
+		}
+		query.Set("marker", page.NextMarker)
+	}
+}
+
+// newRequest returns a request of rawURL with the size bytes of body, if
+// any, as its content.
+func (a *azureStorage) newRequest(ctx context.Context, method, rawURL string, body io.Reader, size int64) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Ms-Version", azureVersion)
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	setBody(req, body, size)
+	return req, nil
+}
+
+// send is do for requests whose response carries no content.
+func (a *azureStorage) send(req *http.Request) error {
+	resp, err := a.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends req and returns responses other than 2xx as errors. The SAS
+// token is removed from the URL of transport errors.
+func (a *azureStorage) do(req *http.Request) (*http.Response, error) {
+	resp, err := a.client.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		where := *req.URL
+		where.RawQuery = ""
+		urlErr.URL = where.String()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, xmlError(resp)
+	}
+	return resp, nil
+}
+
+// setBlobMeta sets meta as the metadata of the blob written by req.
+func setBlobMeta(req *http.Request, meta map[string]string) {
+	for key, value := range meta {
+		req.Header.Set("X-Ms-Meta-"+key, value)
+	}
+}
+
+// managedIdentity is an oauth2.TokenSource of storage tokens for the
+// managed identity of the host, requested from the App Service identity
+// endpoint if IDENTITY_ENDPOINT is set and from the instance metadata
+// service otherwise.
+type managedIdentity struct {
+	client   *http.Client
+	clientID string
+}
+
+func (m *managedIdentity) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	query := url.Values{"resource": {azureResource}}
+	if m.clientID != "" {
+		query.Set("client_id", m.clientID)
+	}
+	endpoint := azureIMDSURL
+	if env := os.Getenv("IDENTITY_ENDPOINT"); env != "" {
+		endpoint = env
+		query.Set("api-version", "2019-08-01")
+	} else {
+		query.Set("api-version", "2018-02-01")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if header := os.Getenv("IDENTITY_HEADER"); header != "" {
+		req.Header.Set("X-Identity-Header", header)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("managed identity token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("managed identity token: %w", &statusError{code: resp.StatusCode, status: resp.Status})
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("managed identity token: %w", err)
+	}
+	expires, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("managed identity token: unexpected expires_on %q", token.ExpiresOn)
+	}
+	return &oauth2.Token{AccessToken: token.AccessToken, TokenType: "Bearer", Expiry: time.Unix(expires, 0)}, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// maxThrottleBurst caps the number of bytes a throttled read may
+	// return at once, so that the throughput stays smooth at high rates.
+	maxThrottleBurst = 64 << 10
+
+	// minFairChunk is the smallest share of the global burst a transfer
+	// reads while others are active.
+	minFairChunk = 4 << 10
+)
+
+// throttle limits the combined throughput of every reader it wraps. Each
+// active reader takes at most its fair share of the burst per read, and
+// since the limiter serves waiting reads in order, a single large transfer
+// cannot starve the others. A nil *throttle does not limit at all.
+type throttle struct {
+	limiter *rate.Limiter
+	active  atomic.Int32
+}
+
+// newThrottle returns a throttle for bandwidth bytes per second, or nil if
+// bandwidth is zero.
+func newThrottle(bandwidth Bandwidth) *throttle {
+	if bandwidth <= 0 {
+		return nil
+	}
+	return &throttle{limiter: newByteLimiter(bandwidth)}
+}
+
+// newByteLimiter returns a limiter whose tokens are bytes.
+func newByteLimiter(bandwidth Bandwidth) *rate.Limiter {
+	burst := int(min(int64(bandwidth), maxThrottleBurst))
+	return rate.NewLimiter(rate.Limit(bandwidth), burst)
+}
+
+// share returns the number of bytes an active reader may read at once.
+func (t *throttle) share() int {
+	return max(t.limiter.Burst()/max(int(t.active.Load()), 1), min(minFairChunk, t.limiter.Burst()))
+}
+
+// shape returns body limited by --download-bandwidth for this transfer and
+// by the --max-bandwidth throttle shared by all transfers. Waiting ends when
+// ctx is done. The returned function must be called once the transfer is
+// over.
+func (s *Scraper) shape(ctx context.Context, body io.Reader) (io.Reader, func()) {
+	r := &throttledReader{ctx: ctx, r: body, global: s.bandwidth}
+	if s.cfg.DownloadBandwidth > 0 {
+		r.own = newByteLimiter(s.cfg.DownloadBandwidth)
+	}
+	if r.own == nil && r.global == nil {
+		return body, func() {}
+	}
+	if r.global != nil {
+		r.global.active.Add(1)
+		return r, func() { r.global.active.Add(-1) }
+	}
+	return r, func() {}
+}
+
+type throttledReader struct {
+	ctx    context.Context
+	r      io.Reader
+	own    *rate.Limiter
+	global *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if r.own != nil && len(p) > r.own.Burst() {
+		p = p[:r.own.Burst()]
+	}
+	if r.global != nil {
+		if share := r.global.share(); len(p) > share {
+			p = p[:share]
+		}
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		for _, limiter := range []*rate.Limiter{r.own, r.globalLimiter()} {
+			if limiter == nil {
+				continue
+			}
+			if werr := limiter.WaitN(r.ctx, n); werr != nil && err == nil {
+				err = werr
+			}
+		}
+	}
+	return n, err
+}
+
+func (r *throttledReader) globalLimiter() *rate.Limiter {
+	if r.global == nil {
+		return nil
+	}
+	return r.global.limiter
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// maxBreakerCooldown caps the wait between two probes of a failing API.
+const maxBreakerCooldown = 5 * time.Minute
+
+// circuitBreaker keeps the directory walk from giving up, or hammering the
+// API, while the API is down. It opens when a page request still fails
+// after its retries, and then retries the page as a probe after a cooldown
+// that doubles with every failed probe. It is used by a single goroutine.
+type circuitBreaker struct {
+	cooldown time.Duration
+	// timeout is how long the breaker may stay open before the walk is
+	// aborted; 0 disables the breaker.
+	timeout time.Duration
+
+	opened time.Time // zero while the breaker is closed
+	wait   time.Duration
+}
+
+func newCircuitBreaker(cooldown, timeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{cooldown: cooldown, timeout: timeout}
+}
+
+// failed reports that the request for pageNumber failed with err. It waits
+// for the next probe and returns nil if the page should be requested again,
+// or an error if the walk has to stop.
+func (b *circuitBreaker) failed(ctx context.Context, pageNumber int, err error) error {
+	if b.timeout <= 0 || errors.Is(err, errNotFound) || !retryable(err) {
+		return err
+	}
+
+	now := time.Now()
+	if b.opened.IsZero() {
+		b.opened = now
+		b.wait = b.cooldown
+		slog.Warn("plugins API keeps failing, circuit breaker opened", "page", pageNumber, "error", err)
+	} else {
+		b.wait = min(2*b.wait, maxBreakerCooldown)
+	}
+	if open := now.Sub(b.opened); open+b.wait > b.timeout {
+		return fmt.Errorf("API did not recover within %s: %w", b.timeout, err)
+	}
+	slog.Warn("probing plugins API again", "page", pageNumber, "in", b.wait, "error", err)
+	return sleep(ctx, b.wait)
+}
+
+// succeeded closes the breaker after a successful request.
+func (b *circuitBreaker) succeeded(pageNumber int) {
+	if b.opened.IsZero() {
+		return
+	}
+	slog.Info("plugins API recovered, circuit breaker closed", "page", pageNumber, "open", time.Since(b.opened).Round(time.Second))
+	b.opened = time.Time{}
+}
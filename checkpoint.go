@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkpointFile keeps the progress of a download run in the output
+// directory until the run finishes, so that --resume can continue an
+// interrupted run instead of starting over. It is prefixed with the kind of
+// the run, such as plugins-checkpoint.json, so that plugin, theme and core
+// runs into the same directory do not share a checkpoint.
+const checkpointFile = "checkpoint.json"
+
+// checkpointInterval is how often a running download writes its checkpoint.
+const checkpointInterval = 10 * time.Second
+
+// checkpointState is the content of the checkpoint file.
+type checkpointState struct {
+	Kind string `json:"kind"`
+	// Page is the first directory page that still has plugins whose
+	// download did not finish.
+	Page      int       `json:"page,omitempty"`
+	Completed []string  `json:"completed"`
+	Updated   time.Time `json:"updated"`
+}
+
+// checkpoint tracks the directory page a download run can resume from and
+// the archives it completed. It is safe for concurrent use by the download
+// workers; a nil checkpoint tracks nothing.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	kind string
+	// pages is unset when the plugins are not queued in page order, as
+	// with --top and --sample, so that only completed archives are kept.
+	pages bool
+
+	resume    int
+	page      int
+	pending   map[string]int
+	completed map[string]bool
+}
+
+// openCheckpoint returns the checkpoint of a download run of kind in dir.
+// With resume the state of an earlier run is loaded; a missing checkpoint
+// starts a new run.
+func openCheckpoint(dir, kind string, pages, resume bool) (*checkpoint, error) {
+	c := &checkpoint{
+		path:      filepath.Join(dir, kind+"-"+checkpointFile),
+		kind:      kind,
+		pages:     pages,
+		pending:   map[string]int{},
+		completed: map[string]bool{},
+	}
+	if !resume {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("no checkpoint to resume from, starting a new run", "file", c.path)
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.path, err)
+	}
+	if pages {
+		c.resume = state.Page
+	}
+	for _, key := range state.Completed {
+		c.completed[key] = true
+	}
+	slog.Info("resuming from checkpoint", "page", c.resume, "completed", len(c.completed),
+		"updated", state.Updated)
+	return c, nil
+}
+
+// releaseKey identifies a plugin release as slug@version.
+func releaseKey(slug, version string) string {
+	return slug + "@" + version
+}
+
+// resumePage returns the directory page an interrupted run stopped at, or 0.
+func (c *checkpoint) resumePage() int {
+	if c == nil {
+		return 0
+	}
+	return c.resume
+}
+
+// enter records that the walk reached page.
+func (c *checkpoint) enter(page int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.page = page
+}
+
+// done reports whether the archive of plugin was completed before the run
+// was interrupted.
+func (c *checkpoint) done(plugin Plugin) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.completed[releaseKey(plugin.Slug, plugin.Version)]
+}
+
+// queued records that plugin of the current page was handed to a worker.
+func (c *checkpoint) queued(plugin Plugin) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[releaseKey(plugin.Slug, plugin.Version)] = c.page
+}
+
+// finished records that the download of plugin is over with err. Only
+// archives that were stored are skipped on resume. Failed plugins stay
+// pending, so that they hold the resume page back to their page and are
+// attempted again when the run resumes.
+func (c *checkpoint) finished(plugin Plugin, err error) {
+	if c == nil {
+		return
+	}
+	var skip *skipError
+	if err != nil && !errors.As(err, &skip) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := releaseKey(plugin.Slug, plugin.Version)
+	delete(c.pending, key)
+	if err == nil {
+		c.completed[key] = true
+	}
+}
+
+// save writes the checkpoint file. Downloads that were queued but did not
+// finish hold the resume page back to their directory page.
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	state := checkpointState{Kind: c.kind, Completed: make([]string, 0, len(c.completed)), Updated: time.Now()}
+	if c.pages {
+		state.Page = c.page
+		for _, page := range c.pending {
+			state.Page = min(state.Page, page)
+		}
+	}
+	for key := range c.completed {
+		state.Completed = append(state.Completed, key)
+	}
+	c.mu.Unlock()
+
+	sort.Strings(state.Completed)
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// remove deletes the checkpoint file of a run that finished.
+func (c *checkpoint) remove() error {
+	err := os.Remove(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// keep writes the checkpoint every checkpointInterval until done is closed.
+func (c *checkpoint) keep(done <-chan struct{}) {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if err := c.save(); err != nil {
+			slog.Warn("failed to write checkpoint", "file", c.path, "error", err)
+		}
+	}
+}
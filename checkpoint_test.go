@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	akismet := Plugin{Slug: "akismet", Version: "5.3.1"}
+	jetpack := Plugin{Slug: "jetpack", Version: "13.1"}
+	hello := Plugin{Slug: "hello-dolly", Version: "1.7.2"}
+
+	// Every run walks pages 1 and 2, queues akismet on page 1 and jetpack
+	// and hello-dolly on page 2, and walks on to page 3.
+	tests := []struct {
+		name       string
+		pages      bool
+		akismet    error
+		interrupt  bool
+		wantPage   int
+		wantDone   []Plugin
+		wantUndone []Plugin
+	}{
+		{name: "all stored", pages: true, wantPage: 3, wantDone: []Plugin{akismet, jetpack, hello}},
+		{
+			name: "failed download holds its page", pages: true, akismet: errors.New("status 500"),
+			wantPage: 1, wantDone: []Plugin{jetpack, hello}, wantUndone: []Plugin{akismet},
+		},
+		{
+			name: "skipped download does not", pages: true, akismet: &skipError{"archive already exists", 0},
+			wantPage: 3, wantDone: []Plugin{jetpack, hello}, wantUndone: []Plugin{akismet},
+		},
+		{
+			name: "interrupted download holds its page", pages: true, interrupt: true,
+			wantPage: 2, wantDone: []Plugin{akismet, jetpack}, wantUndone: []Plugin{hello},
+		},
+		{
+			name: "not in page order", akismet: errors.New("status 500"),
+			wantPage: 0, wantDone: []Plugin{jetpack, hello}, wantUndone: []Plugin{akismet},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c, err := openCheckpoint(dir, "plugins", tt.pages, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.enter(1)
+			c.queued(akismet)
+			c.enter(2)
+			c.queued(jetpack)
+			c.queued(hello)
+			c.enter(3)
+			c.finished(akismet, tt.akismet)
+			c.finished(jetpack, nil)
+			if !tt.interrupt {
+				c.finished(hello, nil)
+			}
+			if err := c.save(); err != nil {
+				t.Fatal(err)
+			}
+
+			resumed, err := openCheckpoint(dir, "plugins", tt.pages, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resumed.resumePage(); got != tt.wantPage {
+				t.Fatalf("resumePage() = %d, want %d", got, tt.wantPage)
+			}
+			for _, plugin := range tt.wantDone {
+				if !resumed.done(plugin) {
+					t.Errorf("%s is not done", plugin.Slug)
+				}
+			}
+			for _, plugin := range tt.wantUndone {
+				if resumed.done(plugin) {
+					t.Errorf("%s is done", plugin.Slug)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckpointWithoutFile(t *testing.T) {
+	c, err := openCheckpoint(t.TempDir(), "themes", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.resumePage() != 0 || c.done(Plugin{Slug: "twentytwentyfour", Version: "1.0"}) {
+		t.Fatal("a missing checkpoint resumed a run")
+	}
+	if err := c.remove(); err != nil {
+		t.Fatalf("remove() of a missing checkpoint = %v", err)
+	}
+	var none *checkpoint
+	if none.resumePage() != 0 || none.done(Plugin{}) {
+		t.Fatal("nil checkpoint tracks progress")
+	}
+}
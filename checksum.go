@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// checksumsFile lists the SHA-256 of every archive in the output directory
+// in the format of sha256sum, so that `sha256sum -c SHA256SUMS` verifies
+// the whole corpus.
+const checksumsFile = "SHA256SUMS"
+
+// checksums maps archive paths, relative to the output directory and with
+// forward slashes, to their hex encoded SHA-256. It is safe for concurrent
+// use by the download workers.
+type checksums struct {
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+// readChecksums loads the manifest at path, so that archives downloaded in
+// earlier runs keep their entries. A missing manifest is not an error.
+func readChecksums(path string) (*checksums, error) {
+	c := &checksums{sums: map[string]string{}}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok || len(sum) != 64 {
+			return nil, fmt.Errorf("%s:%d: malformed checksum line", path, line)
+		}
+		c.sums[name] = sum
+	}
+	return c, scanner.Err()
+}
+
+func (c *checksums) add(name, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sums[name] = sum
+}
+
+func (c *checksums) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sums, name)
+}
+
+// lookup returns the recorded SHA-256 of the archive name, or "".
+func (c *checksums) lookup(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sums[name]
+}
+
+// write stores the manifest at path, sorted by archive path.
+func (c *checksums) write(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.sums))
+	for name := range c.sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return writeAtomic(path, func(w io.Writer) error {
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "%s  %s\n", c.sums[name], name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// recordChecksum adds the SHA-256 of the archive at fileName to the
+// manifest and writes its .sha256 sidecar if --sha256-sidecars is set.
+func (s *Scraper) recordChecksum(fileName, sum string) error {
+	if s.cfg.SHA256Sidecars {
+		line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(fileName))
+		if err := writeFileAtomic(fileName+".sha256", []byte(line)); err != nil {
+			return err
+		}
+	}
+	if s.sums == nil {
+		return nil
+	}
+	rel, err := filepath.Rel(s.cfg.OutputDir, fileName)
+	if err != nil {
+		return err
+	}
+	s.sums.add(filepath.ToSlash(rel), sum)
+	return nil
+}
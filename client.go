@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Connection settings of the HTTP clients that are not configurable.
+const (
+	keepAlive       = 30 * time.Second
+	idleConnTimeout = 90 * time.Second
+)
+
+// do sends req once the pause gate and limiter allow it, and reports the
+// outcome to limiter so that --adaptive-rate can react to it. Requests
+// paced by downloadLimiter are sent with the download client, all others
+// with the API client.
+func (s *Scraper) do(limiter *rateLimiter, req *http.Request) (*http.Response, error) {
+	return s.send(s.clientFor(limiter), limiter, req)
+}
+
+// clientFor returns the client of the requests limiter paces.
+func (s *Scraper) clientFor(limiter *rateLimiter) *http.Client {
+	if limiter == s.downloadLimiter {
+		return s.downloadClient
+	}
+	return s.client
+}
+
+// send is do with client.
+func (s *Scraper) send(client *http.Client, limiter *rateLimiter, req *http.Request) (*http.Response, error) {
+	if err := s.pause.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	if err := limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	limiter.observe(resp, err, time.Since(start))
+	return resp, err
+}
+
+// get is do for a plain GET request of rawURL. Successful responses of the
+// directory API are archived with --save-responses, and answered from the
+// archive instead of the network with --replay.
+func (s *Scraper) get(ctx context.Context, limiter *rateLimiter, rawURL string) (*http.Response, error) {
+	return s.getWith(ctx, s.clientFor(limiter), limiter, rawURL)
+}
+
+// getList is get for a directory page that is decoded while its plugins
+// are processed. It is sent with the list client, which has no timeout for
+// the request as a whole; --request-timeout bounds the wait for the
+// response headers and each read of the body instead, so that the time the
+// decoder waits between reads does not count.
+func (s *Scraper) getList(ctx context.Context, rawURL string) (*http.Response, error) {
+	resp, err := s.getWith(ctx, s.listClient, s.limiter, rawURL)
+	if timeout := time.Duration(s.cfg.RequestTimeout); err == nil && timeout > 0 {
+		resp.Body = &idleBody{ReadCloser: resp.Body, timeout: timeout}
+	}
+	return resp, err
+}
+
+// getWith is get with client.
+func (s *Scraper) getWith(ctx context.Context, client *http.Client, limiter *rateLimiter, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.Replay != "" {
+		if resp, ok, err := s.replayResponse(req); ok {
+			return resp, err
+		}
+	}
+	resp, err := s.send(client, limiter, req)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		resp.Body = s.archiveResponse(rawURL, resp.Body)
+	}
+	return resp, err
+}
+
+// idleBody closes a response body whose read does not return within
+// timeout, and fails that read.
+type idleBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	expired atomic.Bool
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(b.timeout, func() {
+		b.expired.Store(true)
+		b.ReadCloser.Close()
+	})
+	n, err := b.ReadCloser.Read(p)
+	if !timer.Stop() && b.expired.Load() {
+		return n, fmt.Errorf("%w: no data for %s", os.ErrDeadlineExceeded, b.timeout)
+	}
+	return n, err
+}
+
+// newHTTPClients returns the clients of the API and the download requests
+// of a run. They share one transport, which resolves host names with
+// --dns-server, caches them for --dns-cache-ttl and keeps enough idle
+// connections per host for every worker and download segment to reuse its
+// connection. Each client limits its requests, including the response body,
+// to --request-timeout or --download-timeout, except for the list client of
+// the streamed directory pages, see getList. Its transport waits for the
+// response headers no longer than --request-timeout.
+func newHTTPClients(cfg Config) (api, list, download *http.Client) {
+	conns := cfg.Workers * max(cfg.Segments, 1)
+	resolver := newResolver(cfg.DNSServer, time.Duration(cfg.DialTimeout))
+	dialer := &net.Dialer{Timeout: time.Duration(cfg.DialTimeout), KeepAlive: keepAlive, Resolver: resolver}
+	dial := dialer.DialContext
+	if cfg.DNSCacheTTL > 0 {
+		dial = newDNSCache(resolver, time.Duration(cfg.DNSCacheTTL)).dialContext(dialer)
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   time.Duration(cfg.TLSHandshakeTimeout),
+		ResponseHeaderTimeout: time.Duration(cfg.ResponseHeaderTimeout),
+		IdleConnTimeout:       idleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          2 * conns,
+		MaxIdleConnsPerHost:   conns,
+		MaxConnsPerHost:       2 * conns,
+	}
+	api = &http.Client{Transport: transport, Timeout: time.Duration(cfg.RequestTimeout)}
+	download = &http.Client{Transport: transport, Timeout: time.Duration(cfg.DownloadTimeout)}
+	listTransport := transport
+	if timeout := time.Duration(cfg.RequestTimeout); timeout > 0 &&
+		(transport.ResponseHeaderTimeout <= 0 || transport.ResponseHeaderTimeout > timeout) {
+		listTransport = transport.Clone()
+		listTransport.ResponseHeaderTimeout = timeout
+	}
+	list = &http.Client{Transport: listTransport}
+	return api, list, download
+}
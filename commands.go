@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, s *Scraper) error
+	// writes is set for commands that write to the output directory, which
+	// they lock for the duration of the run.
+	writes bool
+}
+
+var commands = []command{
+	{"download", "download archives of all matching plugins", runDownload, true},
+	{"fetch", "write metadata of all matching plugins to plugins.json or themes.json", runFetch, true},
+	{"list", "print the plugins that match the filters", runList, false},
+	{"update", "download the new releases of the plugins updated since the previous update run", runUpdate, true},
+	{"retry-failed", "download the plugins whose download failed in earlier runs again", runRetryFailed, true},
+	{"verify", "check the archives in the output directory", runVerify, false},
+	{"prune", "remove the archives of superseded releases, keeping the latest --keep-latest of each plugin", runPrune, true},
+	{"patterns", "store the block patterns of the pattern directory", runPatterns, true},
+	{"core", "download WordPress core release archives", runCore, true},
+	{"svn", "check out or update the Subversion repositories of all matching plugins", runSVN, true},
+	{"search", "query the full-text index built by download --search-index", runSearch, false},
+	{"serve", "serve the metadata store over GraphQL", runServe, false},
+	{"ui", "serve a web UI for browsing the metadata store and archives", runUI, false},
+}
+
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: wpscraper <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'wpscraper <command> -h' to list the flags of a command.\n")
+}
+
+func runDownload(ctx context.Context, s *Scraper) error {
+	source := func(fn func(Plugin) error) error {
+		return s.each(ctx, fn)
+	}
+	if s.cfg.AllVersions {
+		source = s.allVersions(source)
+	}
+	if s.cfg.FromManifest != "" {
+		var err error
+		if source, err = s.manifestSource(s.cfg.FromManifest); err != nil {
+			return err
+		}
+	}
+	if s.cfg.DryRun {
+		return dryRun(source, "downloaded")
+	}
+	return s.downloadAll(ctx, source)
+}
+
+func runFetch(ctx context.Context, s *Scraper) error {
+	if s.cfg.DryRun {
+		return dryRun(func(fn func(Plugin) error) error {
+			return s.each(ctx, fn)
+		}, "fetched")
+	}
+	if err := os.MkdirAll(s.cfg.OutputDir, 0o755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+
+	plugins := []Plugin{}
+	err := s.each(ctx, func(plugin Plugin) error {
+		plugins = append(plugins, plugin)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(plugins, "", "  ")
+	if err != nil {
+		return err
+	}
+	fileName := filepath.Join(s.cfg.OutputDir, s.dir.metadataFile())
+	if err := writeFileAtomic(fileName, data); err != nil {
+		return err
+	}
+	slog.Info("wrote plugin metadata", "plugins", len(plugins), "file", fileName)
+	return s.publish(ctx, fileName, true, nil)
+}
+
+func runList(ctx context.Context, s *Scraper) error {
+	return s.each(ctx, func(plugin Plugin) error {
+		printPlugin(plugin)
+		return nil
+	})
+}
+
+// dryRun prints the plugins a command would process without touching the
+// output directory.
+func dryRun(source func(func(Plugin) error) error, verb string) error {
+	var count int
+	err := source(func(plugin Plugin) error {
+		count++
+		printPlugin(plugin)
+		return nil
+	})
+	slog.Info("dry run: plugins would be "+verb, "plugins", count)
+	return err
+}
+
+func printPlugin(plugin Plugin) {
+	fmt.Printf("%-50s %-15s %d\n", plugin.Slug, plugin.Version, plugin.ActiveInstalls)
+}
+
+func runVerify(ctx context.Context, s *Scraper) error {
+	var checked, failed int
+	err := filepath.WalkDir(s.cfg.OutputDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Stored objects are verified through the archives linked to them,
+		// and the zip files of Subversion working copies are not archives.
+		if d.IsDir() && (path == filepath.Join(s.cfg.OutputDir, quarantineDir) || path == filepath.Join(s.cfg.OutputDir, objectsDir) ||
+			path == filepath.Join(s.cfg.OutputDir, svnDir)) {
+			return filepath.SkipDir
+		}
+		verify := verifyArchive
+		if !d.IsDir() && strings.HasSuffix(path, repackedExt) {
+			verify = verifyRepacked
+		} else if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".zip") {
+			return nil
+		}
+
+		checked++
+		if err := verify(path); err != nil {
+			failed++
+			slog.Error("archive failed verification", "path", path, "error", err)
+			return nil
+		}
+		slog.Debug("archive verified", "path", path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("verified archives", "checked", checked, "failed", failed)
+	if failed > 0 {
+		return partialFailure(fmt.Errorf("%d of %d archives failed verification", failed, checked))
+	}
+	return nil
+}
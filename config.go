@@ -0,0 +1,802 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultMinInstalls = 1000
+	defaultWorkers     = 5
+	defaultRetries     = 3
+	defaultRateLimit   = 5
+
+	defaultDownloadRateLimit = 10
+	defaultDownloadRateBurst = 5
+
+	// defaultSegmentThreshold is the archive size from which --segments
+	// applies.
+	defaultSegmentThreshold = 50e6
+
+	defaultStallTimeout = time.Minute
+	defaultMinFreeSpace = 1e9
+	defaultS3PartSize   = 64e6
+	defaultGCSChunkSize = 16 << 20
+
+	defaultAzureBlockSize = 16e6
+	maxAzureBlockSize     = 4000 << 20
+
+	defaultDialTimeout           = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 15 * time.Second
+	defaultResponseHeaderTimeout = time.Minute
+	defaultRequestTimeout        = 2 * time.Minute
+	defaultDNSCacheTTL           = 5 * time.Minute
+
+	defaultRetryBackoff    = 2 * time.Second
+	defaultRetryBackoffMax = time.Minute
+
+	defaultBreakerCooldown = 30 * time.Second
+	defaultBreakerTimeout  = 30 * time.Minute
+
+	// maxPerPage is the largest page size the plugins API accepts.
+	maxPerPage = 250
+
+	envPrefix = "WPSCRAPER_"
+)
+
+// Config holds the settings that control a scraper run.
+type Config struct {
+	ConfigFile string `yaml:"-" toml:"-"`
+	// Args are the arguments after the flags, such as the query of search.
+	Args                  []string     `yaml:"-" toml:"-"`
+	Kind                  string       `yaml:"kind" toml:"kind"`
+	Workers               int          `yaml:"workers" toml:"workers"`
+	MinWorkers            int          `yaml:"min_workers" toml:"min_workers"`
+	Autoscale             bool         `yaml:"autoscale" toml:"autoscale"`
+	RateLimit             float64      `yaml:"rate_limit" toml:"rate_limit"`
+	RateBurst             int          `yaml:"rate_burst" toml:"rate_burst"`
+	DownloadRateLimit     float64      `yaml:"download_rate_limit" toml:"download_rate_limit"`
+	DownloadRateBurst     int          `yaml:"download_rate_burst" toml:"download_rate_burst"`
+	AdaptiveRate          bool         `yaml:"adaptive_rate" toml:"adaptive_rate"`
+	Retries               int          `yaml:"retries" toml:"retries"`
+	DownloadRetries       int          `yaml:"download_retries" toml:"download_retries"`
+	RetryBackoff          Duration     `yaml:"retry_backoff" toml:"retry_backoff"`
+	RetryBackoffMax       Duration     `yaml:"retry_backoff_max" toml:"retry_backoff_max"`
+	BreakerCooldown       Duration     `yaml:"breaker_cooldown" toml:"breaker_cooldown"`
+	BreakerTimeout        Duration     `yaml:"breaker_timeout" toml:"breaker_timeout"`
+	OutputDir             string       `yaml:"output_dir" toml:"output_dir"`
+	Output                string       `yaml:"output" toml:"output"`
+	StageArchives         bool         `yaml:"stage_archives" toml:"stage_archives"`
+	S3Region              string       `yaml:"s3_region" toml:"s3_region"`
+	S3Endpoint            string       `yaml:"s3_endpoint" toml:"s3_endpoint"`
+	S3StorageClass        string       `yaml:"s3_storage_class" toml:"s3_storage_class"`
+	S3PartSize            ByteSize     `yaml:"s3_part_size" toml:"s3_part_size"`
+	GCSCredentials        string       `yaml:"gcs_credentials" toml:"gcs_credentials"`
+	GCSChunkSize          ByteSize     `yaml:"gcs_chunk_size" toml:"gcs_chunk_size"`
+	AzureSAS              string       `yaml:"azure_sas" toml:"azure_sas"`
+	AzureClientID         string       `yaml:"azure_client_id" toml:"azure_client_id"`
+	AzureBlockSize        ByteSize     `yaml:"azure_block_size" toml:"azure_block_size"`
+	SFTPKey               string       `yaml:"sftp_key" toml:"sftp_key"`
+	SFTPKnownHosts        string       `yaml:"sftp_known_hosts" toml:"sftp_known_hosts"`
+	WebDAVUser            string       `yaml:"webdav_user" toml:"webdav_user"`
+	WebDAVPassword        string       `yaml:"webdav_password" toml:"webdav_password"`
+	WebDAVChunkSize       ByteSize     `yaml:"webdav_chunk_size" toml:"webdav_chunk_size"`
+	IPFSAPI               string       `yaml:"ipfs_api" toml:"ipfs_api"`
+	IPFSDir               string       `yaml:"ipfs_dir" toml:"ipfs_dir"`
+	Slug                  string       `yaml:"slug" toml:"slug"`
+	SlugsFile             string       `yaml:"slugs_file" toml:"slugs_file"`
+	Allowlist             string       `yaml:"allowlist" toml:"allowlist"`
+	Blocklist             string       `yaml:"blocklist" toml:"blocklist"`
+	StartPage             int          `yaml:"start_page" toml:"start_page"`
+	EndPage               int          `yaml:"end_page" toml:"end_page"`
+	PrefetchPages         int          `yaml:"prefetch_pages" toml:"prefetch_pages"`
+	MaxDownloads          int          `yaml:"max_downloads" toml:"max_downloads"`
+	MaxZipSize            ByteSize     `yaml:"max_zip_size" toml:"max_zip_size"`
+	MaxBandwidth          Bandwidth    `yaml:"max_bandwidth" toml:"max_bandwidth"`
+	MaxInFlight           ByteSize     `yaml:"max_in_flight" toml:"max_in_flight"`
+	MaxFailures           FailureLimit `yaml:"max_failures" toml:"max_failures"`
+	DownloadBandwidth     Bandwidth    `yaml:"download_bandwidth" toml:"download_bandwidth"`
+	Preallocate           bool         `yaml:"preallocate" toml:"preallocate"`
+	DNSServer             string       `yaml:"dns_server" toml:"dns_server"`
+	DNSCacheTTL           Duration     `yaml:"dns_cache_ttl" toml:"dns_cache_ttl"`
+	IfExists              string       `yaml:"if_exists" toml:"if_exists"`
+	Segments              int          `yaml:"segments" toml:"segments"`
+	StallTimeout          Duration     `yaml:"stall_timeout" toml:"stall_timeout"`
+	DialTimeout           Duration     `yaml:"dial_timeout" toml:"dial_timeout"`
+	TLSHandshakeTimeout   Duration     `yaml:"tls_handshake_timeout" toml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout Duration     `yaml:"response_header_timeout" toml:"response_header_timeout"`
+	RequestTimeout        Duration     `yaml:"request_timeout" toml:"request_timeout"`
+	DownloadTimeout       Duration     `yaml:"download_timeout" toml:"download_timeout"`
+	MinSpeed              ByteSize     `yaml:"min_speed" toml:"min_speed"`
+	SegmentThreshold      ByteSize     `yaml:"segment_threshold" toml:"segment_threshold"`
+	SHA256Sidecars        bool         `yaml:"sha256_sidecars" toml:"sha256_sidecars"`
+	MetadataSidecars      bool         `yaml:"metadata_sidecars" toml:"metadata_sidecars"`
+	MetadataJSONL         bool         `yaml:"metadata_jsonl" toml:"metadata_jsonl"`
+	Export                []string     `yaml:"export" toml:"export"`
+	ExportColumns         []string     `yaml:"export_columns" toml:"export_columns"`
+	ElasticsearchAPIKey   string       `yaml:"elasticsearch_api_key" toml:"elasticsearch_api_key"`
+	SearchIndex           bool         `yaml:"search_index" toml:"search_index"`
+	SearchResults         int          `yaml:"search_results" toml:"search_results"`
+	MetadataStore         string       `yaml:"metadata_store" toml:"metadata_store"`
+	Listen                string       `yaml:"listen" toml:"listen"`
+	Dedupe                string       `yaml:"dedupe" toml:"dedupe"`
+	Torrent               string       `yaml:"torrent" toml:"torrent"`
+	Repack                string       `yaml:"repack" toml:"repack"`
+	ZstdLevel             int          `yaml:"zstd_level" toml:"zstd_level"`
+	TorrentTrackers       []string     `yaml:"torrent_trackers" toml:"torrent_trackers"`
+	TorrentWebSeeds       []string     `yaml:"torrent_web_seeds" toml:"torrent_web_seeds"`
+	TorrentPieceSize      ByteSize     `yaml:"torrent_piece_size" toml:"torrent_piece_size"`
+	VerifyChecksums       bool         `yaml:"verify_checksums" toml:"verify_checksums"`
+	CoreVersions          []string     `yaml:"core_versions" toml:"core_versions"`
+	LanguagePacks         []string     `yaml:"language_packs" toml:"language_packs"`
+	Top                   int          `yaml:"top" toml:"top"`
+	Enrich                bool         `yaml:"enrich" toml:"enrich"`
+	AllVersions           bool         `yaml:"all_versions" toml:"all_versions"`
+	MaxVersions           int          `yaml:"max_versions" toml:"max_versions"`
+	KeepLatest            int          `yaml:"keep_latest" toml:"keep_latest"`
+	OlderThan             Duration     `yaml:"older_than" toml:"older_than"`
+	EnrichRateLimit       float64      `yaml:"enrich_rate_limit" toml:"enrich_rate_limit"`
+	Sample                int          `yaml:"sample" toml:"sample"`
+	Seed                  int64        `yaml:"seed" toml:"seed"`
+	DryRun                bool         `yaml:"dry_run" toml:"dry_run"`
+	SaveResponses         string       `yaml:"save_responses" toml:"save_responses"`
+	Replay                string       `yaml:"replay" toml:"replay"`
+	FromManifest          string       `yaml:"from_manifest" toml:"from_manifest"`
+	LockWait              Duration     `yaml:"lock_wait" toml:"lock_wait"`
+	SelfCheck             bool         `yaml:"self_check" toml:"self_check"`
+	MinFreeSpace          ByteSize     `yaml:"min_free_space" toml:"min_free_space"`
+	Resume                bool         `yaml:"resume" toml:"resume"`
+	LogLevel              string       `yaml:"log_level" toml:"log_level"`
+	LogFormat             string       `yaml:"log_format" toml:"log_format"`
+	NameTemplate          string       `yaml:"name_template" toml:"name_template"`
+	Layout                string       `yaml:"layout" toml:"layout"`
+	Query                 QueryConfig  `yaml:"query" toml:"query"`
+	Filters               FilterConfig `yaml:"filters" toml:"filters"`
+}
+
+// QueryConfig narrows down the plugin directory on the server side.
+type QueryConfig struct {
+	PerPage int             `yaml:"per_page" toml:"per_page"`
+	Browse  string          `yaml:"browse" toml:"browse"`
+	Search  string          `yaml:"search" toml:"search"`
+	Tag     string          `yaml:"tag" toml:"tag"`
+	Author  string          `yaml:"author" toml:"author"`
+	Fields  map[string]bool `yaml:"fields" toml:"fields"`
+	Locale  string          `yaml:"locale" toml:"locale"`
+}
+
+// localePattern matches WordPress locale codes such as de, de_DE or
+// de_DE_formal.
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?(_[a-z]+)?$`)
+
+// existsPolicies are the values of --if-exists.
+var existsPolicies = []string{"skip", "overwrite", "rename", "verify"}
+
+// browseModes are the values the API accepts for request[browse].
+var browseModes = []string{"popular", "new", "updated", "featured"}
+
+// FilterConfig holds the criteria a plugin must meet to be selected.
+type FilterConfig struct {
+	MinInstalls   int      `yaml:"min_installs" toml:"min_installs"`
+	MinRating     int      `yaml:"min_rating" toml:"min_rating"`
+	MinNumRatings int      `yaml:"min_num_ratings" toml:"min_num_ratings"`
+	UpdatedWithin Duration `yaml:"updated_within" toml:"updated_within"`
+	IncludeTags   []string `yaml:"include_tags" toml:"include_tags"`
+	ExcludeTags   []string `yaml:"exclude_tags" toml:"exclude_tags"`
+	RequiresWPMax string   `yaml:"requires_wp_max" toml:"requires_wp_max"`
+	TestedMin     string   `yaml:"tested_min" toml:"tested_min"`
+	RequiresPHP   string   `yaml:"requires_php" toml:"requires_php"`
+	SlugMatch     string   `yaml:"slug_match" toml:"slug_match"`
+	SlugExclude   string   `yaml:"slug_exclude" toml:"slug_exclude"`
+	Expression    string   `yaml:"expression" toml:"expression"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Kind:                  "plugins",
+		Workers:               defaultWorkers,
+		MinWorkers:            1,
+		RateLimit:             defaultRateLimit,
+		RateBurst:             1,
+		DownloadRateLimit:     defaultDownloadRateLimit,
+		DownloadRateBurst:     defaultDownloadRateBurst,
+		EnrichRateLimit:       defaultRateLimit,
+		Retries:               defaultRetries,
+		DownloadRetries:       defaultRetries,
+		RetryBackoff:          Duration(defaultRetryBackoff),
+		RetryBackoffMax:       Duration(defaultRetryBackoffMax),
+		BreakerCooldown:       Duration(defaultBreakerCooldown),
+		BreakerTimeout:        Duration(defaultBreakerTimeout),
+		OutputDir:             ".",
+		StartPage:             1,
+		LogLevel:              "info",
+		LogFormat:             "text",
+		IfExists:              "overwrite",
+		Dedupe:                "off",
+		Torrent:               "off",
+		ExportColumns:         defaultCSVColumns,
+		SearchResults:         20,
+		KeepLatest:            3,
+		Listen:                "localhost:8080",
+		Repack:                "off",
+		ZstdLevel:             3,
+		Segments:              1,
+		DNSCacheTTL:           Duration(defaultDNSCacheTTL),
+		StallTimeout:          Duration(defaultStallTimeout),
+		DialTimeout:           Duration(defaultDialTimeout),
+		TLSHandshakeTimeout:   Duration(defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: Duration(defaultResponseHeaderTimeout),
+		RequestTimeout:        Duration(defaultRequestTimeout),
+		SegmentThreshold:      defaultSegmentThreshold,
+		NameTemplate:          defaultNameTemplate,
+		Layout:                "flat",
+		SelfCheck:             true,
+		MinFreeSpace:          defaultMinFreeSpace,
+		S3PartSize:            defaultS3PartSize,
+		GCSChunkSize:          defaultGCSChunkSize,
+		AzureBlockSize:        defaultAzureBlockSize,
+		IPFSDir:               "/wpscraper",
+		CoreVersions:          []string{"latest"},
+		Filters: FilterConfig{
+			MinInstalls: defaultMinInstalls,
+		},
+	}
+}
+
+func newFlagSet(name string, cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("wpscraper "+name, flag.ContinueOnError)
+	fs.StringVar(&cfg.ConfigFile, "config", cfg.ConfigFile, "path to a YAML or TOML configuration file")
+	fs.StringVar(&cfg.Kind, "kind", cfg.Kind, "directory to scrape: "+directoryNames())
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "number of concurrent download workers, the maximum with --autoscale")
+	fs.BoolVar(&cfg.Autoscale, "autoscale", cfg.Autoscale, "adjust the number of workers to the observed throughput, errors and queue")
+	fs.IntVar(&cfg.MinWorkers, "min-workers", cfg.MinWorkers, "smallest number of workers with --autoscale")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "maximum API requests per second (0 disables the limit)")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "number of API requests that may exceed --rate-limit in a burst")
+	fs.Float64Var(&cfg.DownloadRateLimit, "download-rate-limit", cfg.DownloadRateLimit, "maximum archive download requests per second (0 disables the limit)")
+	fs.IntVar(&cfg.DownloadRateBurst, "download-rate-burst", cfg.DownloadRateBurst, "number of download requests that may exceed --download-rate-limit in a burst")
+	fs.BoolVar(&cfg.AdaptiveRate, "adaptive-rate", cfg.AdaptiveRate, "slow down automatically on throttling, server errors or rising latency")
+	fs.IntVar(&cfg.Retries, "retries", cfg.Retries, "number of attempts for each plugin list request")
+	fs.IntVar(&cfg.DownloadRetries, "download-retries", cfg.DownloadRetries, "number of attempts for each archive download")
+	fs.Var(&cfg.RetryBackoff, "retry-backoff", "wait before retrying a failed download, doubled for every further attempt")
+	fs.Var(&cfg.RetryBackoffMax, "retry-backoff-max", "longest wait between download attempts")
+	fs.Var(&cfg.BreakerCooldown, "breaker-cooldown", "wait before probing a failing API again, doubled for every failed probe")
+	fs.Var(&cfg.BreakerTimeout, "breaker-timeout", "stop the walk if the API did not recover within this time (0 stops at the first failed page)")
+	fs.StringVar(&cfg.OutputDir, "output-dir", cfg.OutputDir, "directory to write plugin archives to")
+	fs.StringVar(&cfg.Output, "output", cfg.Output, "upload archives and metadata to this target, e.g. s3://bucket/prefix, sftp://user@host/path, file:///mnt/corpus or tar://-, streaming archives there as they download")
+	fs.BoolVar(&cfg.StageArchives, "stage-archives", cfg.StageArchives, "stage archives in the output directory before uploading them to output, instead of streaming them")
+	fs.StringVar(&cfg.S3Region, "s3-region", cfg.S3Region, "region of the S3 bucket (default AWS_REGION or us-east-1)")
+	fs.StringVar(&cfg.S3Endpoint, "s3-endpoint", cfg.S3Endpoint, "URL of an S3 compatible service, addressed with path-style requests")
+	fs.StringVar(&cfg.S3StorageClass, "s3-storage-class", cfg.S3StorageClass, "storage class of uploaded objects, e.g. STANDARD_IA or GLACIER_IR (default the bucket default)")
+	fs.Var(&cfg.S3PartSize, "s3-part-size", "upload files larger than this to S3 in parts of this size, e.g. 64MB")
+	fs.StringVar(&cfg.GCSCredentials, "gcs-credentials", cfg.GCSCredentials, "service account key file for gs:// outputs (default the application default credentials)")
+	fs.Var(&cfg.GCSChunkSize, "gcs-chunk-size", "size of the chunks of resumable uploads to GCS, a multiple of 256KiB")
+	fs.StringVar(&cfg.AzureSAS, "azure-sas", cfg.AzureSAS, "SAS token for azure:// outputs (default AZURE_STORAGE_SAS_TOKEN, or else the managed identity)")
+	fs.StringVar(&cfg.AzureClientID, "azure-client-id", cfg.AzureClientID, "client ID of the user-assigned managed identity for azure:// outputs")
+	fs.Var(&cfg.AzureBlockSize, "azure-block-size", "upload files larger than this to Azure in blocks of this size, e.g. 16MB")
+	fs.StringVar(&cfg.SFTPKey, "sftp-key", cfg.SFTPKey, "private key for sftp:// outputs (default the SSH agent and the keys in ~/.ssh)")
+	fs.StringVar(&cfg.SFTPKnownHosts, "sftp-known-hosts", cfg.SFTPKnownHosts, "known_hosts file the host key of sftp:// outputs is checked against (default ~/.ssh/known_hosts)")
+	fs.StringVar(&cfg.WebDAVUser, "webdav-user", cfg.WebDAVUser, "user name for webdav:// outputs (default the user of the URL)")
+	fs.StringVar(&cfg.WebDAVPassword, "webdav-password", cfg.WebDAVPassword, "password or app token for webdav:// outputs, best set as "+envName("webdav-password"))
+	fs.Var(&cfg.WebDAVChunkSize, "webdav-chunk-size", "upload files larger than this to Nextcloud in chunks of this size, e.g. 10MB (0 disables chunking)")
+	fs.StringVar(&cfg.IPFSAPI, "ipfs-api", cfg.IPFSAPI, "add archives to the IPFS node with this Kubo RPC API, e.g. http://127.0.0.1:5001")
+	fs.StringVar(&cfg.IPFSDir, "ipfs-dir", cfg.IPFSDir, "MFS directory of the IPFS node to copy the archives into, whose CID is the root of the snapshot (empty to skip)")
+	fs.StringVar(&cfg.NameTemplate, "name-template", cfg.NameTemplate, "Go template for archive paths below the output directory")
+	fs.StringVar(&cfg.Layout, "layout", cfg.Layout, "directories to put the archive paths in: "+strings.Join(layouts, ", "))
+	fs.StringVar(&cfg.Slug, "slug", cfg.Slug, "process only the plugin with this slug instead of walking the directory")
+	fs.StringVar(&cfg.SlugsFile, "slugs-file", cfg.SlugsFile, "process only the slugs listed in this file, one per line (- reads stdin)")
+	fs.StringVar(&cfg.Allowlist, "allowlist", cfg.Allowlist, "file of slugs, one per line, that the run is restricted to")
+	fs.StringVar(&cfg.Blocklist, "blocklist", cfg.Blocklist, "file of slugs, one per line, that are never processed")
+	fs.IntVar(&cfg.StartPage, "start-page", cfg.StartPage, "first directory page to process")
+	fs.IntVar(&cfg.EndPage, "end-page", cfg.EndPage, "last directory page to process (0 processes every page)")
+	fs.IntVar(&cfg.PrefetchPages, "prefetch-pages", cfg.PrefetchPages, "number of directory pages to request ahead of the one being processed")
+	fs.IntVar(&cfg.MaxDownloads, "max-downloads", cfg.MaxDownloads, "stop after selecting this many plugins (0 means no limit)")
+	fs.Var(&cfg.MaxFailures, "max-failures", "abort the run once more downloads failed than this count or percentage, e.g. 50 or 5% (0 means no limit)")
+	fs.BoolVar(&cfg.Enrich, "enrich", cfg.Enrich, "fetch the full plugin_information metadata of every selected plugin")
+	fs.BoolVar(&cfg.AllVersions, "all-versions", cfg.AllVersions, "download every release of the versions map of each plugin, not only the current one")
+	fs.IntVar(&cfg.MaxVersions, "max-versions", cfg.MaxVersions, "with --all-versions, download at most this many of the newest releases of each plugin (0 for all)")
+	fs.IntVar(&cfg.KeepLatest, "keep-latest", cfg.KeepLatest, "number of the newest releases of each plugin that prune keeps")
+	fs.Var(&cfg.OlderThan, "older-than", "only prune archives stored longer ago than this, e.g. 1y (0 prunes regardless of age)")
+	fs.Float64Var(&cfg.EnrichRateLimit, "enrich-rate-limit", cfg.EnrichRateLimit, "maximum plugin_information requests per second for --enrich (0 disables the limit)")
+	fs.Var(newListValue(&cfg.LanguagePacks), "language-packs", "comma-separated locales whose language packs are stored next to each archive, or all")
+	fs.Var(newListValue(&cfg.CoreVersions), "core-versions", "comma-separated WordPress core releases for the core command: latest, all or version numbers")
+	fs.IntVar(&cfg.Top, "top", cfg.Top, "select only the N matching plugins with the most active installs (0 selects all)")
+	fs.IntVar(&cfg.Sample, "sample", cfg.Sample, "select a random sample of N matching plugins (0 selects all)")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed for --sample (0 picks and logs a new seed)")
+	fs.Var(&cfg.MaxBandwidth, "max-bandwidth", "combined download bandwidth of all workers, e.g. 20MB/s (0 means no limit)")
+	fs.Var(&cfg.MaxInFlight, "max-in-flight", "bytes all running downloads together may still receive before new ones wait, e.g. 500MB (0 means no limit)")
+	fs.Var(&cfg.DownloadBandwidth, "download-bandwidth", "bandwidth of each single download, e.g. 2MB/s (0 means no limit)")
+	fs.BoolVar(&cfg.Preallocate, "preallocate", cfg.Preallocate, "reserve disk space for archives of known size before downloading them (Linux only)")
+	fs.StringVar(&cfg.DNSServer, "dns-server", cfg.DNSServer, "DNS server to resolve host names with, e.g. 1.1.1.1 or 9.9.9.9:53 (default the system resolver)")
+	fs.Var(&cfg.DNSCacheTTL, "dns-cache-ttl", "how long resolved addresses are reused (0 disables the DNS cache)")
+	fs.Var(&cfg.MaxZipSize, "max-zip-size", "skip plugins whose archive is larger than this, e.g. 50MB (0 means no limit)")
+	fs.IntVar(&cfg.Segments, "segments", cfg.Segments, "download large archives with this many parallel range requests (1 disables segmenting)")
+	fs.Var(&cfg.SegmentThreshold, "segment-threshold", "archive size from which --segments applies, e.g. 50MB")
+	fs.Var(&cfg.StallTimeout, "stall-timeout", "abort downloads that receive no data for this long, e.g. 30s (0 disables the check)")
+	fs.Var(&cfg.DialTimeout, "dial-timeout", "longest wait for a TCP connection to be established (0 disables the timeout)")
+	fs.Var(&cfg.TLSHandshakeTimeout, "tls-handshake-timeout", "longest wait for a TLS handshake (0 disables the timeout)")
+	fs.Var(&cfg.ResponseHeaderTimeout, "response-header-timeout", "longest wait for the response headers after a request was sent (0 disables the timeout)")
+	fs.Var(&cfg.RequestTimeout, "request-timeout", "longest time an API request may take, including reading the response (0 disables the timeout)")
+	fs.Var(&cfg.DownloadTimeout, "download-timeout", "longest time a download request may take, including the transfer (0 disables the timeout)")
+	fs.Var(&cfg.MinSpeed, "min-speed", fmt.Sprintf("abort downloads slower than this many bytes per second over %s, e.g. 10KB (0 disables the check)", minSpeedWindow))
+	fs.StringVar(&cfg.IfExists, "if-exists", cfg.IfExists, "what to do with archives that already exist: "+strings.Join(existsPolicies, ", "))
+	fs.BoolVar(&cfg.SHA256Sidecars, "sha256-sidecars", cfg.SHA256Sidecars, "write a .sha256 file next to every downloaded archive")
+	fs.BoolVar(&cfg.MetadataSidecars, "metadata-sidecars", cfg.MetadataSidecars, "write a .json file with the API metadata, download time and hash next to every downloaded archive")
+	fs.Var(newListValue(&cfg.Export), "export", "comma-separated format=file exports of the plugin metadata written during the run, e.g. csv=plugins.csv (formats: "+strings.Join(exportFormatNames(), ", ")+")")
+	fs.Var(newListValue(&cfg.ExportColumns), "export-columns", "comma-separated columns of CSV exports: "+strings.Join(csvColumnNames(), ", "))
+	fs.StringVar(&cfg.ElasticsearchAPIKey, "elasticsearch-api-key", cfg.ElasticsearchAPIKey, "API key of elasticsearch and opensearch exports, instead of credentials in the URL")
+	fs.BoolVar(&cfg.SearchIndex, "search-index", cfg.SearchIndex, "index names, descriptions and readmes in "+searchIndexDir+" in the output directory for the search command")
+	fs.IntVar(&cfg.SearchResults, "search-results", cfg.SearchResults, "number of plugins the search command prints")
+	fs.StringVar(&cfg.MetadataStore, "metadata-store", cfg.MetadataStore, "metadata database the serve command queries, sqlite=FILE or postgres=URL (default the first sqlite or postgres export)")
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "address the serve command listens on")
+	fs.BoolVar(&cfg.MetadataJSONL, "metadata-jsonl", cfg.MetadataJSONL, "append the metadata and outcome of every plugin to "+metadataFile+" during the run")
+	fs.StringVar(&cfg.Torrent, "torrent", cfg.Torrent, "build .torrent files of the downloaded archives after the run: "+strings.Join(torrentModes, ", "))
+	fs.Var(newListValue(&cfg.TorrentTrackers), "torrent-trackers", "comma-separated tracker announce URLs of the torrents")
+	fs.Var(newListValue(&cfg.TorrentWebSeeds), "torrent-web-seeds", "comma-separated base URLs the output directory is served from, added to the torrents as web seeds")
+	fs.Var(&cfg.TorrentPieceSize, "torrent-piece-size", "piece size of the torrents, a power of two such as 1MB (0 picks one from the size)")
+	fs.StringVar(&cfg.Repack, "repack", cfg.Repack, "repack downloaded archives to save space, keeping the hash of the zip: "+strings.Join(repackModes, ", "))
+	fs.IntVar(&cfg.ZstdLevel, "zstd-level", cfg.ZstdLevel, "zstd compression level of --repack, from 1 to 22")
+	fs.StringVar(&cfg.Dedupe, "dedupe", cfg.Dedupe, "store archives once by content below objects/ and link their names to them: "+strings.Join(dedupeModes, ", "))
+	fs.BoolVar(&cfg.VerifyChecksums, "verify-checksums", cfg.VerifyChecksums, "check downloaded plugins against the checksums published by WordPress.org")
+	fs.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "print the plugins that would be processed without writing any files")
+	fs.StringVar(&cfg.SaveResponses, "save-responses", cfg.SaveResponses, "directory to store every raw directory API response in, gzip compressed")
+	fs.StringVar(&cfg.FromManifest, "from-manifest", cfg.FromManifest, "download exactly the archives listed in this manifest of an earlier run")
+	fs.StringVar(&cfg.Replay, "replay", cfg.Replay, "answer directory API requests from the responses stored in this directory by --save-responses")
+	fs.BoolVar(&cfg.Resume, "resume", cfg.Resume, "continue an interrupted download run from its checkpoint")
+	fs.BoolVar(&cfg.SelfCheck, "self-check", cfg.SelfCheck, "probe the API, the output directory and the free disk space before the run")
+	fs.Var(&cfg.MinFreeSpace, "min-free-space", "disk space the output directory needs to have free for the self-check to pass (0 disables the check)")
+	fs.Var(&cfg.LockWait, "lock-wait", "wait up to this long for another run to release the output directory (0 exits right away)")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimum log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log output format: text or json")
+	fs.IntVar(&cfg.Query.PerPage, "per-page", cfg.Query.PerPage, fmt.Sprintf("plugins per API page, at most %d (0 uses the API default)", maxPerPage))
+	fs.StringVar(&cfg.Query.Browse, "browse", cfg.Query.Browse, "enumerate a browse listing: "+strings.Join(browseModes, ", "))
+	fs.StringVar(&cfg.Query.Search, "search", cfg.Query.Search, "only enumerate plugins matching this search term")
+	fs.StringVar(&cfg.Query.Tag, "tag", cfg.Query.Tag, "only enumerate plugins with this tag (filtered by the API)")
+	fs.StringVar(&cfg.Query.Author, "author", cfg.Query.Author, "only enumerate plugins published by this author")
+	fs.StringVar(&cfg.Query.Locale, "locale", cfg.Query.Locale, "fetch descriptions and sections in this locale, e.g. de_DE")
+	fs.Var(newFieldsValue(&cfg.Query.Fields), "fields", "comma-separated API response fields to enable, or disable with a leading -, e.g. icons,-sections")
+	fs.IntVar(&cfg.Filters.MinInstalls, "min-installs", cfg.Filters.MinInstalls, "minimum active installs a plugin needs to be downloaded")
+	fs.IntVar(&cfg.Filters.MinRating, "min-rating", cfg.Filters.MinRating, "minimum rating (0-100) a plugin needs to be downloaded")
+	fs.IntVar(&cfg.Filters.MinNumRatings, "min-num-ratings", cfg.Filters.MinNumRatings, "minimum number of ratings a plugin needs to be downloaded")
+	fs.Var(&cfg.Filters.UpdatedWithin, "updated-within", "only select plugins updated within this period, e.g. 180d (0 disables the filter)")
+	fs.Var(newListValue(&cfg.Filters.IncludeTags), "include-tags", "comma-separated tags of which a plugin needs at least one")
+	fs.Var(newListValue(&cfg.Filters.ExcludeTags), "exclude-tags", "comma-separated tags that exclude a plugin")
+	fs.StringVar(&cfg.Filters.RequiresWPMax, "requires-wp-max", cfg.Filters.RequiresWPMax, "only select plugins that require at most this WordPress version")
+	fs.StringVar(&cfg.Filters.TestedMin, "tested-min", cfg.Filters.TestedMin, "only select plugins tested with at least this WordPress version")
+	fs.StringVar(&cfg.Filters.SlugMatch, "slug-match", cfg.Filters.SlugMatch, "only select plugins whose slug matches this regular expression")
+	fs.StringVar(&cfg.Filters.SlugExclude, "slug-exclude", cfg.Filters.SlugExclude, "skip plugins whose slug matches this regular expression")
+	fs.StringVar(&cfg.Filters.Expression, "filter", cfg.Filters.Expression, "boolean expression over the plugin metadata, e.g. 'rating >= 80 && \"security\" in tags'")
+	fs.StringVar(&cfg.Filters.RequiresPHP, "requires-php", cfg.Filters.RequiresPHP, "only select plugins that still run on this PHP version, e.g. 5.6")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: wpscraper %s [flags]\n\nFlags:\n", name)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery flag can also be set through an environment variable named after it,\n"+
+			"e.g. --output-dir as %s.\n", envName("output-dir"))
+	}
+	return fs
+}
+
+// listValue is a flag.Value for comma-separated lists. The first value given
+// replaces the list, so that flags override lists from the environment or
+// the configuration file, and repeated flags add to it.
+type listValue struct {
+	p   *[]string
+	set bool
+}
+
+func newListValue(p *[]string) *listValue {
+	return &listValue{p: p}
+}
+
+func (v *listValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+
+func (v *listValue) Set(s string) error {
+	if !v.set {
+		*v.p = nil
+		v.set = true
+	}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v.p = append(*v.p, item)
+		}
+	}
+	return nil
+}
+
+// fieldsValue is a flag.Value for the API field switches. "name" enables
+// a field and "-name" disables it. Like listValue, the first value given
+// replaces the switches from the environment or the configuration file.
+type fieldsValue struct {
+	p   *map[string]bool
+	set bool
+}
+
+func newFieldsValue(p *map[string]bool) *fieldsValue {
+	return &fieldsValue{p: p}
+}
+
+func (v *fieldsValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	var items []string
+	for name, enabled := range *v.p {
+		if !enabled {
+			name = "-" + name
+		}
+		items = append(items, name)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+func (v *fieldsValue) Set(s string) error {
+	if !v.set || *v.p == nil {
+		*v.p = map[string]bool{}
+		v.set = true
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, disabled := strings.CutPrefix(item, "-")
+		name = strings.TrimPrefix(name, "+")
+		(*v.p)[name] = !disabled
+	}
+	return nil
+}
+
+// envName returns the environment variable that overrides the named flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag of fs that has a matching environment variable.
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %w", envName(f.Name), setErr)
+			}
+		}
+	})
+	return err
+}
+
+// loadConfig resolves the configuration of the named command. Settings are
+// applied in order of increasing precedence: defaults, WPSCRAPER_*
+// environment variables, the configuration file and command line flags.
+func loadConfig(name string, args []string) (Config, error) {
+	cfg := defaultConfig()
+	fs := newFlagSet(name, &cfg)
+	if err := applyEnv(fs); err != nil {
+		return cfg, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	resolved := defaultConfig()
+	if err := applyEnv(newFlagSet(name, &resolved)); err != nil {
+		return resolved, err
+	}
+	if cfg.ConfigFile != "" {
+		if err := readConfigFile(cfg.ConfigFile, &resolved); err != nil {
+			return resolved, err
+		}
+	}
+
+	// Parse a second time on top of the file values so that only the flags
+	// given explicitly take precedence over them.
+	flags := newFlagSet(name, &resolved)
+	if err := flags.Parse(args); err != nil {
+		return resolved, err
+	}
+	resolved.Args = flags.Args()
+	return resolved, resolved.validate()
+}
+
+func readConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parse config %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("parse config %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parse config %s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("config %s: unsupported format %q (use .yaml, .yml or .toml)", path, ext)
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	if _, ok := lookupDirectory(c.Kind); !ok {
+		return fmt.Errorf("kind must be one of %s, got %q", directoryNames(), c.Kind)
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", c.Workers)
+	}
+	if c.MinWorkers < 1 || c.MinWorkers > c.Workers {
+		return fmt.Errorf("min-workers must be between 1 and workers (%d), got %d", c.Workers, c.MinWorkers)
+	}
+	if c.Retries < 1 {
+		return fmt.Errorf("retries must be at least 1, got %d", c.Retries)
+	}
+	if c.DownloadRetries < 1 {
+		return fmt.Errorf("download-retries must be at least 1, got %d", c.DownloadRetries)
+	}
+	if c.RetryBackoff < 0 || c.RetryBackoffMax < 0 {
+		return fmt.Errorf("retry-backoff and retry-backoff-max must not be negative")
+	}
+	if c.BreakerCooldown <= 0 {
+		return fmt.Errorf("breaker-cooldown must be positive, got %s", c.BreakerCooldown)
+	}
+	if c.LockWait < 0 {
+		return fmt.Errorf("lock-wait must not be negative, got %s", c.LockWait)
+	}
+	if c.BreakerTimeout < 0 {
+		return fmt.Errorf("breaker-timeout must not be negative, got %s", c.BreakerTimeout)
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rate-limit must not be negative, got %g", c.RateLimit)
+	}
+	if c.DownloadRateLimit < 0 {
+		return fmt.Errorf("download-rate-limit must not be negative, got %g", c.DownloadRateLimit)
+	}
+	if c.RateBurst < 1 || c.DownloadRateBurst < 1 {
+		return fmt.Errorf("rate-burst and download-rate-burst must be at least 1")
+	}
+	if c.StartPage < 1 {
+		return fmt.Errorf("start-page must be at least 1, got %d", c.StartPage)
+	}
+	if c.EndPage != 0 && c.EndPage < c.StartPage {
+		return fmt.Errorf("end-page %d is before start-page %d", c.EndPage, c.StartPage)
+	}
+	if c.PrefetchPages < 0 {
+		return fmt.Errorf("prefetch-pages must not be negative, got %d", c.PrefetchPages)
+	}
+	if c.MaxDownloads < 0 {
+		return fmt.Errorf("max-downloads must not be negative, got %d", c.MaxDownloads)
+	}
+	if c.Filters.MinRating < 0 || c.Filters.MinRating > 100 {
+		return fmt.Errorf("min-rating must be between 0 and 100, got %d", c.Filters.MinRating)
+	}
+	if c.Filters.MinNumRatings < 0 {
+		return fmt.Errorf("min-num-ratings must not be negative, got %d", c.Filters.MinNumRatings)
+	}
+	if c.Filters.UpdatedWithin < 0 {
+		return fmt.Errorf("updated-within must not be negative, got %s", c.Filters.UpdatedWithin)
+	}
+	for _, v := range []struct{ name, value string }{
+		{"requires-wp-max", c.Filters.RequiresWPMax},
+		{"tested-min", c.Filters.TestedMin},
+		{"requires-php", c.Filters.RequiresPHP},
+	} {
+		if v.value != "" && !isVersion(v.value) {
+			return fmt.Errorf("%s must be a version such as 6.4, got %q", v.name, v.value)
+		}
+	}
+	if _, err := compilePattern("slug-match", c.Filters.SlugMatch); err != nil {
+		return err
+	}
+	if _, err := compilePattern("slug-exclude", c.Filters.SlugExclude); err != nil {
+		return err
+	}
+	if c.Top < 0 {
+		return fmt.Errorf("top must not be negative, got %d", c.Top)
+	}
+	if c.Sample < 0 {
+		return fmt.Errorf("sample must not be negative, got %d", c.Sample)
+	}
+	if c.Top > 0 && c.Sample > 0 {
+		return fmt.Errorf("top and sample cannot be combined")
+	}
+	if c.MaxVersions < 0 {
+		return fmt.Errorf("max-versions must not be negative, got %d", c.MaxVersions)
+	}
+	if c.MaxVersions > 0 && !c.AllVersions {
+		return fmt.Errorf("max-versions requires all-versions")
+	}
+	if c.KeepLatest < 1 {
+		return fmt.Errorf("keep-latest must be at least 1, got %d", c.KeepLatest)
+	}
+	if c.OlderThan < 0 {
+		return fmt.Errorf("older-than must not be negative, got %s", c.OlderThan)
+	}
+	if c.AllVersions && c.FromManifest != "" {
+		return fmt.Errorf("all-versions cannot be combined with from-manifest, which lists the releases to download")
+	}
+	if _, err := compileFilter(c.Filters.Expression); err != nil {
+		return err
+	}
+	if c.Query.PerPage < 0 || c.Query.PerPage > maxPerPage {
+		return fmt.Errorf("per-page must be between 0 (API default) and %d, got %d", maxPerPage, c.Query.PerPage)
+	}
+	if c.Query.Browse != "" && !slices.Contains(browseModes, c.Query.Browse) {
+		return fmt.Errorf("browse must be one of %s, got %q", strings.Join(browseModes, ", "), c.Query.Browse)
+	}
+	for name := range c.Query.Fields {
+		if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz_") != "" {
+			return fmt.Errorf("fields: invalid field name %q", name)
+		}
+	}
+	if enabled, ok := c.Query.Fields["active_installs"]; ok && !enabled && c.Filters.MinInstalls > 0 {
+		return fmt.Errorf("fields: min-installs needs the active_installs field")
+	}
+	if c.EnrichRateLimit < 0 {
+		return fmt.Errorf("enrich-rate-limit must not be negative, got %g", c.EnrichRateLimit)
+	}
+	if c.Query.Locale != "" && !localePattern.MatchString(c.Query.Locale) {
+		return fmt.Errorf("locale must look like de or de_DE, got %q", c.Query.Locale)
+	}
+	for _, version := range c.CoreVersions {
+		if version != "latest" && version != "all" && !isVersion(version) {
+			return fmt.Errorf("core-versions: %q is not latest, all or a version number", version)
+		}
+	}
+	if c.DNSCacheTTL < 0 {
+		return fmt.Errorf("dns-cache-ttl must not be negative, got %s", c.DNSCacheTTL)
+	}
+	for _, timeout := range []struct {
+		name  string
+		value Duration
+	}{
+		{"dial-timeout", c.DialTimeout},
+		{"tls-handshake-timeout", c.TLSHandshakeTimeout},
+		{"response-header-timeout", c.ResponseHeaderTimeout},
+		{"request-timeout", c.RequestTimeout},
+		{"download-timeout", c.DownloadTimeout},
+	} {
+		if timeout.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", timeout.name, timeout.value)
+		}
+	}
+	if c.StallTimeout < 0 {
+		return fmt.Errorf("stall-timeout must not be negative, got %s", c.StallTimeout)
+	}
+	if c.MinSpeed < 0 {
+		return fmt.Errorf("min-speed must not be negative, got %s", c.MinSpeed)
+	}
+	if c.Segments < 1 {
+		return fmt.Errorf("segments must be at least 1, got %d", c.Segments)
+	}
+	if !slices.Contains(existsPolicies, c.IfExists) {
+		return fmt.Errorf("if-exists must be one of %s, got %q", strings.Join(existsPolicies, ", "), c.IfExists)
+	}
+	if c.OutputDir == "" {
+		return fmt.Errorf("output-dir must not be empty")
+	}
+	if c.Output != "" {
+		if err := validateOutput(c.Output, c.OutputDir); err != nil {
+			return err
+		}
+		if c.IfExists == "rename" {
+			return fmt.Errorf("if-exists rename cannot be combined with output")
+		}
+	}
+	if !slices.Contains(dedupeModes, c.Dedupe) {
+		return fmt.Errorf("dedupe must be one of %s, got %q", strings.Join(dedupeModes, ", "), c.Dedupe)
+	}
+	if c.Dedupe != "off" && c.Output != "" {
+		return fmt.Errorf("dedupe cannot be combined with output")
+	}
+	if !slices.Contains(torrentModes, c.Torrent) {
+		return fmt.Errorf("torrent must be one of %s, got %q", strings.Join(torrentModes, ", "), c.Torrent)
+	}
+	if c.Torrent != "off" && c.Output != "" {
+		return fmt.Errorf("torrent cannot be combined with output, which does not keep the archives")
+	}
+	for _, spec := range c.Export {
+		if _, _, err := parseExport(spec); err != nil {
+			return err
+		}
+	}
+	if c.MetadataStore != "" {
+		format, target, _ := strings.Cut(c.MetadataStore, "=")
+		if _, ok := storeFormats[format]; !ok || target == "" {
+			return fmt.Errorf("metadata-store must be sqlite=FILE or postgres=URL, got %q", redactExport(c.MetadataStore))
+		}
+	}
+	if c.Listen == "" {
+		return fmt.Errorf("listen must not be empty")
+	}
+	if c.SearchResults < 1 {
+		return fmt.Errorf("search-results must be at least 1, got %d", c.SearchResults)
+	}
+	if len(c.ExportColumns) == 0 {
+		return fmt.Errorf("export-columns must not be empty")
+	}
+	for _, column := range c.ExportColumns {
+		if csvColumns[column] == nil {
+			return fmt.Errorf("export-columns must be among %s, got %q", strings.Join(csvColumnNames(), ", "), column)
+		}
+	}
+	if !slices.Contains(repackModes, c.Repack) {
+		return fmt.Errorf("repack must be one of %s, got %q", strings.Join(repackModes, ", "), c.Repack)
+	}
+	if c.Repack != "off" {
+		if c.ZstdLevel < 1 || c.ZstdLevel > 22 {
+			return fmt.Errorf("zstd-level must be between 1 and 22, got %d", c.ZstdLevel)
+		}
+		if c.Dedupe != "off" {
+			return fmt.Errorf("repack cannot be combined with dedupe")
+		}
+		if c.Torrent != "off" {
+			return fmt.Errorf("repack cannot be combined with torrent, whose files would be gone")
+		}
+		if c.IfExists == "rename" {
+			return fmt.Errorf("if-exists rename cannot be combined with repack")
+		}
+	}
+	if p := c.TorrentPieceSize; p < 0 || (p > 0 && (p < 16<<10 || p&(p-1) != 0)) {
+		return fmt.Errorf("torrent-piece-size must be a power of two of at least 16KiB, got %s", p)
+	}
+	if c.S3PartSize < minS3PartSize {
+		return fmt.Errorf("s3-part-size must be at least %s, got %s", ByteSize(minS3PartSize), c.S3PartSize)
+	}
+	if c.GCSChunkSize <= 0 || c.GCSChunkSize%gcsChunkAlign != 0 {
+		return fmt.Errorf("gcs-chunk-size must be a positive multiple of 256KiB, got %s", c.GCSChunkSize)
+	}
+	if c.AzureBlockSize <= 0 || c.AzureBlockSize > maxAzureBlockSize {
+		return fmt.Errorf("azure-block-size must be between 1 and 4000MiB, got %s", c.AzureBlockSize)
+	}
+	if c.WebDAVChunkSize < 0 {
+		return fmt.Errorf("webdav-chunk-size must not be negative, got %s", c.WebDAVChunkSize)
+	}
+	if c.IPFSAPI != "" {
+		if u, err := url.Parse(c.IPFSAPI); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("ipfs-api must be an http or https URL, got %q", c.IPFSAPI)
+		}
+	}
+	if c.IPFSDir != "" && !strings.HasPrefix(c.IPFSDir, "/") {
+		return fmt.Errorf("ipfs-dir must be an absolute MFS path, got %q", c.IPFSDir)
+	}
+	if strings.Trim(c.S3StorageClass, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+		return fmt.Errorf("s3-storage-class must be a storage class such as STANDARD_IA, got %q", c.S3StorageClass)
+	}
+	if !slices.Contains(layouts, c.Layout) {
+		return fmt.Errorf("layout must be one of %s, got %q", strings.Join(layouts, ", "), c.Layout)
+	}
+	if _, err := parseNameTemplate(c.NameTemplate); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	defaults := defaultConfig()
+	tests := []struct {
+		name string
+		env  map[string]string
+		// file is the content of config.yaml, or of config.toml if it
+		// starts with #toml.
+		file        string
+		args        []string
+		wantWorkers int
+		wantRetries int
+		wantTimeout time.Duration
+		wantErr     string
+	}{
+		{
+			name:        "defaults",
+			wantWorkers: defaults.Workers, wantRetries: defaults.Retries, wantTimeout: time.Duration(defaults.RequestTimeout),
+		},
+		{
+			name:        "environment over defaults",
+			env:         map[string]string{"workers": "3", "request-timeout": "30s"},
+			wantWorkers: 3, wantRetries: defaults.Retries, wantTimeout: 30 * time.Second,
+		},
+		{
+			name:        "file over environment",
+			env:         map[string]string{"workers": "3", "retries": "9"},
+			file:        "workers: 5\n",
+			wantWorkers: 5, wantRetries: 9, wantTimeout: time.Duration(defaults.RequestTimeout),
+		},
+		{
+			name:        "toml file",
+			file:        "#toml\nworkers = 6\nrequest_timeout = \"1m\"\n",
+			wantWorkers: 6, wantRetries: defaults.Retries, wantTimeout: time.Minute,
+		},
+		{
+			name:        "flags over file and environment",
+			env:         map[string]string{"workers": "3"},
+			file:        "workers: 5\nretries: 4\n",
+			args:        []string{"--workers", "7"},
+			wantWorkers: 7, wantRetries: 4, wantTimeout: time.Duration(defaults.RequestTimeout),
+		},
+		{
+			name:        "flag set to its default still wins",
+			file:        "workers: 5\n",
+			args:        []string{"--workers", "4"},
+			wantWorkers: 4, wantRetries: defaults.Retries, wantTimeout: time.Duration(defaults.RequestTimeout),
+		},
+		{
+			name:    "unknown file key",
+			file:    "wrokers: 5\n",
+			wantErr: "wrokers",
+		},
+		{
+			name:    "invalid environment value",
+			env:     map[string]string{"workers": "many"},
+			wantErr: envName("workers"),
+		},
+		{
+			name:    "invalid result",
+			file:    "workers: 0\n",
+			wantErr: "workers must be at least 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for flagName, value := range tt.env {
+				t.Setenv(envName(flagName), value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				name := "config.yaml"
+				if strings.HasPrefix(tt.file, "#toml") {
+					name = "config.toml"
+				}
+				path := filepath.Join(t.TempDir(), name)
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"--config", path}, args...)
+			}
+
+			cfg, err := loadConfig("download", args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if cfg.Workers != tt.wantWorkers || cfg.Retries != tt.wantRetries || time.Duration(cfg.RequestTimeout) != tt.wantTimeout {
+				t.Fatalf("workers %d, retries %d, request-timeout %s; want %d, %d, %s", cfg.Workers, cfg.Retries,
+					time.Duration(cfg.RequestTimeout), tt.wantWorkers, tt.wantRetries, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestValidateRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*Config)
+		wantErr string
+	}{
+		{"defaults", func(*Config) {}, ""},
+		{"negative min-num-ratings", func(c *Config) { c.Filters.MinNumRatings = -1 }, "min-num-ratings must not be negative"},
+		{"min-rating above 100", func(c *Config) { c.Filters.MinRating = 101 }, "min-rating must be between 0 and 100"},
+		{"per-page above the API limit", func(c *Config) { c.Query.PerPage = maxPerPage + 1 }, "per-page must be between"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tt.change(&cfg)
+			err := cfg.validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("validate() = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
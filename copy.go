@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers archives are copied and written
+// with. Network reads often return only a few kilobytes, so writes are
+// collected into large blocks before they reach the file.
+const copyBufferSize = 1 << 20
+
+var copyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, copyBufferSize)
+		return &b
+	},
+}
+
+// copyArchive copies src to dst through a pooled buffer and a buffered
+// writer, and flushes the writer before it returns.
+func copyArchive(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	w := bufio.NewWriterSize(dst, copyBufferSize)
+	// Hide any ReaderFrom or WriterTo so that io.CopyBuffer uses buf.
+	n, err := io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{src}, *buf)
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
+
+// preallocate reserves size bytes of disk space for file if --preallocate
+// is set, without changing its size, so that partial downloads can still
+// be resumed by their length. It is a no-op on platforms without support.
+func (s *Scraper) preallocate(file *os.File, size int64) {
+	if !s.cfg.Preallocate || size <= 0 {
+		return
+	}
+	if err := fallocate(file, size); err != nil {
+		slog.Debug("failed to preallocate disk space", "file", file.Name(), "bytes", size, "error", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+const (
+	coreVersionCheckURL = "https://api.wordpress.org/core/version-check/1.7/"
+	coreStableCheckURL  = "https://api.wordpress.org/core/stable-check/1.0/"
+)
+
+// coreOffer is an entry of the version-check response.
+type coreOffer struct {
+	Response string `json:"response"`
+	Download string `json:"download"`
+	Locale   string `json:"locale"`
+	Current  string `json:"current"`
+	Version  string `json:"version"`
+}
+
+// runCore downloads the WordPress core releases named by --core-versions.
+// Releases go through the regular download pipeline as the "wordpress"
+// slug, so naming, size limits and the run report apply to them as well.
+func runCore(ctx context.Context, s *Scraper) error {
+	// Core releases are not part of the plugin checksums API, and keep a
+	// checkpoint of their own.
+	s.dir.checksumsURL = ""
+	s.dir.name = "core"
+
+	versions, err := s.coreVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	source := func(fn func(Plugin) error) error {
+		for i, version := range versions {
+			if s.cfg.MaxDownloads > 0 && i >= s.cfg.MaxDownloads {
+				break
+			}
+			if err := fn(s.coreRelease(version)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if s.cfg.DryRun {
+		return dryRun(source, "downloaded")
+	}
+	return s.downloadAll(ctx, source)
+}
+
+// coreVersions resolves --core-versions into release numbers, oldest first.
+// "latest" is the current release and "all" every release ever published.
+func (s *Scraper) coreVersions(ctx context.Context) ([]string, error) {
+	seen := map[string]bool{}
+	var versions []string
+	add := func(version string) {
+		if !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+
+	for _, want := range s.cfg.CoreVersions {
+		switch want {
+		case "latest":
+			var check struct {
+				Offers []coreOffer `json:"offers"`
+			}
+			if err := s.getJSON(ctx, s.limiter, coreVersionCheckURL, &check); err != nil {
+				return nil, fmt.Errorf("core version check: %w", err)
+			}
+			if len(check.Offers) == 0 {
+				return nil, fmt.Errorf("core version check returned no offers")
+			}
+			add(check.Offers[0].Current)
+		case "all":
+			var stable map[string]string
+			if err := s.getJSON(ctx, s.limiter, coreStableCheckURL, &stable); err != nil {
+				return nil, fmt.Errorf("core stable check: %w", err)
+			}
+			for version := range stable {
+				add(version)
+			}
+		default:
+			add(want)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	return versions, nil
+}
+
+// coreRelease describes the release archive of version, localized if
+// --locale is set.
+func (s *Scraper) coreRelease(version string) Plugin {
+	link := "https://downloads.wordpress.org/release/wordpress-" + url.PathEscape(version) + ".zip"
+	if locale := s.cfg.Query.Locale; locale != "" && locale != "en_US" {
+		link = "https://downloads.wordpress.org/release/" + url.PathEscape(locale) + "/wordpress-" + url.PathEscape(version) + ".zip"
+	}
+	return Plugin{Name: "WordPress", Slug: "wordpress", Version: version, DownloadLink: link}
+}
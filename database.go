@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlDialect covers what the SQL of sqlExporter needs to differ in between
+// databases.
+type sqlDialect struct {
+	// bind rewrites the ? placeholders of query for the driver.
+	bind func(query string) string
+	// time converts a timestamp for a column.
+	time func(t time.Time) any
+}
+
+// bindDollar numbers the ? placeholders of query as $1, $2, and so on. The
+// queries of sqlExporter have no literal question marks.
+func bindDollar(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// sqlExporter records the plugins of a run in a metadata database with the
+// tables runs, plugins, versions and downloads, which successive runs add
+// to: plugins keep their latest metadata, every release and every download
+// attempt is kept.
+type sqlExporter struct {
+	db      *sql.DB
+	dialect sqlDialect
+	kind    string
+	runID   int64
+}
+
+// newSQLExporter starts a run of kind in db, which has the schema.
+func newSQLExporter(db *sql.DB, dialect sqlDialect, kind string) (exporter, error) {
+	e := &sqlExporter{db: db, dialect: dialect, kind: kind}
+	err := db.QueryRow(dialect.bind("INSERT INTO runs (kind, started) VALUES (?, ?) RETURNING id"),
+		kind, dialect.time(time.Now())).Scan(&e.runID)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *sqlExporter) exec(tx *sql.Tx, query string, args ...any) error {
+	_, err := tx.Exec(e.dialect.bind(query), args...)
+	return err
+}
+
+// nullTime is the column value of t, or NULL for a zero t.
+func (e *sqlExporter) nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return e.dialect.time(t)
+}
+
+func (e *sqlExporter) export(rec MetadataRecord) error {
+	metadata, err := json.Marshal(rec.Plugin)
+	if err != nil {
+		return err
+	}
+	tx, err := e.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p := rec.Plugin
+	// Plugins that come without metadata, such as those of --from-manifest,
+	// leave the metadata of earlier runs alone.
+	if p.Name != "" {
+		err = e.exec(tx, `INSERT INTO plugins (kind, slug, name, version, author, active_installs, downloaded, rating,
+	num_ratings, requires, tested, requires_php, last_updated, added, metadata, seen)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (kind, slug) DO UPDATE SET name = excluded.name, version = excluded.version, author = excluded.author,
+	active_installs = excluded.active_installs, downloaded = excluded.downloaded, rating = excluded.rating,
+	num_ratings = excluded.num_ratings, requires = excluded.requires, tested = excluded.tested,
+	requires_php = excluded.requires_php, last_updated = excluded.last_updated, added = excluded.added,
+	metadata = excluded.metadata, seen = excluded.seen`,
+			e.kind, p.Slug, string(p.Name), p.Version, string(p.Author), p.ActiveInstalls, p.Downloaded, p.Rating,
+			p.NumRatings, string(p.Requires), string(p.Tested), string(p.RequiresPHP),
+			e.nullTime(p.LastUpdated.Time), e.nullTime(p.Added.Time), string(metadata), e.dialect.time(rec.Finished))
+		if err != nil {
+			return err
+		}
+	}
+	versions := map[string]string{p.Version: p.DownloadLink}
+	for version, link := range p.Versions {
+		versions[version] = string(link)
+	}
+	for version, link := range versions {
+		if version == "" || link == "" {
+			continue
+		}
+		err = e.exec(tx, "INSERT INTO versions (kind, slug, version, download_link) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING",
+			e.kind, p.Slug, version, link)
+		if err != nil {
+			return err
+		}
+	}
+	err = e.exec(tx, `INSERT INTO downloads (run_id, kind, slug, version, outcome, reason, size, sha256, file, cid, repacked,
+	started, finished)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.runID, e.kind, p.Slug, p.Version, rec.Outcome, rec.Reason, rec.Size, rec.SHA256, rec.File, rec.CID, rec.Repacked,
+		e.dialect.time(rec.Started), e.dialect.time(rec.Finished))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (e *sqlExporter) close() error {
+	_, err := e.db.Exec(e.dialect.bind("UPDATE runs SET finished = ? WHERE id = ?"), e.dialect.time(time.Now()), e.runID)
+	if cerr := e.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// objectsDir below the output directory holds the archives by content with
+// --dedupe, as objects/<first two hex digits>/<sha256>.zip, so that
+// identical archives take up disk space only once.
+const objectsDir = "objects"
+
+// dedupeModes are the values of --dedupe.
+var dedupeModes = []string{"off", "hardlink", "symlink"}
+
+// objectPath returns the path of the stored archive with the hex encoded
+// SHA-256 sum.
+func (s *Scraper) objectPath(sum string) string {
+	return filepath.Join(s.cfg.OutputDir, objectsDir, sum[:2], sum+".zip")
+}
+
+// dedupe moves the archive fileName with the hex encoded SHA-256 sum into
+// the objects directory and puts a hardlink or symlink to the object in its
+// place. If the object is stored already, because the archive is a
+// re-release or a download of an earlier run, fileName is dropped in favour
+// of it. An object whose content no longer matches its name is replaced.
+func (s *Scraper) dedupe(fileName, sum string) error {
+	object := s.objectPath(sum)
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	if stored, err := os.Stat(object); err == nil && os.SameFile(info, stored) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(object), 0o755); err != nil {
+		return err
+	}
+	stored, err := fileSHA256(object)
+	duplicate := err == nil && stored == sum
+	if !duplicate {
+		if err := os.Rename(fileName, object); err != nil {
+			return err
+		}
+	}
+
+	tmpName := fileName + tmpSuffix
+	os.Remove(tmpName)
+	if s.cfg.Dedupe == "symlink" {
+		// Relative links keep working when the output directory is moved.
+		var target string
+		if target, err = filepath.Rel(filepath.Dir(fileName), object); err == nil {
+			err = os.Symlink(target, tmpName)
+		}
+	} else {
+		err = os.Link(object, tmpName)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		if !duplicate {
+			os.Rename(object, fileName)
+		}
+		return fmt.Errorf("link %s to %s: %w", fileName, object, err)
+	}
+	if duplicate {
+		slog.Info("archive is a duplicate, linked to the stored copy", "file", fileName, "sha256", sum)
+	}
+	return nil
+}
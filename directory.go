@@ -0,0 +1,69 @@
+package main
+
+import "strings"
+
+// directory describes one of the WordPress.org directories the scraper can
+// enumerate. Both share the same API shape, so themes are decoded into the
+// Plugin type as well.
+type directory struct {
+	name       string // "plugins" or "themes"
+	apiURL     string
+	listAction string
+	infoAction string
+	// translationsURL lists the language packs of a release.
+	translationsURL string
+	// checksumsURL publishes per-file checksums of each release, if the
+	// directory has them.
+	checksumsURL string
+	// fields are requested unless the configuration overrides them.
+	fields map[string]bool
+	// svnURL is the Subversion server with a repository per slug, of which
+	// the svn command checks out svnPaths, or everything without them.
+	svnURL   string
+	svnPaths []string
+}
+
+var directories = []directory{
+	{
+		name:            "plugins",
+		apiURL:          "https://api.wordpress.org/plugins/info/1.2/",
+		listAction:      "query_plugins",
+		infoAction:      "plugin_information",
+		translationsURL: "https://api.wordpress.org/translations/plugins/1.0/",
+		checksumsURL:    "https://downloads.wordpress.org/plugin-checksums",
+		svnURL:          "https://plugins.svn.wordpress.org/",
+		svnPaths:        []string{"trunk", "tags"},
+	},
+	{
+		name:            "themes",
+		apiURL:          "https://api.wordpress.org/themes/info/1.2/",
+		listAction:      "query_themes",
+		infoAction:      "theme_information",
+		translationsURL: "https://api.wordpress.org/translations/themes/1.0/",
+		// query_themes leaves these out by default, but the filters need them.
+		fields: map[string]bool{"active_installs": true, "last_updated": true, "requires": true, "requires_php": true},
+		svnURL: "https://themes.svn.wordpress.org/",
+	},
+}
+
+func lookupDirectory(name string) (directory, bool) {
+	for _, dir := range directories {
+		if dir.name == name {
+			return dir, true
+		}
+	}
+	return directory{}, false
+}
+
+func directoryNames() string {
+	names := make([]string, len(directories))
+	for i, dir := range directories {
+		names[i] = dir.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// metadataFile is the name of the file the fetch command writes to.
+func (d directory) metadataFile() string {
+	return d.name + ".json"
+}
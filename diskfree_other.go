@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// freeSpace returns -1, since the free space is only checked on Linux.
+func freeSpace(dir string) (int64, error) {
+	return -1, nil
+}
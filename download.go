@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// skipError reports that a plugin was deliberately not downloaded.
+type skipError struct {
+	reason string
+	bytes  int64
+}
+
+func (e *skipError) Error() string { return e.reason }
+
+// errTooManyFailures aborts a download run that exceeded --max-failures.
+var errTooManyFailures = errors.New("too many failed downloads")
+
+// downloadAll downloads every plugin produced by source with a pool of
+// workers and writes the run report. Once parent is cancelled, or more
+// downloads failed than --max-failures allows, no further plugins are
+// queued, the transfers in flight are aborted and the report is written for
+// the plugins handled so far.
+func (s *Scraper) downloadAll(parent context.Context, source func(func(Plugin) error) error) error {
+	if err := os.MkdirAll(s.cfg.OutputDir, 0o755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+
+	sumsPath := filepath.Join(s.cfg.OutputDir, checksumsFile)
+	sums, err := readChecksums(sumsPath)
+	if err != nil {
+		return fmt.Errorf("read checksums: %w", err)
+	}
+	s.sums = sums
+	// Plugins chosen by --top or --sample are only queued after the walk,
+	// so their pages say nothing about the progress of the run.
+	pages := s.cfg.Top == 0 && s.cfg.Sample == 0
+	if s.checkpoint, err = openCheckpoint(s.cfg.OutputDir, s.dir.name, pages, s.cfg.Resume); err != nil {
+		return fmt.Errorf("read checkpoint: %w", err)
+	}
+	s.manifest = newManifest()
+	if s.cfg.Output != "" {
+		// Archives of earlier runs that were uploaded are only described by
+		// the manifest of the previous run and SHA256SUMS.
+		s.previous = map[string]ManifestEntry{}
+		previous, err := readManifest(filepath.Join(s.cfg.OutputDir, s.dir.name+"-"+manifestFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("failed to read the manifest of the previous run", "error", err)
+		}
+		for _, entry := range previous.Plugins {
+			s.previous[releaseKey(entry.Slug, entry.Version)] = entry
+		}
+	}
+	if s.cfg.MetadataJSONL {
+		if s.metadata, err = openMetadataLog(s.cfg.OutputDir); err != nil {
+			return fmt.Errorf("open metadata log: %w", err)
+		}
+	}
+	if s.exports, err = s.openExports(); err != nil {
+		return err
+	}
+	retries, err := readRetryQueue(s.cfg.OutputDir)
+	if err != nil {
+		return fmt.Errorf("read retry queue: %w", err)
+	}
+
+	ctx, abort := context.WithCancelCause(parent)
+	defer abort(nil)
+
+	// The producer and the workers run in one group, and an error of either
+	// stops the queue. Plugins that were already queued are still
+	// downloaded with ctx, which only an interruption or --max-failures
+	// cancels.
+	group, groupCtx := errgroup.WithContext(ctx)
+	var queued int
+	report := newReport()
+	size := s.cfg.Workers
+	if s.cfg.Autoscale {
+		size = s.cfg.MinWorkers
+	}
+	pool := newWorkerPool(groupCtx, group, size, s.cfg.Workers, func(plugin Plugin) error {
+		started := time.Now()
+		n, err := s.downloadPlugin(ctx, plugin)
+		if err != nil && ctx.Err() != nil {
+			slog.Debug("download interrupted", "slug", plugin.Slug, "version", plugin.Version, "error", err)
+			return nil
+		}
+		recordDownload(report, plugin, n, err)
+		s.recordMetadata(plugin, started, err)
+		s.checkpoint.finished(plugin, err)
+		retries.record(plugin, err)
+		if errors.Is(err, syscall.ENOSPC) {
+			// Every further download would fail the same way.
+			return fmt.Errorf("%s: %w", plugin.Slug, err)
+		}
+		if attempted, failed := report.attempts(); s.cfg.MaxFailures.exceeded(failed, attempted) {
+			abort(fmt.Errorf("%w: %d of %d downloads failed, more than --max-failures %s allows",
+				errTooManyFailures, failed, attempted, s.cfg.MaxFailures))
+		}
+		return nil
+	})
+	done := make(chan struct{})
+	if s.cfg.Autoscale {
+		go s.autoscale(pool, report, done)
+	}
+	go s.checkpoint.keep(done)
+
+	group.Go(func() error {
+		defer pool.close()
+		return source(func(plugin Plugin) error {
+			if s.checkpoint.done(plugin) {
+				const reason = "downloaded before the run was interrupted"
+				report.skipped(plugin, reason, 0)
+				s.manifestExisting(ctx, plugin)
+				s.recordMetadata(plugin, time.Now(), &skipError{reason: reason})
+				return nil
+			}
+			s.checkpoint.queued(plugin)
+			if err := pool.submit(plugin); err != nil {
+				return err
+			}
+			queued++
+			return nil
+		})
+	})
+
+	err = group.Wait()
+	close(done)
+
+	if werr := report.write(s.cfg.OutputDir); werr != nil {
+		slog.Error("failed to write run report", "error", werr)
+	}
+	runFiles := []string{filepath.Join(s.cfg.OutputDir, reportFile), sumsPath,
+		filepath.Join(s.cfg.OutputDir, s.dir.name+"-"+manifestFile)}
+	if s.metadata != nil {
+		if werr := s.metadata.close(); werr != nil {
+			slog.Error("failed to write metadata log", "error", werr)
+		}
+		runFiles = append(runFiles, filepath.Join(s.cfg.OutputDir, metadataFile))
+	}
+	s.exports.close()
+	if werr := sums.write(sumsPath); werr != nil {
+		slog.Error("failed to write checksums", "error", werr)
+	}
+	if werr := retries.write(); werr != nil {
+		slog.Error("failed to write retry queue", "error", werr)
+	}
+	if s.ipfs != nil && s.ipfs.dir != "" {
+		if root, werr := s.ipfs.root(context.WithoutCancel(parent)); werr != nil {
+			slog.Error("failed to look up the IPFS root", "error", werr)
+		} else {
+			s.manifest.ipfsRoot = root
+			slog.Info("snapshot root on IPFS", "dir", s.ipfs.dir, "cid", root)
+		}
+	}
+	if werr := s.manifest.write(s.cfg.OutputDir, s.dir.name); werr != nil {
+		slog.Error("failed to write manifest", "error", werr)
+	}
+	if s.cfg.Torrent != "off" && ctx.Err() == nil {
+		if werr := s.writeTorrents(s.manifest.sorted()); werr != nil {
+			slog.Error("failed to write torrents", "error", werr)
+		}
+	}
+	// The run metadata stays in the output directory, where the next run
+	// reads it, and is uploaded next to the archives, even if the run was
+	// interrupted.
+	for _, fileName := range runFiles {
+		if werr := s.publish(context.WithoutCancel(parent), fileName, true, nil); werr != nil {
+			slog.Error("failed to upload run metadata", "file", fileName, "error", werr)
+		}
+	}
+	if ctx.Err() != nil || (err != nil && !isPartial(err)) {
+		if werr := s.checkpoint.save(); werr != nil {
+			slog.Error("failed to write checkpoint", "error", werr)
+		} else {
+			slog.Info("wrote checkpoint, continue the run with --resume", "file", s.checkpoint.path)
+		}
+	} else if werr := s.checkpoint.remove(); werr != nil {
+		slog.Error("failed to remove checkpoint", "error", werr)
+	}
+	slog.Info("download run finished", "downloaded", report.Downloaded, "failed", len(report.Failed),
+		"skipped", len(report.Skipped), "bytes", report.Bytes)
+
+	if cause := context.Cause(ctx); errors.Is(cause, errTooManyFailures) {
+		return cause
+	} else if cause != nil {
+		return fmt.Errorf("download run interrupted: %w", cause)
+	}
+	if err != nil && !isPartial(err) {
+		return err
+	}
+	if n := report.failures(); n > 0 {
+		return partialFailure(fmt.Errorf("%d of %d downloads failed", n, queued))
+	}
+	return err
+}
+
+// errTruncated reports a transfer that ended before the announced
+// Content-Length was received.
+var errTruncated = errors.New("truncated transfer")
+
+// errInvalidArchive reports a download that failed validation.
+var errInvalidArchive = errors.New("invalid archive")
+
+// errNotArchive reports a successful response that does not carry a zip
+// archive, such as an HTML error page served with status 200.
+var errNotArchive = errors.New("response is not a zip archive")
+
+// zipMagic are the first bytes of every zip archive.
+var zipMagic = [][]byte{[]byte("PK\x03\x04"), []byte("PK\x05\x06")}
+
+// checkArchiveResponse rejects responses whose Content-Type is text, and,
+// if start is set, bodies that do not begin with a zip signature. The
+// final URL is included in the error because such responses are usually
+// the result of a redirect to a web page.
+func checkArchiveResponse(resp *http.Response, body *bufio.Reader, start bool) error {
+	where := resp.Request.URL.Redacted()
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil &&
+		strings.HasPrefix(mediaType, "text/") {
+		return fmt.Errorf("%w: content type %s from %s", errNotArchive, mediaType, where)
+	}
+	if !start {
+		return nil
+	}
+	magic, err := body.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	for _, want := range zipMagic {
+		if bytes.Equal(magic, want) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: body starts with %q from %s", errNotArchive, magic, where)
+}
+
+// partSuffix marks archives that are still being downloaded. Partial files
+// are kept when a transfer fails so that the next attempt can resume them,
+// which is why they are not named .tmp like the other files written
+// atomically.
+const partSuffix = ".part"
+
+// downloadPlugin downloads the archive of plugin and returns its size.
+func (s *Scraper) downloadPlugin(ctx context.Context, plugin Plugin) (int64, error) {
+	start := time.Now()
+	fileName, err := s.archivePath(plugin)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+		return 0, err
+	}
+	// Repacked archives no longer exist under their zip name.
+	existing := fileName
+	if s.cfg.Repack != "off" {
+		existing = repackedName(fileName)
+	}
+	if info, err := s.stat(ctx, existing); err == nil {
+		size := info.Size
+		switch s.cfg.IfExists {
+		case "skip":
+			s.manifestExisting(ctx, plugin)
+			return 0, &skipError{"archive already exists", size}
+		case "verify":
+			if s.cfg.Output != "" {
+				// Uploaded archives were validated before the upload.
+				s.manifestExisting(ctx, plugin)
+				return 0, &skipError{"archive already exists in the output", size}
+			}
+			var err error
+			if existing != fileName {
+				err = verifyRepacked(existing)
+			} else {
+				err = s.validateExisting(plugin, fileName)
+			}
+			if err == nil {
+				s.manifestExisting(ctx, plugin)
+				return 0, &skipError{"archive already exists and is valid", size}
+			}
+			slog.Warn("existing archive is invalid, downloading it again", "file", existing, "error", err)
+			if target, err := s.quarantine(existing, existing); err != nil {
+				slog.Warn("failed to quarantine archive", "file", existing, "error", err)
+			} else {
+				slog.Info("quarantined invalid archive", "file", target)
+			}
+		case "rename":
+			fileName = freeName(fileName)
+		}
+	}
+
+	validate := func(path string) error {
+		if err := verifyArchive(path); err != nil {
+			return err
+		}
+		if pin, ok := s.pinned[releaseKey(plugin.Slug, plugin.Version)]; ok {
+			if err := checkPinned(path, pin); err != nil {
+				return err
+			}
+		}
+		if s.cfg.VerifyChecksums && s.dir.checksumsURL != "" {
+			return s.verifyOfficialChecksums(ctx, plugin, path)
+		}
+		return nil
+	}
+	stream, err := s.archiveStream(ctx, plugin, fileName)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	var sum string
+	for attempt := 1; ; attempt++ {
+		n, sum, err = s.transfer(ctx, plugin.DownloadLink, fileName, validate, stream)
+		if errors.Is(err, errNotStreamable) && stream != nil {
+			slog.Debug("archive cannot be validated as it streams, staging it", "slug", plugin.Slug, "version", plugin.Version)
+			stream = nil
+			attempt--
+			continue
+		}
+		if err == nil || attempt >= s.cfg.DownloadRetries || !retryable(err) {
+			break
+		}
+		delay := s.backoff(attempt)
+		var status *statusError
+		if errors.As(err, &status) {
+			delay = max(delay, status.retryAfter)
+		}
+		slog.Warn("download failed", "slug", plugin.Slug, "version", plugin.Version, "attempt", attempt,
+			"attempts", s.cfg.DownloadRetries, "retry_in", delay, "error", err)
+		if err := sleep(ctx, delay); err != nil {
+			return n, err
+		}
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := s.recordChecksum(fileName, sum); err != nil {
+		return n, fmt.Errorf("record checksum: %w", err)
+	}
+	// From here on the archive is stored, shared and published as stored.
+	stored := fileName
+	if s.cfg.Repack != "off" {
+		if stored, err = s.repack(fileName, n, sum); err != nil {
+			return n, err
+		}
+	}
+	var cid string
+	if s.ipfs != nil {
+		cid = s.addToIPFS(ctx, plugin, stored)
+	}
+	if err := s.recordManifest(plugin, fileName, n, sum, cid); err != nil {
+		return n, fmt.Errorf("record manifest: %w", err)
+	}
+	if s.cfg.Dedupe != "off" {
+		if err := s.dedupe(fileName, sum); err != nil {
+			return n, fmt.Errorf("dedupe: %w", err)
+		}
+	}
+
+	slog.Info("downloaded plugin", "slug", plugin.Slug, "version", plugin.Version,
+		"bytes", n, "sha256", sum, "duration", time.Since(start))
+
+	if len(s.cfg.LanguagePacks) > 0 && s.dir.translationsURL != "" {
+		s.downloadLanguagePacks(ctx, plugin, fileName)
+	}
+	meta := releaseMeta(plugin, sum)
+	if s.cfg.SHA256Sidecars {
+		if err := s.publish(ctx, fileName+".sha256", false, meta); err != nil {
+			return n, err
+		}
+	}
+	if s.cfg.MetadataSidecars {
+		if err := s.writeSidecar(plugin, fileName, start); err != nil {
+			return n, fmt.Errorf("write metadata sidecar: %w", err)
+		}
+		if err := s.publish(ctx, sidecarName(fileName), false, meta); err != nil {
+			return n, err
+		}
+	}
+	if stream != nil && stream.uploaded {
+		return n, nil
+	}
+	if err := s.publish(ctx, stored, false, meta); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// archiveStream returns the stream that uploads the archive of plugin at
+// fileName straight to the storage of --output, or nil if it has to be
+// staged in the output directory: with --stage-archives, for features that
+// need the local file after validation, and for tar output, where an
+// archive that turns out to be invalid would break the stream.
+func (s *Scraper) archiveStream(ctx context.Context, plugin Plugin, fileName string) (*archiveStream, error) {
+	switch {
+	case s.cfg.Output == "" || s.cfg.StageArchives || strings.HasPrefix(s.cfg.Output, "tar:"):
+		return nil, nil
+	case s.cfg.Repack != "off" || s.ipfs != nil || (s.cfg.VerifyChecksums && s.dir.checksumsURL != ""):
+		return nil, nil
+	}
+	name, err := s.storageName(fileName)
+	if err != nil {
+		return nil, err
+	}
+	// The SHA-256 is only known once the object is stored, so it becomes
+	// object metadata only if the manifest pinned it.
+	pin, pinned := s.pinned[releaseKey(plugin.Slug, plugin.Version)]
+	stream := &archiveStream{
+		upload: func(r io.Reader, size int64) error {
+			if err := s.storage.Put(ctx, name, r, size, releaseMeta(plugin, pin.SHA256)); err != nil {
+				return fmt.Errorf("upload %s: %w", name, err)
+			}
+			slog.Debug("uploaded file", "file", name)
+			return nil
+		},
+	}
+	if pinned {
+		stream.check = func(size int64, sum string) error {
+			return matchPin(pin, size, sum)
+		}
+	}
+	return stream, nil
+}
+
+// archiveStream takes an archive that is not staged in the output directory.
+type archiveStream struct {
+	// upload stores the size bytes of r, whose reads fail if the archive
+	// turns out to be invalid.
+	upload func(r io.Reader, size int64) error
+	// check, if set, is called with the size and SHA-256 of the complete
+	// archive before the upload may finish.
+	check func(size int64, sum string) error
+	// uploaded is set once the archive went to upload instead of fileName.
+	uploaded bool
+}
+
+// transfer downloads the zip archive at rawURL into fileName and returns its
+// size and hex encoded SHA-256. The data is written to fileName+".part" first and renamed only after
+// the archive passed validate; an existing partial file is resumed with
+// a Range request if the server supports it, and restarted otherwise.
+// With stream, an archive of known length is streamed to it instead.
+func (s *Scraper) transfer(parent context.Context, rawURL, fileName string, validate func(string) error, stream *archiveStream) (int64, string, error) {
+	partName := fileName + partSuffix
+	var offset int64
+	if info, err := os.Stat(partName); err == nil {
+		offset = info.Size()
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := s.do(s.downloadLimiter, req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			return 0, "", fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+		flags = os.O_RDWR | os.O_APPEND
+		slog.Debug("resuming partial download", "file", fileName, "offset", offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file does not fit the archive on the server any
+		// more, so start over.
+		resp.Body.Close()
+		if err := os.Remove(partName); err != nil {
+			return 0, "", err
+		}
+		return s.transfer(parent, rawURL, fileName, validate, stream)
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	default:
+		return 0, "", s.statusError(resp)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	if err := checkArchiveResponse(resp, body, offset == 0); err != nil {
+		return 0, "", err
+	}
+
+	maxSize := int64(s.cfg.MaxZipSize)
+	if total := offset + resp.ContentLength; maxSize > 0 && resp.ContentLength >= 0 && total > maxSize {
+		os.Remove(partName)
+		return 0, "", &skipError{fmt.Sprintf("archive of %d bytes exceeds max-zip-size %s", total, s.cfg.MaxZipSize), total}
+	}
+
+	if offset == 0 && stream == nil && s.segmented(resp) {
+		// Continue with ranged requests to the final URL, so that the
+		// segments skip the redirects.
+		resp.Body.Close()
+		return s.transferSegments(parent, resp.Request.URL.String(), fileName, resp.ContentLength, validate)
+	}
+
+	release, err := s.inFlight.acquire(ctx, resp.ContentLength)
+	if err != nil {
+		return 0, "", err
+	}
+	defer release()
+
+	if stream != nil && offset == 0 && resp.ContentLength > 0 {
+		return s.streamArchive(ctx, body, cancel, resp.ContentLength, stream)
+	}
+
+	file, err := os.OpenFile(partName, flags, 0o644)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	// The hash covers the whole archive, including a resumed prefix.
+	hash := sha256.New()
+	if offset > 0 {
+		if _, err := io.Copy(hash, file); err != nil {
+			return 0, "", err
+		}
+	}
+
+	shaped, release := s.shape(ctx, body)
+	defer release()
+	src, stop := s.watchTransfer(shaped, cancel)
+	if maxSize > 0 {
+		// Servers do not always send a Content-Length, so enforce the
+		// limit on the stream as well.
+		src = io.LimitReader(src, maxSize-offset+1)
+	}
+	if resp.ContentLength > 0 {
+		s.preallocate(file, offset+resp.ContentLength)
+	}
+	n, err := copyArchive(io.MultiWriter(file, hash), src)
+	stop()
+	total := offset + n
+	if err != nil {
+		return total, "", fmt.Errorf("write %s: %w", partName, transferError(ctx, err))
+	}
+	if maxSize > 0 && total > maxSize {
+		file.Close()
+		os.Remove(partName)
+		return 0, "", &skipError{fmt.Sprintf("archive exceeds max-zip-size %s", s.cfg.MaxZipSize), total}
+	}
+
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		// The partial file is kept so the next attempt can resume it.
+		return total, "", fmt.Errorf("%w: received %d of %d bytes", errTruncated, n, resp.ContentLength)
+	}
+
+	if err := file.Close(); err != nil {
+		return total, "", err
+	}
+	if err := s.finishArchive(partName, fileName, validate); err != nil {
+		return total, "", err
+	}
+	return total, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// streamArchive passes the archive of size bytes in body on to stream as it
+// arrives, and returns its SHA-256. It is validated on the way, see
+// archiveGate, so an invalid archive aborts the upload and nothing is left
+// to quarantine.
+func (s *Scraper) streamArchive(ctx context.Context, body io.Reader, cancel context.CancelCauseFunc, size int64, stream *archiveStream) (int64, string, error) {
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		err := stream.upload(pr, size)
+		pr.CloseWithError(err)
+		uploaded <- err
+	}()
+
+	shaped, release := s.shape(ctx, body)
+	defer release()
+	src, stop := s.watchTransfer(shaped, cancel)
+	gate := newArchiveGate(pw, size, stream.check)
+	n, err := copyArchive(waitFor(src, gate), src)
+	stop()
+	if err == nil && n != size {
+		err = fmt.Errorf("%w: received %d of %d bytes", errTruncated, n, size)
+	}
+	if err != nil {
+		gate.abort(err)
+		pw.CloseWithError(err)
+		// A failed upload fails the writes with its own error.
+		if uerr := <-uploaded; uerr != nil && errors.Is(err, uerr) {
+			return n, "", uerr
+		}
+		if errors.Is(err, errInvalidArchive) || errors.Is(err, errNotStreamable) || errors.Is(err, errTruncated) {
+			return n, "", err
+		}
+		return n, "", fmt.Errorf("stream archive: %w", transferError(ctx, err))
+	}
+	pw.Close()
+	if err := <-uploaded; err != nil {
+		return n, "", err
+	}
+	stream.uploaded = true
+	return n, gate.sum(), nil
+}
+
+// finishArchive validates the complete download at partName and renames it
+// to fileName. A complete but corrupt archive cannot be resumed, so it is
+// moved aside for inspection and downloaded from scratch on the next
+// attempt.
+func (s *Scraper) finishArchive(partName, fileName string, validate func(string) error) error {
+	if err := validate(partName); err != nil {
+		target, qerr := s.quarantine(partName, fileName)
+		if qerr != nil {
+			os.Remove(partName)
+			return fmt.Errorf("%w: %w", errInvalidArchive, err)
+		}
+		return fmt.Errorf("%w, quarantined as %s: %w", errInvalidArchive, target, err)
+	}
+	return os.Rename(partName, fileName)
+}
+
+// quarantineDir below the output directory receives archives that failed
+// validation, so that they neither count as part of the corpus nor get lost.
+const quarantineDir = "quarantine"
+
+// quarantine moves the invalid file at path, which was meant to become
+// fileName, to the same relative path below the quarantine directory and
+// returns its new name.
+func (s *Scraper) quarantine(path, fileName string) (string, error) {
+	rel, err := filepath.Rel(s.cfg.OutputDir, fileName)
+	if err != nil || !filepath.IsLocal(rel) {
+		rel = filepath.Base(fileName)
+	}
+	target := filepath.Join(s.cfg.OutputDir, quarantineDir, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+	return target, os.Rename(path, target)
+}
+
+// freeName returns fileName with the lowest numeric suffix, as in
+// akismet-5.3.1.zip, akismet-5.3.1-1.zip, akismet-5.3.1-2.zip, that does not
+// exist yet.
+func freeName(fileName string) string {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if _, err := os.Stat(name); errors.Is(err, fs.ErrNotExist) {
+			return name
+		}
+	}
+}
+
+// contentRangeStart returns the first byte position of a Content-Range
+// header such as "bytes 100-999/1000".
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// recordDownload adds the outcome of a download to report.
+func recordDownload(report *Report, plugin Plugin, n int64, err error) {
+	var skip *skipError
+	switch {
+	case err == nil:
+		report.downloaded(n)
+	case errors.As(err, &skip):
+		slog.Warn("skipped plugin", "slug", plugin.Slug, "version", plugin.Version, "reason", skip.reason)
+		report.skipped(plugin, skip.reason, skip.bytes)
+	default:
+		slog.Error("failed to download plugin", "slug", plugin.Slug, "version", plugin.Version, "error", err)
+		report.failed(plugin, err)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultBulkSize is the number of documents per bulk request when
+// --per-page leaves the page size to the API.
+const defaultBulkSize = 100
+
+// searchDocument is the document of a plugin in the search index. Unlike
+// the records of metadata.jsonl it is flat and keeps the maps of the API,
+// keyed by version or username, as lists of their keys, so that the index
+// mapping does not grow a field for every release ever made.
+type searchDocument struct {
+	Kind             string     `json:"kind"`
+	Slug             string     `json:"slug"`
+	Name             string     `json:"name"`
+	Version          string     `json:"version"`
+	Author           string     `json:"author"`
+	ShortDescription string     `json:"short_description"`
+	Description      string     `json:"description"`
+	Homepage         string     `json:"homepage"`
+	Requires         string     `json:"requires"`
+	Tested           string     `json:"tested"`
+	RequiresPHP      string     `json:"requires_php"`
+	ActiveInstalls   int        `json:"active_installs"`
+	Downloaded       int        `json:"downloaded"`
+	Rating           int        `json:"rating"`
+	NumRatings       int        `json:"num_ratings"`
+	LastUpdated      *time.Time `json:"last_updated,omitempty"`
+	Added            *time.Time `json:"added,omitempty"`
+	Tags             []string   `json:"tags"`
+	Contributors     []string   `json:"contributors"`
+	Versions         []string   `json:"versions"`
+	DownloadLink     string     `json:"download_link"`
+	Outcome          string     `json:"outcome"`
+	Reason           string     `json:"reason,omitempty"`
+	Size             int64      `json:"size,omitempty"`
+	SHA256           string     `json:"sha256,omitempty"`
+	File             string     `json:"file,omitempty"`
+	Started          time.Time  `json:"started"`
+	Finished         time.Time  `json:"finished"`
+}
+
+// newSearchDocument returns the document of rec for a run of kind.
+func newSearchDocument(kind string, rec MetadataRecord) searchDocument {
+	p := rec.Plugin
+	doc := searchDocument{
+		Kind:             kind,
+		Slug:             p.Slug,
+		Name:             string(p.Name),
+		Version:          p.Version,
+		Author:           string(p.Author),
+		ShortDescription: string(p.ShortDescription),
+		Description:      string(p.Description),
+		Homepage:         string(p.Homepage),
+		Requires:         string(p.Requires),
+		Tested:           string(p.Tested),
+		RequiresPHP:      string(p.RequiresPHP),
+		ActiveInstalls:   p.ActiveInstalls,
+		Downloaded:       p.Downloaded,
+		Rating:           p.Rating,
+		NumRatings:       p.NumRatings,
+		Tags:             sortedKeys(p.Tags),
+		Contributors:     sortedKeys(p.Contributors),
+		Versions:         sortedKeys(p.Versions),
+		DownloadLink:     p.DownloadLink,
+		Outcome:          rec.Outcome,
+		Reason:           rec.Reason,
+		Size:             rec.Size,
+		SHA256:           rec.SHA256,
+		File:             rec.File,
+		Started:          rec.Started,
+		Finished:         rec.Finished,
+	}
+	if !p.LastUpdated.IsZero() {
+		doc.LastUpdated = &p.LastUpdated.Time
+	}
+	if !p.Added.IsZero() {
+		doc.Added = &p.Added.Time
+	}
+	return doc
+}
+
+// sortedKeys returns the keys of m in order, and an empty list for an
+// empty map.
+func sortedKeys[M ~map[string]V, V any](m M) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// searchExporter indexes the plugins of a run into an Elasticsearch or
+// OpenSearch index with the bulk API, a page worth of plugins per request.
+// A plugin has one document, by kind and slug, which every run replaces.
+type searchExporter struct {
+	client *http.Client
+	// bulkURL is the _bulk endpoint of the cluster.
+	bulkURL  string
+	index    string
+	user     *url.Userinfo
+	apiKey   string
+	kind     string
+	size     int
+	pending  bytes.Buffer
+	buffered int
+}
+
+// newSearchExporter returns the exporter of --export elasticsearch=URL or
+// opensearch=URL, where the last element of the URL path is the index.
+// Credentials in the URL are sent with basic authentication.
+func newSearchExporter(s *Scraper, target string) (exporter, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%s is not an http or https URL", u.Redacted())
+	}
+	index := path.Base(u.Path)
+	if index == "/" || index == "." {
+		return nil, fmt.Errorf("%s names no index", u.Redacted())
+	}
+	e := &searchExporter{
+		client: s.downloadClient,
+		index:  index,
+		user:   u.User,
+		apiKey: s.cfg.ElasticsearchAPIKey,
+		kind:   s.dir.name,
+		size:   s.cfg.Query.PerPage,
+	}
+	if e.size == 0 {
+		e.size = defaultBulkSize
+	}
+	u.User = nil
+	u.Path = strings.TrimSuffix(path.Dir(u.Path), "/") + "/_bulk"
+	u.RawQuery = ""
+	e.bulkURL = u.String()
+	return e, nil
+}
+
+func (e *searchExporter) export(rec MetadataRecord) error {
+	// Plugins that come without metadata, such as those of --from-manifest
+	// or the older releases of --all-versions, keep the document of earlier
+	// runs, as it would otherwise lose its metadata.
+	if rec.Plugin.Name == "" {
+		return nil
+	}
+	action := map[string]any{"index": map[string]string{"_index": e.index, "_id": e.kind + ":" + rec.Plugin.Slug}}
+	data, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	e.pending.Write(data)
+	e.pending.WriteByte('\n')
+	if data, err = json.Marshal(newSearchDocument(e.kind, rec)); err != nil {
+		return err
+	}
+	e.pending.Write(data)
+	e.pending.WriteByte('\n')
+	if e.buffered++; e.buffered < e.size {
+		return nil
+	}
+	return e.flush()
+}
+
+// flush sends the buffered documents in one bulk request. Documents the
+// cluster rejected are reported with the first reason.
+func (e *searchExporter) flush() error {
+	if e.buffered == 0 {
+		return nil
+	}
+	defer func() {
+		e.pending.Reset()
+		e.buffered = 0
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.bulkURL, bytes.NewReader(e.pending.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	} else if e.user != nil {
+		password, _ := e.user.Password()
+		req.SetBasicAuth(e.user.Username(), password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("bulk index: %w: %s", &statusError{code: resp.StatusCode, status: resp.Status}, bytes.TrimSpace(data))
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID    string `json:"_id"`
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("bulk index: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	var failed int
+	var first string
+	for _, item := range result.Items {
+		for _, op := range item {
+			if op.Error == nil {
+				continue
+			}
+			if failed++; first == "" {
+				first = fmt.Sprintf("%s: %s: %s", op.ID, op.Error.Type, op.Error.Reason)
+			}
+		}
+	}
+	return fmt.Errorf("bulk index: %d of %d documents rejected, first %s", failed, e.buffered, first)
+}
+
+func (e *searchExporter) close() error {
+	return e.flush()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// enrichFields are the plugin_information fields that the list endpoint
+// leaves out and --enrich adds.
+var enrichFields = []string{"sections", "versions", "screenshots", "contributors", "ratings", "donate_link", "banners"}
+
+// enrich returns plugin updated with its plugin_information metadata. The
+// calls are paced by their own rate limiter. If the lookup fails, plugin
+// is returned unchanged.
+func (s *Scraper) enrich(ctx context.Context, plugin Plugin) Plugin {
+	query := s.infoQuery(plugin.Slug)
+	for _, name := range enrichFields {
+		if _, ok := s.cfg.Query.Fields[name]; !ok {
+			query.Set("request[fields]["+name+"]", "true")
+		}
+	}
+
+	// Decoding on top of the list metadata keeps the fields that
+	// plugin_information does not return.
+	detail := plugin
+	if err := s.getJSON(ctx, s.enrichLimiter, s.apiURL(query), &detail); err != nil {
+		slog.Warn("failed to enrich plugin metadata", "slug", plugin.Slug, "error", err)
+		return plugin
+	}
+	return detail
+}
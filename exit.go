@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Process exit codes, so that cron wrappers and CI jobs can tell failure
+// categories apart.
+const (
+	exitOK      = 0 // the run completed
+	exitPartial = 1 // the run completed, but some plugins failed
+	exitConfig  = 2 // the configuration or command line is invalid
+	exitFatal   = 3 // the run was aborted, usually because the API failed
+	exitLocked  = 4 // another run holds the lock of the output directory
+
+	exitInterrupted = 130 // the run was stopped by SIGINT or SIGTERM, as 128+2
+)
+
+// partialError marks an error after which the run still completed.
+type partialError struct {
+	err error
+}
+
+func (e partialError) Error() string { return e.err.Error() }
+func (e partialError) Unwrap() error { return e.err }
+
+// partialFailure wraps err to report that the run completed with failures.
+func partialFailure(err error) error {
+	return partialError{err}
+}
+
+func isPartial(err error) bool {
+	var p partialError
+	return errors.As(err, &p)
+}
+
+// exitCode maps the error a command returned to the process exit code.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errLocked):
+		return exitLocked
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case isPartial(err):
+		return exitPartial
+	default:
+		return exitFatal
+	}
+}
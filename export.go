@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exporter receives the metadata record of every plugin of a download run
+// for --export. Calls are serialized by exportSet.
+type exporter interface {
+	export(rec MetadataRecord) error
+	// close finishes the export at the end of the run.
+	close() error
+}
+
+// exportFormats creates the exporter of an --export format=target entry
+// for a run of s.
+var exportFormats = map[string]func(s *Scraper, target string) (exporter, error){
+	"csv":           newCSVExporter,
+	"sqlite":        newSQLiteExporter,
+	"postgres":      newPostgresExporter,
+	"elasticsearch": newSearchExporter,
+	"opensearch":    newSearchExporter,
+	"parquet":       newParquetExporter,
+	"mongodb":       newMongoExporter,
+}
+
+// exportFormatNames returns the formats of --export, sorted.
+func exportFormatNames() []string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseExport splits an --export entry into its format and target.
+func parseExport(spec string) (format, target string, err error) {
+	format, target, ok := strings.Cut(spec, "=")
+	if !ok || target == "" {
+		return "", "", fmt.Errorf("export must be format=target, got %q", spec)
+	}
+	if exportFormats[format] == nil {
+		return "", "", fmt.Errorf("export format must be one of %s, got %q", strings.Join(exportFormatNames(), ", "), format)
+	}
+	return format, target, nil
+}
+
+// redactExport hides the password of an --export entry whose target is a
+// URL, for logs and errors.
+func redactExport(spec string) string {
+	format, target, _ := strings.Cut(spec, "=")
+	if u, err := url.Parse(target); err == nil && u.User != nil {
+		return format + "=" + u.Redacted()
+	}
+	return spec
+}
+
+// exportSet feeds the exporters of a run. It is safe for concurrent use by
+// the download workers, and a nil set drops every record. An exporter that
+// fails is logged and left out for the rest of the run, since the archives
+// themselves are fine.
+type exportSet struct {
+	mu sync.Mutex
+	// exporters are keyed by their redacted --export entry.
+	exporters map[string]exporter
+}
+
+// openExports opens the exporters of --export and --search-index, or returns
+// nil without any.
+func (s *Scraper) openExports() (*exportSet, error) {
+	if len(s.cfg.Export) == 0 && !s.cfg.SearchIndex {
+		return nil, nil
+	}
+	set := &exportSet{exporters: make(map[string]exporter, len(s.cfg.Export)+1)}
+	if s.cfg.SearchIndex {
+		e, err := newIndexExporter(s)
+		if err != nil {
+			return nil, err
+		}
+		set.exporters["search-index"] = e
+	}
+	for _, spec := range s.cfg.Export {
+		format, target, err := parseExport(spec)
+		if err == nil {
+			var e exporter
+			if e, err = exportFormats[format](s, target); err == nil {
+				set.exporters[redactExport(spec)] = e
+				continue
+			}
+		}
+		set.close()
+		return nil, fmt.Errorf("export %s: %w", redactExport(spec), err)
+	}
+	return set, nil
+}
+
+func (x *exportSet) record(rec MetadataRecord) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for spec, e := range x.exporters {
+		if err := e.export(rec); err != nil {
+			slog.Error("export failed, leaving it out for the rest of the run", "export", spec, "error", err)
+			e.close()
+			delete(x.exporters, spec)
+		}
+	}
+}
+
+// close finishes every exporter, logging those that fail.
+func (x *exportSet) close() {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for spec, e := range x.exporters {
+		if err := e.close(); err != nil {
+			slog.Error("failed to finish export", "export", spec, "error", err)
+		}
+		delete(x.exporters, spec)
+	}
+}
+
+// csvColumns are the columns --export-columns can choose for CSV exports.
+var csvColumns = map[string]func(rec MetadataRecord) string{
+	"slug":         func(rec MetadataRecord) string { return rec.Plugin.Slug },
+	"name":         func(rec MetadataRecord) string { return string(rec.Plugin.Name) },
+	"version":      func(rec MetadataRecord) string { return rec.Plugin.Version },
+	"author":       func(rec MetadataRecord) string { return string(rec.Plugin.Author) },
+	"installs":     func(rec MetadataRecord) string { return strconv.Itoa(rec.Plugin.ActiveInstalls) },
+	"downloaded":   func(rec MetadataRecord) string { return strconv.Itoa(rec.Plugin.Downloaded) },
+	"rating":       func(rec MetadataRecord) string { return strconv.Itoa(rec.Plugin.Rating) },
+	"num_ratings":  func(rec MetadataRecord) string { return strconv.Itoa(rec.Plugin.NumRatings) },
+	"requires":     func(rec MetadataRecord) string { return string(rec.Plugin.Requires) },
+	"tested":       func(rec MetadataRecord) string { return string(rec.Plugin.Tested) },
+	"requires_php": func(rec MetadataRecord) string { return string(rec.Plugin.RequiresPHP) },
+	"last_updated": func(rec MetadataRecord) string { return formatTimestamp(rec.Plugin.LastUpdated) },
+	"added":        func(rec MetadataRecord) string { return formatTimestamp(rec.Plugin.Added) },
+	"status":       func(rec MetadataRecord) string { return rec.Outcome },
+	"reason":       func(rec MetadataRecord) string { return rec.Reason },
+	"size":         func(rec MetadataRecord) string { return strconv.FormatInt(rec.Size, 10) },
+	"sha256":       func(rec MetadataRecord) string { return rec.SHA256 },
+	"file":         func(rec MetadataRecord) string { return rec.File },
+	"finished":     func(rec MetadataRecord) string { return rec.Finished.UTC().Format(time.RFC3339) },
+}
+
+// defaultCSVColumns are the columns of CSV exports without --export-columns.
+var defaultCSVColumns = []string{"slug", "version", "installs", "rating", "last_updated", "status"}
+
+// csvColumnNames returns the columns of CSV exports, sorted.
+func csvColumnNames() []string {
+	names := make([]string, 0, len(csvColumns))
+	for name := range csvColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatTimestamp formats t as RFC 3339 in UTC, or as an empty string if
+// the API left it out.
+func formatTimestamp(t Timestamp) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvExporter writes a CSV file with a header row and a row for every
+// plugin. The file replaces fileName when the run ends.
+type csvExporter struct {
+	fileName string
+	file     *os.File
+	w        *csv.Writer
+	columns  []string
+}
+
+func newCSVExporter(s *Scraper, fileName string) (exporter, error) {
+	if dir := filepath.Dir(fileName); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.Create(fileName + tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	e := &csvExporter{fileName: fileName, file: file, w: csv.NewWriter(file), columns: s.cfg.ExportColumns}
+	if err := e.w.Write(e.columns); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *csvExporter) export(rec MetadataRecord) error {
+	row := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		row[i] = csvColumns[column](rec)
+	}
+	if err := e.w.Write(row); err != nil {
+		return err
+	}
+	// Flushing every row reports a full disk with the row that hit it.
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExporter) close() error {
+	e.w.Flush()
+	err := e.w.Error()
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(e.file.Name())
+		return err
+	}
+	return os.Rename(e.file.Name(), e.fileName)
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE.
+const fallocKeepSize = 0x1
+
+func fallocate(file *os.File, size int64) error {
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+}
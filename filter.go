@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// matches reports whether plugin passes the configured filters, including
+// the slug patterns compiled by newScraper.
+func (s *Scraper) matches(plugin Plugin) bool {
+	if s.slugMatch != nil && !s.slugMatch.MatchString(plugin.Slug) {
+		return false
+	}
+	if s.slugExclude != nil && s.slugExclude.MatchString(plugin.Slug) {
+		return false
+	}
+	if !s.cfg.Filters.match(plugin) {
+		return false
+	}
+	if s.expression != nil {
+		ok, err := evalFilter(s.expression, plugin)
+		if err != nil {
+			slog.Debug("filter expression failed", "slug", plugin.Slug, "error", err)
+			return false
+		}
+		return ok
+	}
+	return true
+}
+
+// compileFilter compiles a --filter expression. The expression sees the
+// plugin metadata under its API field names, such as active_installs,
+// rating or tags, and must evaluate to a boolean.
+func compileFilter(expression string) (*vm.Program, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	// Compile against an empty plugin so that the expression is type checked.
+	env, err := filterEnv(Plugin{})
+	if err != nil {
+		return nil, err
+	}
+	program, err := expr.Compile(expression, expr.Env(env), expr.AllowUndefinedVariables(), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	return program, nil
+}
+
+func evalFilter(program *vm.Program, plugin Plugin) (bool, error) {
+	env, err := filterEnv(plugin)
+	if err != nil {
+		return false, err
+	}
+	out, err := expr.Run(program, env)
+	if err != nil {
+		return false, err
+	}
+	return out.(bool), nil
+}
+
+// filterEnv exposes plugin to filter expressions as its JSON representation.
+func filterEnv(plugin Plugin) (map[string]any, error) {
+	data, err := json.Marshal(plugin)
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]any{}
+	err = json.Unmarshal(data, &env)
+	return env, err
+}
+
+// match reports whether plugin satisfies every configured metadata filter.
+func (f FilterConfig) match(plugin Plugin) bool {
+	if plugin.ActiveInstalls < f.MinInstalls ||
+		plugin.Rating < f.MinRating ||
+		plugin.NumRatings < f.MinNumRatings {
+		return false
+	}
+	if len(f.IncludeTags) > 0 && !hasAnyTag(plugin.Tags, f.IncludeTags) {
+		return false
+	}
+	if hasAnyTag(plugin.Tags, f.ExcludeTags) {
+		return false
+	}
+	// A plugin that states no required version runs on any release, but one
+	// without a tested version cannot prove compatibility.
+	if f.RequiresWPMax != "" && plugin.Requires != "" && compareVersions(string(plugin.Requires), f.RequiresWPMax) > 0 {
+		return false
+	}
+	if f.TestedMin != "" && (plugin.Tested == "" || compareVersions(string(plugin.Tested), f.TestedMin) < 0) {
+		return false
+	}
+	if f.RequiresPHP != "" && plugin.RequiresPHP != "" && compareVersions(string(plugin.RequiresPHP), f.RequiresPHP) > 0 {
+		return false
+	}
+	if f.UpdatedWithin > 0 {
+		// Plugins without a known update date cannot prove their recency.
+		if plugin.LastUpdated.IsZero() || time.Since(plugin.LastUpdated.Time) > time.Duration(f.UpdatedWithin) {
+			return false
+		}
+	}
+	return true
+}
+
+// compilePattern compiles the regular expression of the named filter. An
+// empty pattern disables the filter and yields nil.
+func compilePattern(name, pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return re, nil
+}
+
+// compareVersions compares dotted version strings numerically and returns
+// -1, 0 or +1. Missing components count as zero, so "6.4" equals "6.4.0".
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := versionPart(as, i), versionPart(bs, i)
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionPart returns the numeric prefix of the i-th component of parts.
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	var n int
+	for _, r := range strings.TrimSpace(parts[i]) {
+		if r < '0' || r > '9' {
+			break
+		}
+		n = n*10 + int(r-'0')
+	}
+	return n
+}
+
+// isVersion reports whether s is a dotted numeric version such as 6.4.2.
+func isVersion(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnyTag(tags Tags, wanted []string) bool {
+	for _, tag := range wanted {
+		if tags.has(tag) {
+			return true
+		}
+	}
+	return false
+}
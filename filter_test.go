@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilterMatch(t *testing.T) {
+	plugin := Plugin{
+		Slug: "wordpress-seo", ActiveInstalls: 5_000_000, Rating: 96, NumRatings: 27_000,
+		Tags: Tags{"seo": "SEO", "xml-sitemap": "XML sitemap"}, Requires: "6.3", Tested: "6.5.2", RequiresPHP: "7.2.5",
+		LastUpdated: Timestamp{time.Now().Add(-48 * time.Hour)},
+	}
+	tests := []struct {
+		name   string
+		filter FilterConfig
+		want   bool
+	}{
+		{"no filters", FilterConfig{}, true},
+		{"all thresholds met", FilterConfig{MinInstalls: 5_000_000, MinRating: 96, MinNumRatings: 27_000}, true},
+		{"too few installs", FilterConfig{MinInstalls: 5_000_001}, false},
+		{"rating too low", FilterConfig{MinRating: 97}, false},
+		{"too few ratings", FilterConfig{MinNumRatings: 30_000}, false},
+		{"included tag", FilterConfig{IncludeTags: []string{"forms", "seo"}}, true},
+		{"no included tag", FilterConfig{IncludeTags: []string{"forms"}}, false},
+		{"excluded tag", FilterConfig{ExcludeTags: []string{"xml-sitemap"}}, false},
+		{"requires an older WordPress", FilterConfig{RequiresWPMax: "6.4"}, true},
+		{"requires a newer WordPress", FilterConfig{RequiresWPMax: "6.2"}, false},
+		{"tested against 6.5", FilterConfig{TestedMin: "6.5"}, true},
+		{"not tested against 6.6", FilterConfig{TestedMin: "6.6"}, false},
+		{"requires PHP 7.2.5", FilterConfig{RequiresPHP: "7.4"}, true},
+		{"requires a newer PHP", FilterConfig{RequiresPHP: "7.2"}, false},
+		{"updated recently", FilterConfig{UpdatedWithin: Duration(72 * time.Hour)}, true},
+		{"not updated recently", FilterConfig{UpdatedWithin: Duration(24 * time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(plugin); got != tt.want {
+				t.Fatalf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	unknown := Plugin{Slug: "hello-dolly"}
+	if !(FilterConfig{RequiresWPMax: "6.0", RequiresPHP: "7.0"}).match(unknown) {
+		t.Error("a plugin without requirements was rejected")
+	}
+	if (FilterConfig{TestedMin: "6.0"}).match(unknown) || (FilterConfig{UpdatedWithin: Duration(time.Hour)}).match(unknown) {
+		t.Error("a plugin without tested version or update date was accepted")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"6.4", "6.4.0", 0},
+		{"6.4.1", "6.4", 1},
+		{"6.10", "6.9", 1},
+		{"5.3.1-beta", "5.3.2", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcsAPIURL    = "https://storage.googleapis.com/storage/v1"
+	gcsUploadURL = "https://storage.googleapis.com/upload/storage/v1"
+	gcsScope     = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// gcsChunkAlign is the granularity of the chunks of a resumable upload.
+	gcsChunkAlign = 256 << 10
+
+	// gcsResumeIncomplete is the status of an accepted chunk of an upload
+	// that is not complete yet.
+	gcsResumeIncomplete = 308
+
+	// maxGCSChunkRetries caps how often a chunk is sent again within one
+	// upload session before the upload fails.
+	maxGCSChunkRetries = 3
+)
+
+// gcsStorage stores files in a Google Cloud Storage bucket with resumable
+// uploads, sent in chunks of --gcs-chunk-size.
+type gcsStorage struct {
+	client    *http.Client
+	bucket    string
+	prefix    string
+	chunkSize int64
+}
+
+// newGCSStorage returns the storage of a gs://bucket/prefix target. It
+// authenticates with the service account key file --gcs-credentials, or
+// else with the application default credentials.
+func newGCSStorage(ctx context.Context, cfg Config, client *http.Client, target *url.URL) (*gcsStorage, error) {
+	// The token requests use the same transport as the storage requests.
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	var creds *google.Credentials
+	var err error
+	if cfg.GCSCredentials != "" {
+		var data []byte
+		if data, err = os.ReadFile(cfg.GCSCredentials); err != nil {
+			return nil, fmt.Errorf("gcs-credentials: %w", err)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data, gcsScope)
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, gcsScope)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("google cloud credentials: %w", err)
+	}
+	return &gcsStorage{
+		client: &http.Client{
+			Transport: &oauth2.Transport{Source: creds.TokenSource, Base: client.Transport},
+			Timeout:   client.Timeout,
+		},
+		bucket:    target.Host,
+		prefix:    strings.Trim(target.Path, "/"),
+		chunkSize: int64(cfg.GCSChunkSize),
+	}, nil
+}
+
+func (g *gcsStorage) objectName(name string) string {
+	return path.Join(g.prefix, name)
+}
+
+// Put starts a resumable upload session for name and sends r in chunks.
+// A chunk that fails is sent again from the offset the session reports,
+// so a broken connection does not restart the whole upload.
+func (g *gcsStorage) Put(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error {
+	chunk := func(offset, n int64) (io.Reader, error) {
+		return section(r, offset, n), nil
+	}
+	if _, ok := r.(io.ReaderAt); !ok {
+		chunk = (&streamChunks{r: r}).chunk
+	}
+
+	session, err := g.startUpload(ctx, name, size, meta)
+	if err != nil {
+		return fmt.Errorf("start resumable upload: %w", err)
+	}
+	var offset int64
+	for retries := 0; ; {
+		n := min(g.chunkSize, size-offset)
+		body, err := chunk(offset, n)
+		if err != nil {
+			return err
+		}
+		received, done, err := g.sendChunk(ctx, session, body, offset, n, size)
+		if err != nil {
+			if retries++; retries > maxGCSChunkRetries || !retryable(err) || ctx.Err() != nil {
+				return fmt.Errorf("upload bytes %d-%d: %w", offset, offset+n, err)
+			}
+			var qerr error
+			if received, done, qerr = g.queryUpload(ctx, session, size); qerr != nil {
+				return fmt.Errorf("upload bytes %d-%d: %w", offset, offset+n, err)
+			}
+		} else {
+			retries = 0
+		}
+		if done {
+			return nil
+		}
+		offset = received
+	}
+}
+
+// startUpload creates the object name with its content type and metadata
+// and returns the URI of the upload session.
+func (g *gcsStorage) startUpload(ctx context.Context, name string, size int64, meta map[string]string) (string, error) {
+	mediaType := contentType(name)
+	body, err := json.Marshal(struct {
+		Name        string            `json:"name"`
+		ContentType string            `json:"contentType"`
+		Metadata    map[string]string `json:"metadata,omitempty"`
+	}{g.objectName(name), mediaType, meta})
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{"uploadType": {"resumable"}, "name": {g.objectName(name)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		gcsUploadURL+"/b/"+url.PathEscape(g.bucket)+"/o?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", mediaType)
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	resp, err := g.send(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", fmt.Errorf("no upload session in the response")
+	}
+	return session, nil
+}
+
+// sendChunk sends the n bytes of chunk at offset of a size byte upload. It
+// returns how many bytes the session received so far, and whether the
+// upload is complete.
+func (g *gcsStorage) sendChunk(ctx context.Context, session string, chunk io.Reader, offset, n, size int64) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, chunk)
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = n
+	if n == 0 {
+		req.Body = http.NoBody
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+	}
+	return g.progress(req, size)
+}
+
+// queryUpload asks the session how many bytes it received, and whether the
+// upload is complete.
+func (g *gcsStorage) queryUpload(ctx context.Context, session string, size int64) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session, http.NoBody)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	return g.progress(req, size)
+}
+
+// progress sends a request to an upload session and returns the number of
+// bytes the session holds, which may be fewer than were sent, and whether
+// the upload is complete.
+func (g *gcsStorage) progress(req *http.Request, size int64) (int64, bool, error) {
+	resp, err := g.send(req)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != gcsResumeIncomplete {
+		return size, true, nil
+	}
+	// A Range of "bytes=0-41" means 42 bytes were received; without one,
+	// none were.
+	_, last, ok := strings.Cut(resp.Header.Get("Range"), "-")
+	if !ok {
+		return 0, false, nil
+	}
+	received, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("unexpected Range %q", resp.Header.Get("Range"))
+	}
+	return received + 1, false, nil
+}
+
+// objectURL returns the JSON API URL of the object name.
+func (g *gcsStorage) objectURL(name string) string {
+	return gcsAPIURL + "/b/" + url.PathEscape(g.bucket) + "/o/" + url.PathEscape(g.objectName(name))
+}
+
+// gcsObject is the part of an object resource the storage uses.
+type gcsObject struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size,string"`
+	Updated time.Time `json:"updated"`
+}
+
+func (g *gcsStorage) Exists(ctx context.Context, name string) (bool, error) {
+	return exists(g.Stat(ctx, name))
+}
+
+func (g *gcsStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(name)+"?fields=size,updated", nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	var object gcsObject
+	if err := g.sendJSON(req, &object); err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Name: name, Size: object.Size, Modified: object.Updated}, nil
+}
+
+func (g *gcsStorage) Delete(ctx context.Context, name string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, g.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := g.send(req)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List pages through the objects below the prefix.
+func (g *gcsStorage) List(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	root := ""
+	if g.prefix != "" {
+		root = g.prefix + "/"
+	}
+	query := url.Values{"prefix": {root + prefix}, "fields": {"items(name,size,updated),nextPageToken"}}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			gcsAPIURL+"/b/"+url.PathEscape(g.bucket)+"/o?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Items         []gcsObject `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := g.sendJSON(req, &page); err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		for _, object := range page.Items {
+			info := FileInfo{Name: strings.TrimPrefix(object.Name, root), Size: object.Size, Modified: object.Updated}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// send sends req and returns responses other than 2xx and 308 as errors.
+func (g *gcsStorage) send(req *http.Request) (*http.Response, error) {
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && resp.StatusCode != gcsResumeIncomplete {
+		defer resp.Body.Close()
+		return nil, gcsError(resp)
+	}
+	return resp, nil
+}
+
+// sendJSON is send for requests that answer with a JSON document, which is
+// decoded into v.
+func (g *gcsStorage) sendJSON(req *http.Request, v any) error {
+	resp, err := g.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// streamChunks holds the chunks of an upload whose content is read from a
+// stream: the bytes from the offset the session has received so far on,
+// which may have to be sent again.
+type streamChunks struct {
+	r      io.Reader
+	offset int64
+	buf    []byte
+}
+
+// chunk returns the n bytes at offset, which is never before the offset of
+// the previous chunk.
+func (c *streamChunks) chunk(offset, n int64) (io.Reader, error) {
+	c.buf = c.buf[offset-c.offset:]
+	c.offset = offset
+	if missing := n - int64(len(c.buf)); missing > 0 {
+		more := make([]byte, missing)
+		if _, err := io.ReadFull(c.r, more); err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, more...)
+	}
+	return bytes.NewReader(c.buf[:n]), nil
+}
+
+// gcsError returns the error of a failed request, including the message of
+// the JSON error GCS sends in the body. Objects that do not exist are
+// reported as fs.ErrNotExist.
+func gcsError(resp *http.Response) error {
+	where := resp.Request.Method + " " + resp.Request.URL.Redacted()
+	method := resp.Request.Method
+	if resp.StatusCode == http.StatusNotFound && (method == http.MethodGet || method == http.MethodDelete) {
+		return fmt.Errorf("%s: %w", where, fs.ErrNotExist)
+	}
+	status := &statusError{code: resp.StatusCode, status: resp.Status}
+	var doc struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &doc) != nil || doc.Error.Message == "" {
+		return fmt.Errorf("%s: %w", where, status)
+	}
+	return fmt.Errorf("%s: %w: %s", where, status, doc.Error.Message)
+}
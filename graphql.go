@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+// graphqlSchema describes the metadata store to `serve graphql`. Counts
+// that can outgrow the 32 bits of Int, such as downloaded and size, are
+// Float, and arguments of kind default to the --kind of the server.
+const graphqlSchema = `
+scalar Time
+
+schema {
+	query: Query
+}
+
+type Query {
+	"The plugin with slug, or null if the store has none."
+	plugin(kind: String, slug: String!): Plugin
+	"Plugins, most installed first. query matches slugs and names, outcome the latest download."
+	plugins(kind: String, query: String, outcome: String, minInstalls: Int, first: Int = 50, offset: Int = 0): PluginPage!
+	"Download attempts, latest first."
+	downloads(kind: String, slug: String, version: String, outcome: String, first: Int = 50, offset: Int = 0): [Download!]!
+	"Download runs of every kind, latest first."
+	runs(kind: String, first: Int = 50, offset: Int = 0): [Run!]!
+	run(id: ID!): Run
+}
+
+type PluginPage {
+	totalCount: Int!
+	nodes: [Plugin!]!
+}
+
+type Plugin {
+	kind: String!
+	slug: String!
+	name: String!
+	version: String!
+	author: String!
+	activeInstalls: Int!
+	downloaded: Float!
+	rating: Int!
+	numRatings: Int!
+	requires: String!
+	tested: String!
+	requiresPHP: String!
+	lastUpdated: Time
+	added: Time
+	"When a run last saw the plugin."
+	seen: Time!
+	"The full plugin_information response, as JSON."
+	metadata: String!
+	"The outcome of the latest download: downloaded, skipped or failed."
+	outcome: String!
+	versions: [Version!]!
+	downloads(first: Int = 20): [Download!]!
+}
+
+type Version {
+	version: String!
+	downloadLink: String!
+}
+
+type Download {
+	id: ID!
+	run: Run
+	kind: String!
+	slug: String!
+	version: String!
+	"downloaded, skipped or failed, or pruned for an archive prune removed."
+	outcome: String!
+	reason: String!
+	size: Float!
+	sha256: String!
+	"The archive, relative to the output directory."
+	file: String!
+	cid: String!
+	"The recompressed archive of --repack, relative to the output directory."
+	repacked: String!
+	started: Time!
+	finished: Time!
+	"The state of the archive in the output directory, checked when queried by reading it; null without an archive."
+	archive: Archive
+}
+
+type Archive {
+	"verified, missing, corrupt or mismatch."
+	status: String!
+	"What failed a corrupt or mismatched archive."
+	problem: String!
+	"The archive or its recompressed copy on disk, relative to the output directory."
+	path: String
+	"A copy of an archive at the same path that failed validation and was moved below quarantine/."
+	quarantined: String
+}
+
+type Run {
+	id: ID!
+	kind: String!
+	started: Time!
+	finished: Time
+	downloads(outcome: String, first: Int = 50, offset: Int = 0): [Download!]!
+}
+`
+
+// graphqlPageLimit caps the first argument, so that a query cannot read the
+// whole store in one response.
+const graphqlPageLimit = 1000
+
+// page clamps the first and offset arguments of a list.
+func page(first, offset int32) (int, int) {
+	return int(min(max(first, 0), graphqlPageLimit)), int(max(offset, 0))
+}
+
+// graphqlResolver resolves the queries of graphqlSchema against a store.
+type graphqlResolver struct {
+	store *metadataStore
+	// kind is the kind of arguments that leave it out.
+	kind string
+	// outputDir holds the archives the downloads are checked against.
+	outputDir string
+}
+
+func (r *graphqlResolver) kindOr(kind *string) string {
+	if kind == nil {
+		return r.kind
+	}
+	return *kind
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// optional is s, or null if it is empty.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func graphqlTime(t *time.Time) *graphql.Time {
+	if t == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *t}
+}
+
+func (r *graphqlResolver) Plugin(ctx context.Context, args struct {
+	Kind *string
+	Slug string
+}) (*pluginResolver, error) {
+	p, err := r.store.plugin(ctx, r.kindOr(args.Kind), args.Slug)
+	if p == nil || err != nil {
+		return nil, err
+	}
+	return &pluginResolver{r, *p}, nil
+}
+
+func (r *graphqlResolver) Plugins(ctx context.Context, args struct {
+	Kind        *string
+	Query       *string
+	Outcome     *string
+	MinInstalls *int32
+	First       int32
+	Offset      int32
+}) (*pluginPageResolver, error) {
+	f := pluginFilter{Kind: r.kindOr(args.Kind), Query: deref(args.Query), Outcome: deref(args.Outcome)}
+	if args.MinInstalls != nil {
+		f.MinInstalls = int64(*args.MinInstalls)
+	}
+	f.Limit, f.Offset = page(args.First, args.Offset)
+	plugins, total, err := r.store.plugins(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	res := &pluginPageResolver{total: int32(total), nodes: make([]*pluginResolver, len(plugins))}
+	for i, p := range plugins {
+		res.nodes[i] = &pluginResolver{r, p}
+	}
+	return res, nil
+}
+
+func (r *graphqlResolver) Downloads(ctx context.Context, args struct {
+	Kind    *string
+	Slug    *string
+	Version *string
+	Outcome *string
+	First   int32
+	Offset  int32
+}) ([]*downloadResolver, error) {
+	f := downloadFilter{Kind: r.kindOr(args.Kind), Slug: deref(args.Slug), Version: deref(args.Version), Outcome: deref(args.Outcome)}
+	f.Limit, f.Offset = page(args.First, args.Offset)
+	return r.downloads(ctx, f)
+}
+
+func (r *graphqlResolver) downloads(ctx context.Context, f downloadFilter) ([]*downloadResolver, error) {
+	downloads, err := r.store.downloads(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*downloadResolver, len(downloads))
+	for i, d := range downloads {
+		res[i] = &downloadResolver{r, d}
+	}
+	return res, nil
+}
+
+func (r *graphqlResolver) Runs(ctx context.Context, args struct {
+	Kind   *string
+	First  int32
+	Offset int32
+}) ([]*runResolver, error) {
+	limit, offset := page(args.First, args.Offset)
+	runs, err := r.store.runs(ctx, deref(args.Kind), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*runResolver, len(runs))
+	for i, run := range runs {
+		res[i] = &runResolver{r, run}
+	}
+	return res, nil
+}
+
+func (r *graphqlResolver) Run(ctx context.Context, args struct{ ID graphql.ID }) (*runResolver, error) {
+	id, err := strconv.ParseInt(string(args.ID), 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	return r.run(ctx, id)
+}
+
+func (r *graphqlResolver) run(ctx context.Context, id int64) (*runResolver, error) {
+	run, err := r.store.run(ctx, id)
+	if run == nil || err != nil {
+		return nil, err
+	}
+	return &runResolver{r, *run}, nil
+}
+
+type pluginPageResolver struct {
+	total int32
+	nodes []*pluginResolver
+}
+
+func (p *pluginPageResolver) TotalCount() int32        { return p.total }
+func (p *pluginPageResolver) Nodes() []*pluginResolver { return p.nodes }
+
+type pluginResolver struct {
+	r *graphqlResolver
+	p storedPlugin
+}
+
+func (p *pluginResolver) Kind() string               { return p.p.Kind }
+func (p *pluginResolver) Slug() string               { return p.p.Slug }
+func (p *pluginResolver) Name() string               { return p.p.Name }
+func (p *pluginResolver) Version() string            { return p.p.Version }
+func (p *pluginResolver) Author() string             { return p.p.Author }
+func (p *pluginResolver) ActiveInstalls() int32      { return int32(min(p.p.ActiveInstalls, 1<<31-1)) }
+func (p *pluginResolver) Downloaded() float64        { return float64(p.p.Downloaded) }
+func (p *pluginResolver) Rating() int32              { return p.p.Rating }
+func (p *pluginResolver) NumRatings() int32          { return p.p.NumRatings }
+func (p *pluginResolver) Requires() string           { return p.p.Requires }
+func (p *pluginResolver) Tested() string             { return p.p.Tested }
+func (p *pluginResolver) RequiresPHP() string        { return p.p.RequiresPHP }
+func (p *pluginResolver) LastUpdated() *graphql.Time { return graphqlTime(p.p.LastUpdated) }
+func (p *pluginResolver) Added() *graphql.Time       { return graphqlTime(p.p.Added) }
+func (p *pluginResolver) Seen() graphql.Time         { return graphql.Time{Time: p.p.Seen} }
+func (p *pluginResolver) Metadata() string           { return p.p.Metadata }
+func (p *pluginResolver) Outcome() string            { return p.p.Outcome }
+
+func (p *pluginResolver) Versions(ctx context.Context) ([]*versionResolver, error) {
+	versions, err := p.r.store.versions(ctx, p.p.Kind, p.p.Slug)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*versionResolver, len(versions))
+	for i, v := range versions {
+		res[i] = &versionResolver{v}
+	}
+	return res, nil
+}
+
+func (p *pluginResolver) Downloads(ctx context.Context, args struct{ First int32 }) ([]*downloadResolver, error) {
+	limit, _ := page(args.First, 0)
+	return p.r.downloads(ctx, downloadFilter{Kind: p.p.Kind, Slug: p.p.Slug, Limit: limit})
+}
+
+type versionResolver struct {
+	v storedVersion
+}
+
+func (v *versionResolver) Version() string      { return v.v.Version }
+func (v *versionResolver) DownloadLink() string { return v.v.DownloadLink }
+
+type downloadResolver struct {
+	r *graphqlResolver
+	d storedDownload
+}
+
+func (d *downloadResolver) ID() graphql.ID { return graphql.ID(strconv.FormatInt(d.d.ID, 10)) }
+func (d *downloadResolver) Run(ctx context.Context) (*runResolver, error) {
+	return d.r.run(ctx, d.d.RunID)
+}
+func (d *downloadResolver) Kind() string           { return d.d.Kind }
+func (d *downloadResolver) Slug() string           { return d.d.Slug }
+func (d *downloadResolver) Version() string        { return d.d.Version }
+func (d *downloadResolver) Outcome() string        { return d.d.Outcome }
+func (d *downloadResolver) Reason() string         { return d.d.Reason }
+func (d *downloadResolver) Size() float64          { return float64(d.d.Size) }
+func (d *downloadResolver) SHA256() string         { return d.d.SHA256 }
+func (d *downloadResolver) File() string           { return d.d.File }
+func (d *downloadResolver) CID() string            { return d.d.CID }
+func (d *downloadResolver) Repacked() string       { return d.d.Repacked }
+func (d *downloadResolver) Started() graphql.Time  { return graphql.Time{Time: d.d.Started} }
+func (d *downloadResolver) Finished() graphql.Time { return graphql.Time{Time: d.d.Finished} }
+
+// Archive checks the archive of the download when the field is queried.
+func (d *downloadResolver) Archive() *archiveResolver {
+	if d.d.File == "" {
+		return nil
+	}
+	return &archiveResolver{checkDownload(d.r.outputDir, d.d)}
+}
+
+type archiveResolver struct {
+	c archiveCheck
+}
+
+func (a *archiveResolver) Status() string       { return a.c.Status }
+func (a *archiveResolver) Problem() string      { return a.c.Problem }
+func (a *archiveResolver) Path() *string        { return optional(a.c.Path) }
+func (a *archiveResolver) Quarantined() *string { return optional(a.c.Quarantined) }
+
+type runResolver struct {
+	r   *graphqlResolver
+	run storedRun
+}
+
+func (r *runResolver) ID() graphql.ID          { return graphql.ID(strconv.FormatInt(r.run.ID, 10)) }
+func (r *runResolver) Kind() string            { return r.run.Kind }
+func (r *runResolver) Started() graphql.Time   { return graphql.Time{Time: r.run.Started} }
+func (r *runResolver) Finished() *graphql.Time { return graphqlTime(r.run.Finished) }
+
+func (r *runResolver) Downloads(ctx context.Context, args struct {
+	Outcome *string
+	First   int32
+	Offset  int32
+}) ([]*downloadResolver, error) {
+	f := downloadFilter{RunID: r.run.ID, Outcome: deref(args.Outcome)}
+	f.Limit, f.Offset = page(args.First, args.Offset)
+	return r.r.downloads(ctx, f)
+}
+
+// graphqlHandler executes the queries of POST requests with a JSON body of
+// query, operationName and variables, as most GraphQL clients send them, and
+// of GET requests with the same URL parameters, for curl.
+func graphqlHandler(schema *graphql.Schema) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			Query         string         `json:"query"`
+			OperationName string         `json:"operationName"`
+			Variables     map[string]any `json:"variables"`
+		}
+		switch r.Method {
+		case http.MethodGet:
+			q := r.URL.Query()
+			params.Query, params.OperationName = q.Get("query"), q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &params.Variables); err != nil {
+					http.Error(w, "variables: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		resp := schema.Exec(r.Context(), params.Query, params.OperationName, params.Variables)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// newGraphQLHandler returns the handler of `serve graphql` for store.
+func (s *Scraper) newGraphQLHandler(store *metadataStore) (http.Handler, error) {
+	schema, err := graphql.ParseSchema(graphqlSchema, &graphqlResolver{store: store, kind: s.dir.name, outputDir: s.cfg.OutputDir})
+	if err != nil {
+		return nil, err
+	}
+	return graphqlHandler(schema), nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// inFlight limits the bytes that all transfers together may still have to
+// receive. A nil *inFlight does not limit at all.
+type inFlight struct {
+	sem    *semaphore.Weighted
+	budget int64
+}
+
+// newInFlight returns a budget of budget bytes, or nil if budget is zero.
+func newInFlight(budget ByteSize) *inFlight {
+	if budget <= 0 {
+		return nil
+	}
+	return &inFlight{sem: semaphore.NewWeighted(int64(budget)), budget: int64(budget)}
+}
+
+// acquire blocks until n more bytes fit into the budget and returns the
+// function that gives them back. Transfers of unknown size count as one copy
+// buffer, and transfers larger than the whole budget take all of it, so
+// that they run on their own instead of never.
+func (f *inFlight) acquire(ctx context.Context, n int64) (func(), error) {
+	if f == nil {
+		return func() {}, nil
+	}
+	n = min(max(n, copyBufferSize), f.budget)
+	if !f.sem.TryAcquire(n) {
+		slog.Debug("waiting for in-flight budget", "bytes", n)
+		if err := f.sem.Acquire(ctx, n); err != nil {
+			return nil, err
+		}
+	}
+	return func() { f.sem.Release(n) }, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// ipfsNode adds archives to an IPFS node through the RPC API of Kubo, and
+// copies them into a directory of its mutable file system, whose CID is the
+// root of the whole snapshot.
+type ipfsNode struct {
+	client *http.Client
+	api    string
+	// dir is the MFS directory the archives are copied into, or empty.
+	dir string
+}
+
+// newIPFSNode returns the node of --ipfs-api, or nil without one.
+func newIPFSNode(cfg Config, client *http.Client) *ipfsNode {
+	if cfg.IPFSAPI == "" {
+		return nil
+	}
+	return &ipfsNode{
+		client: client,
+		api:    strings.TrimSuffix(cfg.IPFSAPI, "/") + "/api/v0/",
+		dir:    strings.TrimSuffix(cfg.IPFSDir, "/"),
+	}
+}
+
+// add adds and pins the file fileName, copies it to name below the MFS
+// directory and returns its CID.
+func (n *ipfsNode) add(ctx context.Context, name, fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// The archive is streamed into the multipart body rather than buffered.
+	body, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		part, err := form.CreateFormFile("file", path.Base(name))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	query := url.Values{"pin": {"true"}, "cid-version": {"1"}, "quieter": {"true"}}
+	var added struct {
+		Hash string
+	}
+	err = n.call(ctx, "add", query, body, form.FormDataContentType(), &added)
+	body.Close()
+	if err != nil {
+		return "", err
+	}
+	if n.dir == "" {
+		return added.Hash, nil
+	}
+
+	target := n.dir + "/" + name
+	if err := n.call(ctx, "files/mkdir", url.Values{"arg": {path.Dir(target)}, "parents": {"true"}}, nil, "", nil); err != nil {
+		return "", err
+	}
+	// files/cp does not replace an existing file.
+	n.call(ctx, "files/rm", url.Values{"arg": {target}, "force": {"true"}}, nil, "", nil)
+	if err := n.call(ctx, "files/cp", url.Values{"arg": {"/ipfs/" + added.Hash, target}}, nil, "", nil); err != nil {
+		return "", err
+	}
+	return added.Hash, nil
+}
+
+// root returns the CID of the MFS directory, which holds the archives of
+// this and all earlier runs.
+func (n *ipfsNode) root(ctx context.Context) (string, error) {
+	var stat struct {
+		Hash string
+	}
+	if err := n.call(ctx, "files/stat", url.Values{"arg": {n.dir}, "hash": {"true"}}, nil, "", &stat); err != nil {
+		return "", err
+	}
+	return stat.Hash, nil
+}
+
+// call sends the RPC command with the given arguments and body and decodes
+// the JSON answer into v, if not nil. Failed commands are returned with the
+// message of the node.
+func (n *ipfsNode) call(ctx context.Context, command string, query url.Values, body io.Reader, contentType string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.api+command+"?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ipfs %s: %w", command, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		status := &statusError{code: resp.StatusCode, status: resp.Status}
+		var doc struct {
+			Message string
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &doc) != nil || doc.Message == "" {
+			return fmt.Errorf("ipfs %s: %w", command, status)
+		}
+		return fmt.Errorf("ipfs %s: %w: %s", command, status, doc.Message)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("ipfs %s: %w", command, err)
+	}
+	return nil
+}
+
+// addToIPFS adds the archive of plugin at fileName to the IPFS node and
+// returns its CID. A failure only costs a warning and an empty CID, as the
+// archive itself is stored.
+func (s *Scraper) addToIPFS(ctx context.Context, plugin Plugin, fileName string) string {
+	name, err := s.storageName(fileName)
+	if err == nil {
+		var cid string
+		if cid, err = s.ipfs.add(ctx, name, fileName); err == nil {
+			slog.Debug("added archive to IPFS", "slug", plugin.Slug, "version", plugin.Version, "cid", cid)
+			return cid
+		}
+	}
+	slog.Warn("failed to add archive to IPFS", "slug", plugin.Slug, "version", plugin.Version, "error", err)
+	return ""
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localStorage keeps files in a directory of the local filesystem: the
+// output directory, or the directory of a file:// --output.
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(root string) *localStorage {
+	return &localStorage{root: root}
+}
+
+func (l *localStorage) path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(name))
+}
+
+// Put writes r next to name and renames it once it is complete. The
+// object metadata is not stored.
+func (l *localStorage) Put(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error {
+	fileName := l.path(name)
+	if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+		return err
+	}
+	return writeAtomic(fileName, func(w io.Writer) error {
+		_, err := io.CopyN(w, contextReader{ctx, r}, size)
+		return err
+	})
+}
+
+func (l *localStorage) Exists(ctx context.Context, name string) (bool, error) {
+	return exists(l.Stat(ctx, name))
+}
+
+func (l *localStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	info, err := os.Stat(l.path(name))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Name: name, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+func (l *localStorage) Delete(ctx context.Context, name string) error {
+	if err := os.Remove(l.path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks the directory the prefix points into, skipping the files
+// that are still being written.
+func (l *localStorage) List(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	dir := l.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = l.path(prefix[:i])
+	}
+	return filepath.WalkDir(dir, func(fileName string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && fileName == dir {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(fileName, tmpSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, fileName)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(FileInfo{Name: name, Size: info.Size(), Modified: info.ModTime()})
+	})
+}
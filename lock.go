@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lockFile is the advisory lock that keeps two runs from writing to the same
+// output directory at once. It holds the process ID of the run that owns it.
+const lockFile = ".wpscraper.lock"
+
+// lockPollInterval is how often a run waiting for the lock tries again.
+const lockPollInterval = time.Second
+
+// errLocked reports that another run holds the lock of the output directory.
+var errLocked = errors.New("output directory is locked by another run")
+
+// lockOutput takes the lock of dir, waiting up to wait for another run to
+// release it. The returned function releases the lock.
+func lockOutput(ctx context.Context, dir string, wait time.Duration) (func(), error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create output directory: %w", err)
+	}
+	path := filepath.Join(dir, lockFile)
+	deadline := time.Now().Add(wait)
+	for waiting := false; ; waiting = true {
+		release, err := tryLock(path)
+		if !errors.Is(err, errLocked) {
+			return release, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w: %s is held by process %s", errLocked, path, lockOwner(path))
+		}
+		if !waiting {
+			slog.Info("waiting for another run to release the output directory", "lock", path,
+				"pid", lockOwner(path), "wait", wait)
+		}
+		if err := sleep(ctx, lockPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lockOwner returns the process ID recorded in the lock file at path.
+func lockOwner(path string) string {
+	data, err := os.ReadFile(path)
+	if pid := strings.TrimSpace(string(data)); err == nil && pid != "" {
+		return pid
+	}
+	return "unknown"
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// tryLock creates path as a PID file. Unlike an flock it survives a crashed
+// run and then has to be removed by hand.
+func tryLock(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, errLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	file.Close()
+	return func() {
+		os.Remove(path)
+	}, nil
+}
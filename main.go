@@ -1,113 +1,192 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
 	"os"
-	"sync"
+	"regexp"
+	"strings"
+	"text/template"
 	"time"
-)
 
-const (
-	baseURL      = "https://api.wordpress.org/plugins/info/1.2/?action=query_plugins&request[page]=%d"
-	minInstalls  = 1000
-	maxWorkers   = 5
-	requestRetry = 3
+	"github.com/expr-lang/expr/vm"
 )
 
-type Plugin struct {
-	Slug          string `json:"slug"`
-	Version       string `json:"version"`
-	DownloadLink  string `json:"download_link"`
-	ActiveInstalls int   `json:"active_installs"`
-}
-
-type PluginList struct {
-	Plugins []Plugin `json:"plugins"`
+// defaultCommand runs when no command is given, which keeps the original
+// "download everything" behaviour of a bare invocation.
+const defaultCommand = "download"
+
+// Scraper carries the configuration and shared state of a run.
+type Scraper struct {
+	cfg Config
+	dir directory
+	// client sends the API requests, listClient the directory pages that
+	// are decoded as a stream, and downloadClient the archive and language
+	// pack downloads.
+	client         *http.Client
+	listClient     *http.Client
+	downloadClient *http.Client
+	// limiter paces API requests and downloadLimiter archive downloads.
+	limiter         *rateLimiter
+	downloadLimiter *rateLimiter
+	// enrichLimiter paces the plugin_information calls of --enrich.
+	enrichLimiter *rateLimiter
+	// bandwidth caps the combined throughput of all downloads.
+	bandwidth *throttle
+	// inFlight caps the bytes all downloads together still have to receive.
+	inFlight *inFlight
+	names    *template.Template
+	allow    slugSet
+	block    slugSet
+
+	slugMatch   *regexp.Regexp
+	slugExclude *regexp.Regexp
+	expression  *vm.Program
+
+	// pause holds back all requests while a server asked to back off.
+	pause pauseGate
+
+	// sums collects the SHA-256 of the archives of a download run.
+	sums *checksums
+	// checkpoint records the progress of a download run for --resume.
+	checkpoint *checkpoint
+	// manifest lists the archives of a download run, and pinned the
+	// archives --from-manifest expects, by slug@version.
+	manifest *manifest
+	pinned   map[string]ManifestEntry
+	// previous holds the manifest entries of the previous run with
+	// --output, by slug@version.
+	previous map[string]ManifestEntry
+	// metadata is the metadata.jsonl of a download run, and exports the
+	// --export targets, or nil.
+	metadata *metadataLog
+	exports  *exportSet
+	// storage keeps the files of the run: the output directory, or the
+	// target of --output, to which they are published.
+	storage Storage
+	// ipfs is the IPFS node of --ipfs-api, or nil.
+	ipfs *ipfsNode
 }
 
-func fetchPluginList(pageNumber int) (PluginList, error) {
-	var pluginList PluginList
-
-	url := fmt.Sprintf(baseURL, pageNumber)
-	resp, err := http.Get(url)
+func newScraper(cfg Config) (*Scraper, error) {
+	names, err := parseNameTemplate(cfg.NameTemplate)
 	if err != nil {
-		return pluginList, err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return pluginList, fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	dir, ok := lookupDirectory(cfg.Kind)
+	if !ok {
+		return nil, fmt.Errorf("kind must be one of %s, got %q", directoryNames(), cfg.Kind)
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&pluginList)
-	return pluginList, err
+	s := &Scraper{
+		cfg:             cfg,
+		dir:             dir,
+		names:           names,
+		bandwidth:       newThrottle(cfg.MaxBandwidth),
+		inFlight:        newInFlight(cfg.MaxInFlight),
+		limiter:         newRateLimiter(cfg.RateLimit, cfg.RateBurst, cfg.AdaptiveRate),
+		downloadLimiter: newRateLimiter(cfg.DownloadRateLimit, cfg.DownloadRateBurst, cfg.AdaptiveRate),
+		enrichLimiter:   newRateLimiter(cfg.EnrichRateLimit, cfg.RateBurst, cfg.AdaptiveRate),
+	}
+	s.client, s.listClient, s.downloadClient = newHTTPClients(cfg)
+	if s.storage, err = newStorage(cfg, s.downloadClient); err != nil {
+		return nil, err
+	}
+	s.ipfs = newIPFSNode(cfg, s.downloadClient)
+	if s.slugMatch, err = compilePattern("slug-match", cfg.Filters.SlugMatch); err != nil {
+		return nil, err
+	}
+	if s.slugExclude, err = compilePattern("slug-exclude", cfg.Filters.SlugExclude); err != nil {
+		return nil, err
+	}
+	if s.expression, err = compileFilter(cfg.Filters.Expression); err != nil {
+		return nil, err
+	}
+	if cfg.Allowlist != "" {
+		if s.allow, err = readSlugSet(cfg.Allowlist); err != nil {
+			return nil, fmt.Errorf("allowlist: %w", err)
+		}
+	}
+	if cfg.Blocklist != "" {
+		if s.block, err = readSlugSet(cfg.Blocklist); err != nil {
+			return nil, fmt.Errorf("blocklist: %w", err)
+		}
+	}
+	return s, nil
 }
 
-func downloadPlugin(plugin Plugin, wg *sync.WaitGroup) {
-	defer wg.Done()
+func main() {
+	args := os.Args[1:]
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
 
-	resp, err := http.Get(plugin.DownloadLink)
-	if err != nil {
-		fmt.Printf("Failed to download %s: %v\n", plugin.Slug, err)
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(exitConfig)
+	}
+
+	cfg, err := loadConfig(cmd.name, args)
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
-	defer resp.Body.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitConfig)
+	}
 
-	fileName := fmt.Sprintf("%s-%s.zip", plugin.Slug, plugin.Version)
-	file, err := os.Create(fileName)
+	logger, err := newLogger(os.Stderr, cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		fmt.Printf("Failed to create file %s: %v\n", fileName, err)
-		return
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitConfig)
 	}
-	defer file.Close()
+	slog.SetDefault(logger)
 
-	_, err = io.Copy(file, resp.Body)
+	s, err := newScraper(cfg)
 	if err != nil {
-		fmt.Printf("Failed to write file %s: %v\n", fileName, err)
-		return
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitConfig)
 	}
 
-	fmt.Printf("Downloaded %s version %s\n", plugin.Slug, plugin.Version)
-	time.Sleep(1 * time.Second)
+	ctx, stop := interruptContext()
+	err = runCommand(ctx, cmd, s)
+	if cerr := s.closeStorage(); err == nil {
+		err = cerr
+	}
+	stop()
+	if err != nil {
+		slog.Error(cmd.name+" failed", "error", err)
+		os.Exit(exitCode(err))
+	}
 }
 
-func main() {
-	pageNumber := 1
-	var wg sync.WaitGroup
-	jobs := make(chan Plugin, maxWorkers)
-
-	for i := 0; i < maxWorkers; i++ {
-		go func() {
-			for plugin := range jobs {
-				downloadPlugin(plugin, &wg)
-			}
-		}()
-	}
-
-	for {
-		pluginList, err := fetchPluginList(pageNumber)
-		if err != nil {
-			fmt.Printf("Failed to fetch plugin list: %v\n", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		if len(pluginList.Plugins) == 0 {
-			break
-		}
-
-		for _, plugin := range pluginList.Plugins {
-			if plugin.ActiveInstalls >= minInstalls {
-				wg.Add(1)
-				jobs <- plugin
-			}
+// runCommand runs the self-check and then cmd, holding the lock of the
+// output directory if the command writes to it.
+func runCommand(ctx context.Context, cmd command, s *Scraper) error {
+	if s.cfg.SelfCheck {
+		if err := s.selfCheck(ctx, cmd); err != nil {
+			return err
 		}
-		pageNumber++
 	}
-
-	wg.Wait()
-	close(jobs)
+	if !cmd.writes || s.cfg.DryRun {
+		return cmd.run(ctx, s)
+	}
+	release, err := lockOutput(ctx, s.cfg.OutputDir, time.Duration(s.cfg.LockWait))
+	if err != nil {
+		return err
+	}
+	defer release()
+	return cmd.run(ctx, s)
 }
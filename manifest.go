@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestFile lists the archives a download run selected, so that the
+// same corpus can be fetched again with --from-manifest. Like the
+// checkpoint it is prefixed with the kind of the run, as in
+// plugins-manifest.json.
+const manifestFile = "manifest.json"
+
+// Manifest is the content of the manifest file.
+type Manifest struct {
+	Kind    string    `json:"kind"`
+	Created time.Time `json:"created"`
+	// IPFSRoot is the CID of the --ipfs-dir directory after the run.
+	IPFSRoot string          `json:"ipfs_root,omitempty"`
+	Plugins  []ManifestEntry `json:"plugins"`
+}
+
+// ManifestEntry pins the archive of a plugin release.
+type ManifestEntry struct {
+	Slug    string `json:"slug"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	// File is the path of the archive below the output directory, with
+	// forward slashes.
+	File string `json:"file"`
+	// CID is the IPFS content identifier with --ipfs-api.
+	CID string `json:"cid,omitempty"`
+	// Repacked is the path of the archive with --repack, which replaced
+	// File. Size and SHA256 still describe the zip.
+	Repacked string `json:"repacked,omitempty"`
+}
+
+// manifest collects the entries of a run. It is safe for concurrent use by
+// the download workers.
+type manifest struct {
+	mu      sync.Mutex
+	entries map[string]ManifestEntry
+	// ipfsRoot is set once the downloads are done.
+	ipfsRoot string
+}
+
+func newManifest() *manifest {
+	return &manifest{entries: map[string]ManifestEntry{}}
+}
+
+func (m *manifest) add(entry ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[releaseKey(entry.Slug, entry.Version)] = entry
+}
+
+// lookup returns the entry of the archive of plugin.
+func (m *manifest) lookup(plugin Plugin) (ManifestEntry, bool) {
+	if m == nil {
+		return ManifestEntry{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[releaseKey(plugin.Slug, plugin.Version)]
+	return entry, ok
+}
+
+// sorted returns the entries sorted by slug and version.
+func (m *manifest) sorted() []ManifestEntry {
+	m.mu.Lock()
+	entries := make([]ManifestEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Slug != b.Slug {
+			return a.Slug < b.Slug
+		}
+		return a.Version < b.Version
+	})
+	return entries
+}
+
+// write stores the manifest of a kind run in dir, sorted by slug and
+// version.
+func (m *manifest) write(dir, kind string) error {
+	out := Manifest{Kind: kind, Created: time.Now().UTC(), IPFSRoot: m.ipfsRoot, Plugins: m.sorted()}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, kind+"-"+manifestFile), data)
+}
+
+// recordManifest adds the archive of plugin at fileName to the manifest of
+// the run, with its IPFS cid if known. An empty sum is looked up in
+// SHA256SUMS or computed.
+func (s *Scraper) recordManifest(plugin Plugin, fileName string, size int64, sum, cid string) error {
+	if s.manifest == nil {
+		return nil
+	}
+	rel, err := filepath.Rel(s.cfg.OutputDir, fileName)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	if sum == "" && s.sums != nil {
+		sum = s.sums.lookup(rel)
+	}
+	if sum == "" {
+		if sum, err = fileSHA256(fileName); err != nil {
+			return err
+		}
+	}
+	entry := ManifestEntry{
+		Slug:    plugin.Slug,
+		Version: plugin.Version,
+		URL:     plugin.DownloadLink,
+		Size:    size,
+		SHA256:  sum,
+		File:    rel,
+		CID:     cid,
+	}
+	if s.cfg.Repack != "off" {
+		entry.Repacked = repackedName(rel)
+	}
+	s.manifest.add(entry)
+	return nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// manifestSource reads the manifest at path for --from-manifest. It returns
+// the source of its plugins and pins each archive to the path, size and
+// SHA-256 the manifest lists.
+func (s *Scraper) manifestSource(path string) (func(func(Plugin) error) error, error) {
+	m, err := readManifest(path)
+	if err != nil {
+		return nil, fmt.Errorf("from-manifest: %w", err)
+	}
+	if m.Kind != s.dir.name {
+		return nil, fmt.Errorf("from-manifest: %s lists %s, run with --kind %s", path, m.Kind, m.Kind)
+	}
+
+	s.pinned = make(map[string]ManifestEntry, len(m.Plugins))
+	for _, entry := range m.Plugins {
+		if !filepath.IsLocal(filepath.FromSlash(entry.File)) {
+			return nil, fmt.Errorf("from-manifest: %s: file %q of %s is outside the output directory", path, entry.File, entry.Slug)
+		}
+		s.pinned[releaseKey(entry.Slug, entry.Version)] = entry
+	}
+	return func(fn func(Plugin) error) error {
+		for _, entry := range m.Plugins {
+			if err := fn(Plugin{Slug: entry.Slug, Version: entry.Version, DownloadLink: entry.URL}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// readManifest reads the manifest at path.
+func readManifest(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// checkPinned compares the archive at path with the size and SHA-256 that
+// --from-manifest pinned it to.
+func checkPinned(path string, pin ManifestEntry) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	return matchPin(pin, info.Size(), sum)
+}
+
+// matchPin checks the size and SHA-256 of an archive against the entry of
+// the manifest --from-manifest pinned it to.
+func matchPin(pin ManifestEntry, size int64, sum string) error {
+	if size != pin.Size {
+		return fmt.Errorf("%w: %d bytes, the manifest lists %d", errInvalidArchive, size, pin.Size)
+	}
+	if sum != pin.SHA256 {
+		return fmt.Errorf("%w: SHA-256 %s, the manifest lists %s", errInvalidArchive, sum, pin.SHA256)
+	}
+	return nil
+}
+
+// manifestExisting adds the archive that an earlier run stored for plugin
+// to the manifest. Failures are logged, since the archive itself is fine.
+func (s *Scraper) manifestExisting(ctx context.Context, plugin Plugin) {
+	fileName, err := s.archivePath(plugin)
+	if err == nil {
+		err = s.describeExisting(ctx, plugin, fileName)
+	}
+	if err != nil {
+		slog.Warn("failed to add existing archive to the manifest", "slug", plugin.Slug, "version", plugin.Version, "error", err)
+	}
+}
+
+// describeExisting records the existing archive of plugin at fileName in
+// the manifest. Archives uploaded to --output are gone from the output
+// directory, so they are described by the manifest of the earlier run, or
+// by the size of the stored file and the sum in SHA256SUMS, without
+// downloading them.
+func (s *Scraper) describeExisting(ctx context.Context, plugin Plugin, fileName string) error {
+	if info, err := os.Stat(fileName); err == nil {
+		return s.recordManifest(plugin, fileName, info.Size(), "", "")
+	}
+	if s.cfg.Repack != "off" {
+		if size, sum, err := repackedOrigin(repackedName(fileName)); err == nil {
+			return s.recordManifest(plugin, fileName, size, sum, "")
+		}
+	}
+	if s.cfg.Output == "" {
+		return fmt.Errorf("%s: %w", fileName, fs.ErrNotExist)
+	}
+
+	name, err := s.storageName(fileName)
+	if err != nil {
+		return err
+	}
+	if entry, ok := s.previous[releaseKey(plugin.Slug, plugin.Version)]; ok && entry.File == name {
+		return s.recordManifest(plugin, fileName, entry.Size, entry.SHA256, entry.CID)
+	}
+	if s.cfg.Repack != "off" {
+		// The stored file is the recompressed archive, whose size is not
+		// the size of the zip the manifest lists.
+		return fmt.Errorf("%s is not in the manifest of an earlier run", name)
+	}
+	info, err := s.stat(ctx, fileName)
+	if err != nil {
+		return err
+	}
+	var sum string
+	if s.sums != nil {
+		sum = s.sums.lookup(name)
+	}
+	if sum == "" {
+		return fmt.Errorf("%s is not in %s", name, checksumsFile)
+	}
+	return s.recordManifest(plugin, fileName, info.Size, sum, "")
+}
+
+// validateExisting checks an archive that an earlier run stored for plugin
+// at fileName, against the manifest if it is pinned.
+func (s *Scraper) validateExisting(plugin Plugin, fileName string) error {
+	if err := verifyArchive(fileName); err != nil {
+		return err
+	}
+	if pin, ok := s.pinned[releaseKey(plugin.Slug, plugin.Version)]; ok {
+		return checkPinned(fileName, pin)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchPin(t *testing.T) {
+	const sum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	pin := ManifestEntry{Slug: "akismet", Version: "5.3.1", Size: 4, SHA256: sum}
+	tests := []struct {
+		name    string
+		size    int64
+		sum     string
+		wantErr string
+	}{
+		{"match", 4, sum, ""},
+		{"size", 5, sum, "5 bytes, the manifest lists 4"},
+		{"sha256", 4, strings.Repeat("0", 64), "the manifest lists " + sum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := matchPin(pin, tt.size, tt.sum)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("matchPin() = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errInvalidArchive) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("matchPin() = %v, want an invalid archive with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPinned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "akismet.5.3.1.zip")
+	if err := os.WriteFile(path, []byte("test"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("test"))
+	if err := checkPinned(path, ManifestEntry{Size: 4, SHA256: hex.EncodeToString(sum[:])}); err != nil {
+		t.Fatalf("checkPinned() = %v", err)
+	}
+	if err := checkPinned(path, ManifestEntry{Size: 4, SHA256: "0"}); !errors.Is(err, errInvalidArchive) {
+		t.Fatalf("checkPinned() = %v, want an invalid archive", err)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := readManifest(filepath.Join(dir, "missing.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("readManifest() of a missing file = %v, want fs.ErrNotExist", err)
+	}
+	broken := filepath.Join(dir, "broken.json")
+	os.WriteFile(broken, []byte("{"), 0o644)
+	if _, err := readManifest(broken); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("readManifest() of invalid JSON = %v, want a parse error", err)
+	}
+	valid := filepath.Join(dir, "plugins-manifest.json")
+	os.WriteFile(valid, []byte(`{"kind":"plugins","plugins":[{"slug":"akismet","version":"5.3.1","size":4,"file":"akismet.5.3.1.zip"}]}`), 0o644)
+	m, err := readManifest(valid)
+	if err != nil || m.Kind != "plugins" || len(m.Plugins) != 1 || m.Plugins[0].File != "akismet.5.3.1.zip" {
+		t.Fatalf("readManifest() = %+v, %v", m, err)
+	}
+}
+
+func TestDescribeExisting(t *testing.T) {
+	plugin := Plugin{Slug: "akismet", Version: "5.3.1", DownloadLink: "https://downloads.wordpress.org/plugin/akismet.5.3.1.zip"}
+	const name = "akismet.5.3.1.zip"
+	data := []byte("archive")
+	digest := sha256.Sum256(data)
+	sum := hex.EncodeToString(digest[:])
+	const recorded = "1111111111111111111111111111111111111111111111111111111111111111"
+
+	tests := []struct {
+		name string
+		// remote uses a file:// output, local and uploaded place the
+		// archive in the output directory or the output.
+		remote, local, uploaded bool
+		repack                  string
+		previous                *ManifestEntry
+		summed                  bool
+		want                    ManifestEntry
+		wantErr                 string
+		// notExist is whether the error wraps fs.ErrNotExist.
+		notExist bool
+	}{
+		{name: "local archive", local: true, want: ManifestEntry{Size: int64(len(data)), SHA256: sum}},
+		{name: "local archive missing", notExist: true},
+		{name: "local archive with remote output", remote: true, local: true, want: ManifestEntry{Size: int64(len(data)), SHA256: sum}},
+		{
+			name: "manifest of the previous run", remote: true, uploaded: true,
+			previous: &ManifestEntry{File: name, Size: 42, SHA256: recorded, CID: "bafy"},
+			want:     ManifestEntry{Size: 42, SHA256: recorded, CID: "bafy"},
+		},
+		{
+			name: "previous manifest lists another file", remote: true, uploaded: true, summed: true,
+			previous: &ManifestEntry{File: "akismet/" + name, Size: 42, SHA256: recorded},
+			want:     ManifestEntry{Size: int64(len(data)), SHA256: sum},
+		},
+		{name: "stored size and SHA256SUMS", remote: true, uploaded: true, summed: true, want: ManifestEntry{Size: int64(len(data)), SHA256: sum}},
+		{name: "not in SHA256SUMS", remote: true, uploaded: true, wantErr: "is not in SHA256SUMS"},
+		{name: "not uploaded", remote: true, summed: true, notExist: true},
+		{name: "repacked without manifest", remote: true, uploaded: true, summed: true, repack: "zstd", wantErr: "not in the manifest of an earlier run"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.OutputDir = t.TempDir()
+			output := t.TempDir()
+			if tt.remote {
+				cfg.Output = "file://" + filepath.ToSlash(output)
+			}
+			if tt.repack != "" {
+				cfg.Repack = tt.repack
+			}
+			s, err := newScraper(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.manifest = newManifest()
+			if s.sums, err = readChecksums(filepath.Join(cfg.OutputDir, checksumsFile)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.summed {
+				s.sums.add(name, sum)
+			}
+			s.previous = map[string]ManifestEntry{}
+			if tt.previous != nil {
+				s.previous[releaseKey(plugin.Slug, plugin.Version)] = *tt.previous
+			}
+			fileName := filepath.Join(cfg.OutputDir, name)
+			if tt.local {
+				os.WriteFile(fileName, data, 0o644)
+			}
+			if tt.uploaded {
+				os.WriteFile(filepath.Join(output, name), data, 0o644)
+			}
+
+			err = s.describeExisting(context.Background(), plugin, fileName)
+			if tt.wantErr != "" || tt.notExist {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || tt.notExist && !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("describeExisting() = %v, want %q", err, tt.wantErr)
+				}
+				if _, ok := s.manifest.lookup(plugin); ok {
+					t.Fatal("failed archive was added to the manifest")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("describeExisting() = %v", err)
+			}
+			got, ok := s.manifest.lookup(plugin)
+			want := tt.want
+			want.Slug, want.Version, want.URL, want.File = plugin.Slug, plugin.Version, plugin.DownloadLink, name
+			if !ok || got != want {
+				t.Fatalf("manifest entry = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataFile in the output directory receives a line for every plugin of
+// a download run with --metadata-jsonl. Runs append to it.
+const metadataFile = "metadata.jsonl"
+
+// MetadataRecord is a line of metadata.jsonl: the metadata of a plugin as
+// the API returned it and what the run did with its archive.
+type MetadataRecord struct {
+	Plugin Plugin `json:"plugin"`
+	// Outcome is downloaded, skipped or failed, with the reason of the
+	// latter two, or pruned for the archives prune removed.
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+	// Size, SHA256, File, CID and Repacked describe the archive as in the
+	// manifest, and are empty if it was not stored.
+	Size     int64     `json:"size,omitempty"`
+	SHA256   string    `json:"sha256,omitempty"`
+	File     string    `json:"file,omitempty"`
+	CID      string    `json:"cid,omitempty"`
+	Repacked string    `json:"repacked,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// metadataLog appends records to metadata.jsonl as the plugins are done, so
+// the file can be followed during the run. It is safe for concurrent use by
+// the download workers, and a nil log drops every record.
+type metadataLog struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	err  error
+}
+
+// openMetadataLog opens metadata.jsonl in dir for appending.
+func openMetadataLog(dir string) (*metadataLog, error) {
+	file, err := os.OpenFile(filepath.Join(dir, metadataFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &metadataLog{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// sidecarName returns the name of the metadata sidecar of the archive
+// fileName, such as akismet-5.3.1.json for akismet-5.3.1.zip.
+func sidecarName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".json"
+}
+
+// writeSidecar writes the record of the archive of plugin at fileName, whose
+// download started at started, to its sidecar for --metadata-sidecars.
+func (s *Scraper) writeSidecar(plugin Plugin, fileName string, started time.Time) error {
+	entry, _ := s.manifest.lookup(plugin)
+	data, err := json.MarshalIndent(newMetadataRecord(plugin, entry, started, nil), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(sidecarName(fileName), append(data, '\n'))
+}
+
+// recordMetadata passes the outcome err of plugin, whose download started
+// at started, to metadata.jsonl and the exports of the run.
+func (s *Scraper) recordMetadata(plugin Plugin, started time.Time, err error) {
+	if s.metadata == nil && s.exports == nil {
+		return
+	}
+	entry, _ := s.manifest.lookup(plugin)
+	rec := newMetadataRecord(plugin, entry, started, err)
+	s.metadata.record(rec)
+	s.exports.record(rec)
+}
+
+// newMetadataRecord returns the record of the outcome err of plugin, with
+// the archive entry the manifest holds for it.
+func newMetadataRecord(plugin Plugin, entry ManifestEntry, started time.Time, err error) MetadataRecord {
+	rec := MetadataRecord{Plugin: plugin, Outcome: "downloaded", Started: started, Finished: time.Now()}
+	var skip *skipError
+	switch {
+	case errors.As(err, &skip):
+		rec.Outcome, rec.Reason = "skipped", skip.reason
+	case err != nil:
+		rec.Outcome, rec.Reason = "failed", err.Error()
+	}
+	if err == nil || skip != nil {
+		rec.Size, rec.SHA256, rec.File, rec.CID, rec.Repacked = entry.Size, entry.SHA256, entry.File, entry.CID, entry.Repacked
+	}
+	return rec
+}
+
+// record appends rec. Each record is written with a single write, so
+// readers never see half a line.
+func (l *metadataLog) record(rec MetadataRecord) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = l.enc.Encode(rec)
+	}
+}
+
+// close closes the file and returns the first error of the run.
+func (l *metadataLog) close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Close(); l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoExporter upserts a document per plugin, keyed by slug, into the
+// collection of the kind of the run, such as plugins, in the database named
+// by the connection URL. Documents are the full API metadata with nested
+// tags, sections and versions, plus the outcome of the latest download
+// under download. Writes are batched like those of searchExporter.
+type mongoExporter struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+	size       int
+	pending    []mongo.WriteModel
+}
+
+func newMongoExporter(s *Scraper, uri string) (exporter, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	database := strings.TrimPrefix(u.Path, "/")
+	if database == "" {
+		return nil, fmt.Errorf("%s names no database", u.Redacted())
+	}
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	// Connecting is lazy; a ping reports a wrong URL before the run.
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	e := &mongoExporter{
+		client:     client,
+		collection: client.Database(database).Collection(s.dir.name),
+		size:       s.cfg.Query.PerPage,
+	}
+	if e.size == 0 {
+		e.size = defaultBulkSize
+	}
+	return e, nil
+}
+
+func (e *mongoExporter) export(rec MetadataRecord) error {
+	download := bson.D{
+		{Key: "outcome", Value: rec.Outcome},
+		{Key: "reason", Value: rec.Reason},
+		{Key: "size", Value: rec.Size},
+		{Key: "sha256", Value: rec.SHA256},
+		{Key: "file", Value: rec.File},
+		{Key: "cid", Value: rec.CID},
+		{Key: "started", Value: rec.Started},
+		{Key: "finished", Value: rec.Finished},
+	}
+	set := bson.M{"download": download}
+	// Plugins that come without metadata, such as those of --from-manifest,
+	// leave the metadata of earlier runs alone.
+	if rec.Plugin.Name != "" {
+		data, err := json.Marshal(rec.Plugin)
+		if err != nil {
+			return err
+		}
+		if err := bson.UnmarshalExtJSON(data, false, &set); err != nil {
+			return err
+		}
+		// Dates are stored as such rather than as the strings of the API.
+		for key, t := range map[string]Timestamp{"last_updated": rec.Plugin.LastUpdated, "added": rec.Plugin.Added} {
+			if t.IsZero() {
+				set[key] = nil
+			} else {
+				set[key] = t.Time
+			}
+		}
+		set["download"] = download
+	}
+	e.pending = append(e.pending, mongo.NewUpdateOneModel().
+		SetFilter(bson.D{{Key: "_id", Value: rec.Plugin.Slug}}).
+		SetUpdate(bson.D{{Key: "$set", Value: set}}).
+		SetUpsert(true))
+	if len(e.pending) < e.size {
+		return nil
+	}
+	return e.flush()
+}
+
+// flush sends the buffered upserts in one bulk write.
+func (e *mongoExporter) flush() error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := e.collection.BulkWrite(ctx, e.pending, options.BulkWrite().SetOrdered(false))
+	e.pending = e.pending[:0]
+	return err
+}
+
+func (e *mongoExporter) close() error {
+	err := e.flush()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if derr := e.client.Disconnect(ctx); err == nil {
+		err = derr
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	// defaultNameTemplate reproduces the original slug-version.zip layout.
+	defaultNameTemplate = "{{.Slug}}-{{.Version}}.zip"
+
+	// maxNameLength keeps path components below the limits of common
+	// filesystems.
+	maxNameLength = 200
+)
+
+// layouts are the values of --layout, which put the paths of the name
+// template into directories: none for flat, one per slug for slug, and one
+// per slug below a shard of the first two characters of the slug for
+// prefix, or of the first two hex digits of its SHA-256 for hash. Sharding
+// keeps directories small enough for filesystems and rsync with 60k+
+// plugins, and hash spreads them evenly.
+var layouts = []string{"flat", "slug", "prefix", "hash"}
+
+// layoutDirs returns the directories --layout puts the archives of slug in.
+func layoutDirs(layout, slug string) []string {
+	switch layout {
+	case "slug":
+		return []string{slug}
+	case "prefix":
+		return []string{slug[:min(2, len(slug))], slug}
+	case "hash":
+		sum := sha256.Sum256([]byte(slug))
+		return []string{hex.EncodeToString(sum[:1]), slug}
+	}
+	return nil
+}
+
+func parseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("name-template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// archivePath returns the path below the output directory that the archive
+// of plugin is written to. Every component of the rendered template is
+// sanitized, so the result never leaves the output directory. Archives
+// pinned by --from-manifest keep the path the manifest lists.
+func (s *Scraper) archivePath(plugin Plugin) (string, error) {
+	if pin, ok := s.pinned[releaseKey(plugin.Slug, plugin.Version)]; ok {
+		return filepath.Join(s.cfg.OutputDir, filepath.FromSlash(pin.File)), nil
+	}
+	var b strings.Builder
+	if err := s.names.Execute(&b, plugin); err != nil {
+		return "", fmt.Errorf("name-template: %w", err)
+	}
+
+	var parts []string
+	dirs := layoutDirs(s.cfg.Layout, plugin.Slug)
+	for _, dir := range dirs {
+		parts = append(parts, sanitizeName(dir))
+	}
+	for _, part := range strings.Split(strings.ReplaceAll(b.String(), "\\", "/"), "/") {
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, sanitizeName(part))
+		}
+	}
+	if len(parts) == len(dirs) {
+		return "", fmt.Errorf("name-template produced an empty file name for %s", plugin.Slug)
+	}
+
+	name := filepath.Join(parts...)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("name-template produced unsafe path %q for %s", name, plugin.Slug)
+	}
+	return filepath.Join(s.cfg.OutputDir, name), nil
+}
+
+// sanitizeName turns s into a single path component that is valid on
+// Windows, macOS and Linux. Characters other than ASCII letters, digits and
+// ".-_+" are replaced with underscores, leading dots are dropped so that no
+// component can be "." or "..", and names reserved by Windows are prefixed.
+func sanitizeName(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '-', r == '_', r == '+':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	name := strings.TrimRight(strings.TrimLeft(b.String(), "."), ".")
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength]
+	}
+	if name == "" {
+		return "_"
+	}
+	if isReservedName(name) {
+		return "_" + name
+	}
+	return name
+}
+
+// isReservedName reports whether name is a device name reserved by Windows,
+// which remains reserved with any extension.
+func isReservedName(name string) bool {
+	base, _, _ := strings.Cut(strings.ToUpper(name), ".")
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '1' && base[3] <= '9'
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// checksumManifest is the response of the plugin checksums API, which lists
+// the hashes of every file of a release.
+type checksumManifest struct {
+	Files map[string]struct {
+		SHA256 hashList `json:"sha256"`
+	} `json:"files"`
+}
+
+// hashList holds the accepted hashes of a file. The API encodes a single
+// hash as a string and files that changed between builds of the same
+// version as an array.
+type hashList []string
+
+func (h *hashList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var sum string
+		if err := json.Unmarshal(data, &sum); err != nil {
+			return err
+		}
+		*h = hashList{sum}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(h))
+}
+
+// verifyOfficialChecksums compares the files in the archive at path with the
+// checksums WordPress.org publishes for the release of plugin. Releases
+// without published checksums are logged and pass.
+func (s *Scraper) verifyOfficialChecksums(ctx context.Context, plugin Plugin, path string) error {
+	rawURL := fmt.Sprintf("%s/%s/%s.json", s.dir.checksumsURL, url.PathEscape(plugin.Slug), url.PathEscape(plugin.Version))
+	var manifest checksumManifest
+	err := s.getJSON(ctx, s.limiter, rawURL, &manifest)
+	if errors.Is(err, errNotFound) {
+		slog.Warn("no official checksums published", "slug", plugin.Slug, "version", plugin.Version)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("fetch official checksums: %w", err)
+	}
+
+	sums, err := zipChecksums(path)
+	if err != nil {
+		return err
+	}
+	var mismatched []string
+	for name, want := range manifest.Files {
+		if got, ok := sums[name]; !ok || !slices.Contains(want.SHA256, got) {
+			mismatched = append(mismatched, name)
+		}
+	}
+	if len(mismatched) == 0 {
+		slog.Debug("archive matches official checksums", "slug", plugin.Slug, "version", plugin.Version,
+			"files", len(manifest.Files))
+		return nil
+	}
+
+	sort.Strings(mismatched)
+	slog.Error("archive does not match official checksums", "slug", plugin.Slug, "version", plugin.Version,
+		"files", mismatched)
+	return fmt.Errorf("%d of %d files do not match the official checksums, first %s",
+		len(mismatched), len(manifest.Files), mismatched[0])
+}
+
+// zipChecksums returns the hex encoded SHA-256 of every file in the archive
+// at path, keyed by the name below the top-level plugin directory as used
+// by the checksums API.
+func zipChecksums(path string) (map[string]string, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	sums := map[string]string{}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		_, name, _ := strings.Cut(f.Name, "/")
+		sums[name] = hex.EncodeToString(hash.Sum(nil))
+	}
+	return sums, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// parquetRowGroup is the number of rows buffered before they are written
+// as a row group, which bounds the memory of long runs.
+const parquetRowGroup = 10000
+
+// parquetRow is a row of the Parquet export, with the columns of
+// searchDocument. Optional timestamps are null when the API left them out.
+type parquetRow struct {
+	Kind             string    `parquet:"kind,dict"`
+	Slug             string    `parquet:"slug"`
+	Name             string    `parquet:"name"`
+	Version          string    `parquet:"version"`
+	Author           string    `parquet:"author"`
+	ShortDescription string    `parquet:"short_description"`
+	Description      string    `parquet:"description"`
+	Homepage         string    `parquet:"homepage"`
+	Requires         string    `parquet:"requires,dict"`
+	Tested           string    `parquet:"tested,dict"`
+	RequiresPHP      string    `parquet:"requires_php,dict"`
+	ActiveInstalls   int64     `parquet:"active_installs"`
+	Downloaded       int64     `parquet:"downloaded"`
+	Rating           int32     `parquet:"rating"`
+	NumRatings       int32     `parquet:"num_ratings"`
+	LastUpdated      time.Time `parquet:"last_updated,optional,timestamp(millisecond)"`
+	Added            time.Time `parquet:"added,optional,timestamp(millisecond)"`
+	Tags             []string  `parquet:"tags,list"`
+	Contributors     []string  `parquet:"contributors,list"`
+	Versions         []string  `parquet:"versions,list"`
+	DownloadLink     string    `parquet:"download_link"`
+	Outcome          string    `parquet:"outcome,dict"`
+	Reason           string    `parquet:"reason"`
+	Size             int64     `parquet:"size"`
+	SHA256           string    `parquet:"sha256"`
+	File             string    `parquet:"file"`
+	Started          time.Time `parquet:"started,timestamp(millisecond)"`
+	Finished         time.Time `parquet:"finished,timestamp(millisecond)"`
+}
+
+func newParquetRow(doc searchDocument) parquetRow {
+	row := parquetRow{
+		Kind:             doc.Kind,
+		Slug:             doc.Slug,
+		Name:             doc.Name,
+		Version:          doc.Version,
+		Author:           doc.Author,
+		ShortDescription: doc.ShortDescription,
+		Description:      doc.Description,
+		Homepage:         doc.Homepage,
+		Requires:         doc.Requires,
+		Tested:           doc.Tested,
+		RequiresPHP:      doc.RequiresPHP,
+		ActiveInstalls:   int64(doc.ActiveInstalls),
+		Downloaded:       int64(doc.Downloaded),
+		Rating:           int32(doc.Rating),
+		NumRatings:       int32(doc.NumRatings),
+		Tags:             doc.Tags,
+		Contributors:     doc.Contributors,
+		Versions:         doc.Versions,
+		DownloadLink:     doc.DownloadLink,
+		Outcome:          doc.Outcome,
+		Reason:           doc.Reason,
+		Size:             doc.Size,
+		SHA256:           doc.SHA256,
+		File:             doc.File,
+		Started:          doc.Started.UTC(),
+		Finished:         doc.Finished.UTC(),
+	}
+	if doc.LastUpdated != nil {
+		row.LastUpdated = doc.LastUpdated.UTC()
+	}
+	if doc.Added != nil {
+		row.Added = doc.Added.UTC()
+	}
+	return row
+}
+
+// parquetExporter writes the plugins of a run as Parquet files below a
+// directory, partitioned by the UTC date they were crawled on in the Hive
+// layout crawl_date=YYYY-MM-DD, so that DuckDB, Athena or Spark can query
+// the directory as one table. Every run adds a file per date, named after
+// the kind and start of the run, which appears once the run ends.
+type parquetExporter struct {
+	dir     string
+	kind    string
+	name    string
+	writers map[string]*parquetFile
+}
+
+// parquetFile is a file of a parquetExporter that is still being written.
+type parquetFile struct {
+	file *os.File
+	w    *parquet.GenericWriter[parquetRow]
+	path string
+}
+
+func newParquetExporter(s *Scraper, dir string) (exporter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &parquetExporter{
+		dir:     dir,
+		kind:    s.dir.name,
+		name:    s.dir.name + "-" + time.Now().UTC().Format(responseTimeFormat) + ".parquet",
+		writers: map[string]*parquetFile{},
+	}, nil
+}
+
+func (e *parquetExporter) export(rec MetadataRecord) error {
+	date := rec.Finished.UTC().Format(time.DateOnly)
+	pf, ok := e.writers[date]
+	if !ok {
+		dir := filepath.Join(e.dir, "crawl_date="+date)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, e.name)
+		file, err := os.Create(path + tmpSuffix)
+		if err != nil {
+			return err
+		}
+		pf = &parquetFile{
+			file: file,
+			w:    parquet.NewGenericWriter[parquetRow](file, parquet.Compression(&zstd.Codec{}), parquet.MaxRowsPerRowGroup(parquetRowGroup)),
+			path: path,
+		}
+		e.writers[date] = pf
+	}
+	_, err := pf.w.Write([]parquetRow{newParquetRow(newSearchDocument(e.kind, rec))})
+	return err
+}
+
+// close writes the footers and moves the files into place.
+func (e *parquetExporter) close() error {
+	var first error
+	for date, pf := range e.writers {
+		err := pf.w.Close()
+		if cerr := pf.file.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(pf.file.Name(), pf.path)
+		} else {
+			os.Remove(pf.file.Name())
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("%s: %w", date, err)
+		}
+		delete(e.writers, date)
+	}
+	return first
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	patternsURL = "https://api.wordpress.org/patterns/1.0/"
+
+	// patternsPerPage is the largest page size the pattern API accepts.
+	patternsPerPage = 100
+
+	// patternsDir is the directory below the output directory that block
+	// patterns are stored in.
+	patternsDir = "patterns"
+)
+
+// Pattern holds the fields of a block pattern that the scraper uses. The
+// complete API object is stored alongside.
+type Pattern struct {
+	ID    int `json:"id"`
+	Title struct {
+		Rendered string `json:"rendered"`
+	} `json:"title"`
+	Content string `json:"pattern_content"`
+}
+
+// patternsPageSize returns the page size for pattern requests, which is
+// --per-page capped at what the pattern API accepts.
+func (s *Scraper) patternsPageSize() int {
+	if s.cfg.Query.PerPage > 0 && s.cfg.Query.PerPage < patternsPerPage {
+		return s.cfg.Query.PerPage
+	}
+	return patternsPerPage
+}
+
+// patternsQuery builds the pattern directory request for a page.
+func (s *Scraper) patternsQuery(pageNumber int) url.Values {
+	query := url.Values{
+		"page":     {strconv.Itoa(pageNumber)},
+		"per_page": {strconv.Itoa(s.patternsPageSize())},
+	}
+	if s.cfg.Query.Search != "" {
+		query.Set("search", s.cfg.Query.Search)
+	}
+	if s.cfg.Query.Locale != "" {
+		query.Set("locale", s.cfg.Query.Locale)
+	}
+	return query
+}
+
+// runPatterns enumerates the block pattern directory and stores every
+// pattern as its API JSON object and its block HTML.
+func runPatterns(ctx context.Context, s *Scraper) error {
+	dir := filepath.Join(s.cfg.OutputDir, patternsDir)
+	if !s.cfg.DryRun {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create output directory: %w", err)
+		}
+	}
+
+	var stored int
+	for pageNumber := s.cfg.StartPage; s.cfg.EndPage == 0 || pageNumber <= s.cfg.EndPage; pageNumber++ {
+		var page []json.RawMessage
+		rawURL := patternsURL + "?" + s.patternsQuery(pageNumber).Encode()
+		if err := s.getJSON(ctx, s.limiter, rawURL, &page); err != nil {
+			return fmt.Errorf("fetch pattern page %d: %w", pageNumber, err)
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, raw := range page {
+			var pattern Pattern
+			if err := json.Unmarshal(raw, &pattern); err != nil {
+				slog.Error("failed to decode pattern", "page", pageNumber, "error", err)
+				continue
+			}
+			if s.cfg.MaxDownloads > 0 && stored >= s.cfg.MaxDownloads {
+				slog.Info("stored block patterns", "patterns", stored)
+				return nil
+			}
+			stored++
+
+			if s.cfg.DryRun {
+				fmt.Printf("%-10d %s\n", pattern.ID, pattern.Title.Rendered)
+				continue
+			}
+			if err := s.writePattern(ctx, dir, pattern, raw); err != nil {
+				return err
+			}
+			slog.Debug("stored block pattern", "id", pattern.ID, "title", pattern.Title.Rendered)
+		}
+
+		// A short page is the last one.
+		if len(page) < s.patternsPageSize() {
+			break
+		}
+	}
+
+	slog.Info("stored block patterns", "patterns", stored)
+	return nil
+}
+
+// writePattern stores pattern in dir as <id>.json and <id>.html, and
+// uploads both to --output if one is set.
+func (s *Scraper) writePattern(ctx context.Context, dir string, pattern Pattern, raw json.RawMessage) error {
+	base := filepath.Join(dir, strconv.Itoa(pattern.ID))
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{base + ".json", raw}, {base + ".html", []byte(pattern.Content)}} {
+		if err := writeFileAtomic(file.name, file.data); err != nil {
+			return err
+		}
+		if err := s.publish(ctx, file.name, false, map[string]string{"pattern_id": strconv.Itoa(pattern.ID)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Plugin is the metadata the API returns for a plugin. Fields that the API
+// omits, or sets to false when they are unknown, decode to their zero value.
+type Plugin struct {
+	Name                   LooseString           `json:"name"`
+	Slug                   string                `json:"slug"`
+	Version                string                `json:"version"`
+	Author                 LooseString           `json:"author"`
+	AuthorProfile          LooseString           `json:"author_profile"`
+	Contributors           LooseMap[Contributor] `json:"contributors"`
+	Requires               LooseString           `json:"requires"`
+	Tested                 LooseString           `json:"tested"`
+	RequiresPHP            LooseString           `json:"requires_php"`
+	RequiresPlugins        LooseList             `json:"requires_plugins"`
+	Rating                 int                   `json:"rating"`
+	Ratings                LooseMap[int]         `json:"ratings"`
+	NumRatings             int                   `json:"num_ratings"`
+	SupportThreads         int                   `json:"support_threads"`
+	SupportThreadsResolved int                   `json:"support_threads_resolved"`
+	ActiveInstalls         int                   `json:"active_installs"`
+	Downloaded             int                   `json:"downloaded"`
+	LastUpdated            Timestamp             `json:"last_updated"`
+	Added                  Timestamp             `json:"added"`
+	Homepage               LooseString           `json:"homepage"`
+	ShortDescription       LooseString           `json:"short_description"`
+	Description            LooseString           `json:"description"`
+	Sections               LooseMap[LooseString] `json:"sections"`
+	DownloadLink           string                `json:"download_link"`
+	UpgradeNotice          LooseMap[LooseString] `json:"upgrade_notice"`
+	Screenshots            LooseMap[Screenshot]  `json:"screenshots"`
+	Tags                   Tags                  `json:"tags"`
+	Versions               LooseMap[LooseString] `json:"versions"`
+	DonateLink             LooseString           `json:"donate_link"`
+	Icons                  LooseMap[LooseString] `json:"icons"`
+	Banners                LooseMap[LooseString] `json:"banners"`
+	BusinessModel          LooseString           `json:"business_model"`
+}
+
+// Contributor is an entry of the contributors map, keyed by username.
+type Contributor struct {
+	Profile     LooseString `json:"profile"`
+	Avatar      LooseString `json:"avatar"`
+	DisplayName LooseString `json:"display_name"`
+}
+
+// Screenshot is an entry of the screenshots map, keyed by position.
+type Screenshot struct {
+	Src     LooseString `json:"src"`
+	Caption LooseString `json:"caption"`
+}
+
+// PluginList is a page of query_plugins or query_themes results.
+type PluginList struct {
+	Info    PageInfo `json:"info"`
+	Plugins []Plugin `json:"plugins"`
+	Themes  []Plugin `json:"themes"`
+}
+
+// items returns the plugins or themes of the page.
+func (l PluginList) items() []Plugin {
+	if len(l.Themes) > 0 {
+		return l.Themes
+	}
+	return l.Plugins
+}
+
+// PageInfo describes the position of a page within the full result set.
+type PageInfo struct {
+	Page    int `json:"page"`
+	Pages   int `json:"pages"`
+	Results int `json:"results"`
+}
+
+// LooseString decodes string fields that the API sets to false or null
+// when they are unknown, and that occasionally arrive as numbers.
+type LooseString string
+
+func (s *LooseString) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		*s = LooseString(v)
+	case float64:
+		*s = LooseString(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		*s = ""
+	}
+	return nil
+}
+
+// LooseMap decodes object fields that the API encodes as an empty array,
+// false or null when they have no entries. Arrays are keyed by position.
+// It always encodes as an object.
+type LooseMap[V any] map[string]V
+
+func (m *LooseMap[V]) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) > 0 && data[0] == '{':
+		var v map[string]V
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*m = v
+	case len(data) > 0 && data[0] == '[':
+		var list []V
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*m = make(LooseMap[V], len(list))
+		for i, v := range list {
+			(*m)[strconv.Itoa(i)] = v
+		}
+	default:
+		*m = nil
+	}
+	return nil
+}
+
+func (m LooseMap[V]) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]V(m))
+}
+
+// LooseList decodes lists of strings that the API sets to false or null
+// when they are empty. Objects contribute their values. It always encodes
+// as an array.
+type LooseList []string
+
+func (l *LooseList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) > 0 && data[0] == '[':
+		var list []LooseString
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*l = make(LooseList, len(list))
+		for i, v := range list {
+			(*l)[i] = string(v)
+		}
+	case len(data) > 0 && data[0] == '{':
+		var m LooseMap[LooseString]
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*l = make(LooseList, 0, len(m))
+		for _, v := range m {
+			*l = append(*l, string(v))
+		}
+	default:
+		*l = nil
+	}
+	return nil
+}
+
+func (l LooseList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(l))
+}
+
+// Tags maps tag slugs to their display names. The API encodes an empty tag
+// set as an empty JSON array instead of an object.
+type Tags map[string]string
+
+func (t *Tags) UnmarshalJSON(data []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err == nil {
+		*t = m
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+	*t = make(Tags, len(list))
+	for _, tag := range list {
+		(*t)[tag] = tag
+	}
+	return nil
+}
+
+func (t Tags) MarshalJSON() ([]byte, error) {
+	if t == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]string(t))
+}
+
+// has reports whether the set contains tag, by slug or display name.
+func (t Tags) has(tag string) bool {
+	for slug, name := range t {
+		if strings.EqualFold(slug, tag) || strings.EqualFold(name, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Timestamp decodes the dates used by the API, such as
+// "2024-05-01 3:04pm GMT". Values that cannot be parsed decode to the zero
+// time.
+type Timestamp struct {
+	time.Time
+}
+
+var timestampLayouts = []string{
+	"2006-01-02 3:04pm MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC3339,
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		// The API uses false or null for unknown dates.
+		t.Time = time.Time{}
+		return nil
+	}
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	t.Time = time.Time{}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// autoscaleInterval is how often --autoscale re-evaluates the pool size.
+const autoscaleInterval = 10 * time.Second
+
+// workerPool runs download workers whose number can change while jobs are
+// being processed. The workers belong to an errgroup, whose Wait returns
+// once every worker stopped, with the first error a worker returned.
+type workerPool struct {
+	ctx   context.Context
+	group *errgroup.Group
+	jobs  chan Plugin
+	quit  chan struct{}
+	work  func(Plugin) error
+
+	mu     sync.Mutex
+	size   int
+	closed bool
+}
+
+// newWorkerPool starts size workers in group that call work for every
+// submitted plugin. At most limit workers can run at the same time. A worker
+// stops when work returns an error; the others still finish the queued jobs.
+// No further jobs are accepted once ctx, the context of group, is done.
+func newWorkerPool(ctx context.Context, group *errgroup.Group, size, limit int, work func(Plugin) error) *workerPool {
+	p := &workerPool{
+		ctx:   ctx,
+		group: group,
+		jobs:  make(chan Plugin, limit),
+		quit:  make(chan struct{}, limit),
+		work:  work,
+	}
+	p.resize(size)
+	return p
+}
+
+func (p *workerPool) run() error {
+	for {
+		select {
+		case <-p.quit:
+			return nil
+		case plugin, ok := <-p.jobs:
+			if !ok {
+				return nil
+			}
+			if err := p.work(plugin); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// submit queues plugin for the workers, or returns the error of the pool
+// context once it is done.
+func (p *workerPool) submit(plugin Plugin) error {
+	select {
+	case p.jobs <- plugin:
+		return nil
+	case <-p.ctx.Done():
+		return context.Cause(p.ctx)
+	}
+}
+
+// resize starts or stops workers until n are running. Stopped workers
+// finish their current download first. A closed pool is not resized.
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	for ; p.size < n; p.size++ {
+		// Take back a stop request that no busy worker picked up yet
+		// before starting a new worker.
+		select {
+		case <-p.quit:
+		default:
+			p.group.Go(p.run)
+		}
+	}
+	for ; p.size > n; p.size-- {
+		p.quit <- struct{}{}
+	}
+}
+
+func (p *workerPool) workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// close stops the workers once the queued jobs are taken.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	close(p.jobs)
+}
+
+// autoscale adjusts the size of pool between --min-workers and --workers
+// until done is closed. Every autoscaleInterval it removes a worker if most
+// downloads of the interval failed, if the queue ran empty or if the last
+// added worker lowered the throughput, and adds one if the queue is full
+// and throughput held up.
+func (s *Scraper) autoscale(pool *workerPool, report *Report, done <-chan struct{}) {
+	ticker := time.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+
+	var lastDone, lastFailed int
+	var lastBytes int64
+	var lastRate float64
+	grew := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		finished, failed, bytes := report.progress()
+		doneDelta, failedDelta := finished-lastDone, failed-lastFailed
+		rate := float64(bytes-lastBytes) / autoscaleInterval.Seconds()
+		lastDone, lastFailed, lastBytes = finished, failed, bytes
+
+		size := pool.workers()
+		target := size
+		switch {
+		case doneDelta > 0 && 2*failedDelta > doneDelta:
+			target--
+		case grew && rate < 0.9*lastRate:
+			target--
+		case len(pool.jobs) == 0:
+			target--
+		case len(pool.jobs) == cap(pool.jobs):
+			target++
+		}
+		target = min(max(target, s.cfg.MinWorkers), s.cfg.Workers)
+		grew = target > size
+		lastRate = rate
+
+		if target != size {
+			slog.Info("resizing worker pool", "workers", target, "queued", len(pool.jobs),
+				"bytes_per_second", int64(rate), "failed", failedDelta, "finished", doneDelta)
+			pool.resize(target)
+		}
+	}
+}
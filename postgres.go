@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresMigrations bring the schema of a PostgreSQL database written by
+// --export postgres=URL up to date, in order. Migration n is recorded in
+// schema_migrations as version n once it is applied, and released
+// migrations are never changed, only appended to.
+var postgresMigrations = []string{
+	// 1: the tables of the metadata database, as in sqliteMigrations.
+	`CREATE TABLE runs (
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	kind TEXT NOT NULL,
+	started TIMESTAMPTZ NOT NULL,
+	finished TIMESTAMPTZ
+);
+CREATE TABLE plugins (
+	kind TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	name TEXT NOT NULL,
+	version TEXT NOT NULL,
+	author TEXT NOT NULL,
+	active_installs BIGINT NOT NULL,
+	downloaded BIGINT NOT NULL,
+	rating INTEGER NOT NULL,
+	num_ratings INTEGER NOT NULL,
+	requires TEXT NOT NULL,
+	tested TEXT NOT NULL,
+	requires_php TEXT NOT NULL,
+	last_updated TIMESTAMPTZ,
+	added TIMESTAMPTZ,
+	metadata JSONB NOT NULL,
+	seen TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (kind, slug)
+);
+CREATE TABLE versions (
+	kind TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	version TEXT NOT NULL,
+	download_link TEXT NOT NULL,
+	PRIMARY KEY (kind, slug, version)
+);
+CREATE TABLE downloads (
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	run_id BIGINT NOT NULL REFERENCES runs (id),
+	kind TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	version TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	sha256 TEXT NOT NULL,
+	file TEXT NOT NULL,
+	cid TEXT NOT NULL,
+	started TIMESTAMPTZ NOT NULL,
+	finished TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX downloads_release ON downloads (kind, slug, version);
+CREATE INDEX downloads_sha256 ON downloads (sha256);`,
+	// 2: the recompressed archive of --repack, and indexes for the latest
+	// download and the most installed plugins.
+	`ALTER TABLE downloads ADD COLUMN repacked TEXT NOT NULL DEFAULT '';
+CREATE INDEX downloads_plugin ON downloads (kind, slug, id);
+CREATE INDEX plugins_installs ON plugins (kind, active_installs);`,
+}
+
+// postgresMigrationLock is the key of the advisory lock that keeps scraper
+// instances starting at the same time from migrating the schema twice.
+const postgresMigrationLock = 0x77707363 // "wpsc"
+
+// postgresDialect stores timestamps as TIMESTAMPTZ.
+var postgresDialect = sqlDialect{
+	bind: bindDollar,
+	time: func(t time.Time) any { return t.UTC() },
+}
+
+// newPostgresExporter connects to the database of --export postgres=URL,
+// migrates its schema and starts the run. Several scraper instances can
+// write to the same database.
+func newPostgresExporter(s *Scraper, dsn string) (exporter, error) {
+	db, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLExporter(db, postgresDialect, s.dir.name)
+}
+
+// openPostgres connects to the metadata database at dsn and migrates its
+// schema.
+func openPostgres(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := migratePostgres(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migratePostgres applies the migrations the database lacks, each in its
+// own transaction, while holding the migration lock.
+func migratePostgres(ctx context.Context, db *sql.DB) error {
+	// Advisory locks belong to a session, so everything runs on one
+	// connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresMigrationLock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", postgresMigrationLock)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	applied TIMESTAMPTZ NOT NULL DEFAULT now()
+)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(postgresMigrations) {
+		return fmt.Errorf("database has schema version %d, this build knows %d", version, len(postgresMigrations))
+	}
+	for ; version < len(postgresMigrations); version++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, postgresMigrations[version])
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version+1)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate schema to version %d: %w", version+1, err)
+		}
+		if version > 0 {
+			slog.Info("migrated metadata database", "version", version+1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import "context"
+
+// pageResult is the outcome of a plugin list request.
+type pageResult struct {
+	list PluginList
+	err  error
+}
+
+// prefetcher fetches directory pages ahead of the walk, so that the next
+// --prefetch-pages pages are already on their way while the plugins of the
+// current page are processed. It is used by a single goroutine.
+type prefetcher struct {
+	ctx     context.Context
+	s       *Scraper
+	ahead   int
+	pending map[int]chan pageResult
+	// last is the last page worth requesting, or 0 while it is unknown.
+	last int
+}
+
+func newPrefetcher(ctx context.Context, s *Scraper, ahead int) *prefetcher {
+	return &prefetcher{ctx: ctx, s: s, ahead: ahead, pending: map[int]chan pageResult{}, last: s.cfg.EndPage}
+}
+
+// fetch returns the page with the given number and starts the requests for
+// the pages after it.
+func (p *prefetcher) fetch(pageNumber int) (PluginList, error) {
+	for n := pageNumber; n <= pageNumber+p.ahead && (p.last == 0 || n <= p.last); n++ {
+		if _, ok := p.pending[n]; ok {
+			continue
+		}
+		result := make(chan pageResult, 1)
+		p.pending[n] = result
+		go func(n int) {
+			list, err := p.s.fetchPluginList(p.ctx, n)
+			result <- pageResult{list, err}
+		}(n)
+	}
+
+	result := <-p.pending[pageNumber]
+	delete(p.pending, pageNumber)
+	if pages := result.list.Info.Pages; pages > 0 && (p.last == 0 || pages < p.last) {
+		p.last = pages
+	}
+	return result.list, result.err
+}
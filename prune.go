@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// runPrune removes the archives of superseded releases: all but the
+// --keep-latest newest stored releases of each plugin, and with
+// --older-than only those stored longer ago than that. The current release
+// and trunk are always kept. The metadata store, which records every stored
+// archive, chooses them; each removal is recorded there as a download with
+// the outcome pruned, and the archive is dropped from SHA256SUMS and the
+// manifest. With --slug or --slugs-file only those plugins are pruned.
+func runPrune(ctx context.Context, s *Scraper) error {
+	store, err := s.openMetadataStore()
+	if err != nil {
+		return err
+	}
+	releases, err := store.storedReleases(ctx, s.dir.name)
+	if err != nil {
+		store.close()
+		return fmt.Errorf("metadata store: %w", err)
+	}
+	slugs, err := s.requestedSlugs()
+	if err != nil {
+		store.close()
+		return err
+	}
+	superseded := s.superseded(releases, slugs)
+	if s.cfg.DryRun {
+		defer store.close()
+		for _, r := range superseded {
+			fmt.Printf("%-50s %-15s %s\n", r.Slug, r.Version, r.Stored.Format(time.DateOnly))
+		}
+		slog.Info("dry run: archives would be pruned", "archives", len(superseded))
+		return nil
+	}
+	if len(superseded) == 0 {
+		store.close()
+		slog.Info("no archives to prune", "kept", len(releases))
+		return nil
+	}
+
+	// The exporter takes over the database.
+	exporter, err := newSQLExporter(store.db, store.dialect, s.dir.name)
+	if err != nil {
+		return fmt.Errorf("metadata store: %w", err)
+	}
+	sumsPath := filepath.Join(s.cfg.OutputDir, checksumsFile)
+	sums, err := readChecksums(sumsPath)
+	if err != nil {
+		exporter.close()
+		return fmt.Errorf("read checksums: %w", err)
+	}
+
+	reason := fmt.Sprintf("superseded, pruned to the latest %d releases", s.cfg.KeepLatest)
+	removed := map[string]bool{}
+	var failed int
+	for _, r := range superseded {
+		if ctx.Err() != nil {
+			break
+		}
+		started := time.Now()
+		if err := s.removeRelease(ctx, r); err != nil {
+			failed++
+			slog.Error("failed to prune archive", "slug", r.Slug, "version", r.Version, "error", err)
+			continue
+		}
+		sums.remove(r.File)
+		removed[releaseKey(r.Slug, r.Version)] = true
+		rec := MetadataRecord{Plugin: Plugin{Slug: r.Slug, Version: r.Version}, Outcome: "pruned", Reason: reason,
+			Started: started, Finished: time.Now()}
+		if err := exporter.export(rec); err != nil {
+			slog.Error("failed to record pruned archive", "slug", r.Slug, "version", r.Version, "error", err)
+		}
+		slog.Debug("pruned archive", "slug", r.Slug, "version", r.Version, "file", r.File)
+		if err := s.pruneObject(ctx, store, r.SHA256); err != nil {
+			slog.Error("failed to prune deduplicated object", "sha256", r.SHA256, "error", err)
+		}
+	}
+	if err := exporter.close(); err != nil {
+		slog.Error("failed to finish run in metadata store", "error", err)
+	}
+
+	runFiles := []string{sumsPath}
+	if werr := sums.write(sumsPath); werr != nil {
+		slog.Error("failed to write checksums", "error", werr)
+	}
+	manifestPath := filepath.Join(s.cfg.OutputDir, s.dir.name+"-"+manifestFile)
+	if changed, werr := pruneManifest(manifestPath, removed); werr != nil {
+		slog.Error("failed to update manifest", "error", werr)
+	} else if changed {
+		runFiles = append(runFiles, manifestPath)
+	}
+	for _, fileName := range runFiles {
+		if werr := s.publish(context.WithoutCancel(ctx), fileName, true, nil); werr != nil {
+			slog.Error("failed to upload run metadata", "file", fileName, "error", werr)
+		}
+	}
+	slog.Info("pruned archives", "pruned", len(removed), "failed", failed, "kept", len(releases)-len(removed))
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("prune interrupted: %w", err)
+	}
+	if failed > 0 {
+		return partialFailure(fmt.Errorf("%d of %d archives could not be pruned", failed, len(superseded)))
+	}
+	return nil
+}
+
+// superseded returns the releases of releases, which are sorted by slug,
+// that the retention policy removes, for the plugins of slugs if any.
+func (s *Scraper) superseded(releases []storedRelease, slugs []string) []storedRelease {
+	cutoff := time.Now().Add(-time.Duration(s.cfg.OlderThan))
+	var list []storedRelease
+	for start := 0; start < len(releases); {
+		end := start + 1
+		for end < len(releases) && releases[end].Slug == releases[start].Slug {
+			end++
+		}
+		plugin := releases[start:end]
+		start = end
+		if len(slugs) > 0 && !slices.Contains(slugs, plugin[0].Slug) {
+			continue
+		}
+
+		sort.Slice(plugin, func(i, j int) bool {
+			if c := compareVersions(plugin[i].Version, plugin[j].Version); c != 0 {
+				return c > 0
+			}
+			return plugin[i].Version > plugin[j].Version
+		})
+		var kept int
+		for _, r := range plugin {
+			if r.Version == trunkVersion {
+				continue
+			}
+			kept++
+			if kept <= s.cfg.KeepLatest || r.Current || r.Stored.IsZero() ||
+				(s.cfg.OlderThan > 0 && r.Stored.After(cutoff)) {
+				continue
+			}
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// removeRelease deletes the archive of r, and its recompressed copy, from
+// the output directory and the storage of --output.
+func (s *Scraper) removeRelease(ctx context.Context, r storedRelease) error {
+	for _, name := range []string{r.File, r.Repacked} {
+		if name == "" {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("file %q is outside the output directory", name)
+		}
+		if s.cfg.Output != "" {
+			if err := s.storage.Delete(ctx, name); err != nil {
+				return err
+			}
+		}
+		err := os.Remove(filepath.Join(s.cfg.OutputDir, filepath.FromSlash(name)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneObject removes the --dedupe object with the SHA-256 sum once no
+// stored release links to it any more, which the pruned download recorded
+// before makes sure of for the release just removed.
+func (s *Scraper) pruneObject(ctx context.Context, store *metadataStore, sum string) error {
+	if len(sum) != sha256.Size*2 {
+		return nil
+	}
+	object := s.objectPath(sum)
+	if _, err := os.Stat(object); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if used, err := store.referenced(ctx, sum); err != nil || used {
+		return err
+	}
+	if err := os.Remove(object); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	slog.Debug("pruned deduplicated object", "file", object)
+	return nil
+}
+
+// pruneManifest drops the releases in removed from the manifest at path,
+// and reports whether it changed. A missing manifest is left alone.
+func pruneManifest(path string, removed map[string]bool) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) || len(removed) == 0 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	entries := slices.DeleteFunc(m.Plugins, func(entry ManifestEntry) bool {
+		return removed[releaseKey(entry.Slug, entry.Version)]
+	})
+	if len(entries) == len(m.Plugins) {
+		return false, nil
+	}
+	m.Plugins = entries
+	if data, err = json.MarshalIndent(m, "", "  "); err != nil {
+		return false, err
+	}
+	return true, writeFileAtomic(path, data)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Tuning of --adaptive-rate. The rate is halved on throttling and server
+// errors and cut by a fifth when latency more than doubles, at most once per
+// adaptCooldown, and every successful request wins back adaptStep of the
+// configured rate. It never drops below adaptFloor of the configured rate.
+const (
+	adaptCooldown       = 5 * time.Second
+	adaptStep           = 0.02
+	adaptFloor          = 0.05
+	adaptLatencyFactor  = 2
+	adaptLatencySmooth  = 0.1
+	adaptLatencySamples = 20
+)
+
+// rateLimiter is a token bucket that allows a fixed number of requests per
+// second on average and short bursts above it. An adaptive limiter
+// additionally lowers its rate while the server shows signs of overload. A
+// nil *rateLimiter does not limit at all.
+type rateLimiter struct {
+	limiter *rate.Limiter
+
+	adaptive bool
+	mu       sync.Mutex
+	ceiling  float64 // configured requests per second
+	current  float64
+	latency  float64 // moving average in seconds
+	baseline float64 // lowest moving average seen
+	samples  int
+	lastCut  time.Time
+}
+
+// newRateLimiter returns a limiter for perSecond requests per second with
+// bursts of up to burst requests, or nil if perSecond is zero. If adaptive
+// is set, the rate follows the feedback passed to observe.
+func newRateLimiter(perSecond float64, burst int, adaptive bool) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		limiter:  rate.NewLimiter(rate.Limit(perSecond), max(burst, 1)),
+		adaptive: adaptive,
+		ceiling:  perSecond,
+		current:  perSecond,
+	}
+}
+
+// observe adapts the rate of an adaptive limiter to the outcome of a
+// request that took latency until the response headers arrived.
+func (l *rateLimiter) observe(resp *http.Response, err error, latency time.Duration) {
+	if l == nil || !l.adaptive {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case err != nil:
+		// Network errors say little about the server load.
+		return
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		l.cut(0.5, "status", resp.StatusCode)
+		return
+	}
+
+	seconds := latency.Seconds()
+	if l.samples == 0 {
+		l.latency = seconds
+	} else {
+		l.latency += adaptLatencySmooth * (seconds - l.latency)
+	}
+	l.samples++
+	if l.samples >= adaptLatencySamples {
+		if l.baseline == 0 || l.latency < l.baseline {
+			l.baseline = l.latency
+		}
+		if l.latency > adaptLatencyFactor*l.baseline {
+			l.cut(0.8, "latency", time.Duration(l.latency*float64(time.Second)))
+			return
+		}
+	}
+
+	if l.current < l.ceiling {
+		l.set(min(l.ceiling, l.current+adaptStep*l.ceiling))
+	}
+}
+
+// cut lowers the rate by factor unless it was lowered within the cooldown.
+// It must be called with l.mu held.
+func (l *rateLimiter) cut(factor float64, reason string, value any) {
+	if time.Since(l.lastCut) < adaptCooldown {
+		return
+	}
+	l.lastCut = time.Now()
+	l.set(max(adaptFloor*l.ceiling, l.current*factor))
+	slog.Info("lowered request rate", "rate", l.current, "reason", reason, reason, value)
+}
+
+func (l *rateLimiter) set(perSecond float64) {
+	l.current = perSecond
+	l.limiter.SetLimit(rate.Limit(perSecond))
+}
+
+// wait blocks until the limiter allows another request or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// pauseGate holds back every request of a run while a server has asked the
+// scraper to back off with Retry-After. The zero value is open.
+type pauseGate struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// pause closes the gate for d, unless it is already closed for longer.
+func (g *pauseGate) pause(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until := time.Now().Add(d); until.After(g.until) {
+		g.until = until
+	}
+}
+
+// wait blocks until the gate is open or ctx is done.
+func (g *pauseGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	until := g.until
+	g.mu.Unlock()
+	return sleep(ctx, time.Until(until))
+}
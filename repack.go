@@ -0,0 +1,165 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// repackedExt replaces the .zip extension of archives repacked by
+// --repack zstd.
+const repackedExt = ".tar.zst"
+
+// repackModes are the values of --repack.
+var repackModes = []string{"off", "zstd"}
+
+// repackedName returns the name the archive fileName has once repacked.
+func repackedName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + repackedExt
+}
+
+// repack turns the validated zip archive fileName of size bytes with the
+// hex encoded SHA-256 sum into a zstd compressed tar of the same files at
+// the level of --zstd-level, removes the zip and returns the name of the
+// tar. A global PAX header keeps the name, size and SHA-256 of the original
+// zip, which cannot be restored byte for byte, as WPSCRAPER.source,
+// WPSCRAPER.size and WPSCRAPER.sha256.
+func (s *Scraper) repack(fileName string, size int64, sum string) (string, error) {
+	archive, err := zip.OpenReader(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	target := repackedName(fileName)
+	err = writeAtomic(target, func(w io.Writer) error {
+		enc, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(s.cfg.ZstdLevel)))
+		if err != nil {
+			return err
+		}
+		tw := tar.NewWriter(enc)
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeXGlobalHeader,
+			PAXRecords: map[string]string{
+				"WPSCRAPER.source": filepath.Base(fileName),
+				"WPSCRAPER.size":   strconv.FormatInt(size, 10),
+				"WPSCRAPER.sha256": sum,
+			},
+			Format: tar.FormatPAX,
+		})
+		for _, file := range archive.File {
+			if err != nil {
+				break
+			}
+			err = repackFile(tw, file)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		if cerr := enc.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	archive.Close()
+	if err != nil {
+		return "", fmt.Errorf("repack %s: %w", fileName, err)
+	}
+	return target, os.Remove(fileName)
+}
+
+// repackFile appends the zip entry file to tw.
+func repackFile(tw *tar.Writer, file *zip.File) error {
+	info := file.FileInfo()
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     file.Name,
+		Size:     int64(file.UncompressedSize64),
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  file.Modified,
+		Format:   tar.FormatPAX,
+	}
+	if info.IsDir() {
+		header.Typeflag, header.Size = tar.TypeDir, 0
+	}
+	// Zip tools that do not record permissions leave them at zero.
+	if header.Mode == 0 {
+		header.Mode = 0o644
+		if info.IsDir() {
+			header.Mode = 0o755
+		}
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	r, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Name, err)
+	}
+	defer r.Close()
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("%s: %w", file.Name, err)
+	}
+	return nil
+}
+
+// repackedOrigin returns the size and SHA-256 of the zip archive that the
+// repacked archive at path was made from.
+func repackedOrigin(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	dec, err := zstd.NewReader(file)
+	if err != nil {
+		return 0, "", err
+	}
+	defer dec.Close()
+	header, err := tar.NewReader(dec).Next()
+	if err != nil {
+		return 0, "", err
+	}
+	// The reader merges the global header into the first entry.
+	size, err := strconv.ParseInt(header.PAXRecords["WPSCRAPER.size"], 10, 64)
+	sum := header.PAXRecords["WPSCRAPER.sha256"]
+	if err != nil || sum == "" {
+		return 0, "", fmt.Errorf("%s records no original archive", path)
+	}
+	return size, sum, nil
+}
+
+// verifyRepacked checks that the repacked archive at path decompresses and
+// holds a complete tar stream.
+func verifyRepacked(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	dec, err := zstd.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	tr := tar.NewReader(dec)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return err
+		}
+	}
+}
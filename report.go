@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// reportFile is written to the output directory at the end of a download run.
+const reportFile = "report.json"
+
+// Report records the outcome of a download run. It is safe for concurrent
+// use by the download workers.
+type Report struct {
+	mu sync.Mutex
+
+	Started    time.Time     `json:"started"`
+	Finished   time.Time     `json:"finished"`
+	Downloaded int           `json:"downloaded"`
+	Bytes      int64         `json:"bytes"`
+	Failed     []ReportEntry `json:"failed"`
+	Skipped    []ReportEntry `json:"skipped"`
+}
+
+// ReportEntry describes a plugin that was not downloaded.
+type ReportEntry struct {
+	Slug    string `json:"slug"`
+	Version string `json:"version"`
+	Reason  string `json:"reason"`
+	Bytes   int64  `json:"bytes,omitempty"`
+}
+
+func newReport() *Report {
+	return &Report{Started: time.Now(), Failed: []ReportEntry{}, Skipped: []ReportEntry{}}
+}
+
+func (r *Report) downloaded(bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Downloaded++
+	r.Bytes += bytes
+}
+
+func (r *Report) failed(plugin Plugin, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failed = append(r.Failed, ReportEntry{Slug: plugin.Slug, Version: plugin.Version, Reason: err.Error()})
+}
+
+func (r *Report) skipped(plugin Plugin, reason string, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped = append(r.Skipped, ReportEntry{Slug: plugin.Slug, Version: plugin.Version, Reason: reason, Bytes: bytes})
+}
+
+// progress returns the number of finished and failed downloads and the
+// bytes downloaded so far.
+func (r *Report) progress() (finished, failed int, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Downloaded + len(r.Failed) + len(r.Skipped), len(r.Failed), r.Bytes
+}
+
+// attempts returns the number of downloads that were attempted, that is
+// not skipped, and how many of them failed.
+func (r *Report) attempts() (attempted, failed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Downloaded + len(r.Failed), len(r.Failed)
+}
+
+func (r *Report) failures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Failed)
+}
+
+// write stores the report as JSON in dir.
+func (r *Report) write(dir string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, reportFile), data)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// responseTimeFormat is the timestamp in the names of archived responses.
+// It sorts in time order.
+const responseTimeFormat = "20060102T150405Z"
+
+// responseName returns the name that the response to the API request at
+// rawURL is archived under, without timestamp and extension: the page of a
+// query_plugins or query_themes request as <kind>/pages/<page>, and the slug
+// of a plugin_information or theme_information request as
+// <kind>/info/<slug>. Other requests are not archived.
+func (s *Scraper) responseName(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	query := u.Query()
+	switch query.Get("action") {
+	case s.dir.listAction:
+		var page int
+		if _, err := fmt.Sscan(query.Get("request[page]"), &page); err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("%s/pages/%05d", s.dir.name, page), true
+	case s.dir.infoAction:
+		slug := query.Get("request[slug]")
+		if slug == "" {
+			return "", false
+		}
+		return s.dir.name + "/info/" + sanitizeName(slug), true
+	}
+	return "", false
+}
+
+// archiveResponse returns body, which answers the request at rawURL, so that
+// whatever it returns is also written to --save-responses, gzip
+// compressed. The response is stored when body is closed, once any unread
+// rest has been received.
+func (s *Scraper) archiveResponse(rawURL string, body io.ReadCloser) io.ReadCloser {
+	name, ok := s.responseName(rawURL)
+	if s.cfg.SaveResponses == "" || !ok {
+		return body
+	}
+	fileName := filepath.Join(s.cfg.SaveResponses, filepath.FromSlash(name)+"-"+time.Now().UTC().Format(responseTimeFormat)+".json.gz")
+	if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+		slog.Warn("failed to archive API response", "url", rawURL, "error", err)
+		return body
+	}
+	file, err := os.Create(fileName + tmpSuffix)
+	if err != nil {
+		slog.Warn("failed to archive API response", "url", rawURL, "error", err)
+		return body
+	}
+	gz := gzip.NewWriter(file)
+	return &archivedBody{ReadCloser: body, tee: io.TeeReader(body, gz), file: file, gz: gz, fileName: fileName}
+}
+
+// archivedBody copies a response body to a gzip file while it is read.
+type archivedBody struct {
+	io.ReadCloser
+	tee      io.Reader
+	file     *os.File
+	gz       *gzip.Writer
+	fileName string
+}
+
+func (b *archivedBody) Read(p []byte) (int, error) {
+	return b.tee.Read(p)
+}
+
+func (b *archivedBody) Close() error {
+	_, err := io.Copy(io.Discard, b.tee)
+	if cerr := b.gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := b.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(b.file.Name(), b.fileName)
+	}
+	if err != nil {
+		os.Remove(b.file.Name())
+		slog.Warn("failed to archive API response", "file", b.fileName, "error", err)
+	}
+	return b.ReadCloser.Close()
+}
+
+// replayResponse answers the API request req from the latest response
+// archived for it below --replay. ok is false for requests that are not
+// archived, which go to the network as usual. A request without an
+// archived response is answered with 404 Not Found.
+func (s *Scraper) replayResponse(req *http.Request) (resp *http.Response, ok bool, err error) {
+	name, ok := s.responseName(req.URL.String())
+	if !ok {
+		return nil, false, nil
+	}
+	resp = &http.Response{
+		Status:     "404 Not Found",
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	matches, err := filepath.Glob(filepath.Join(s.cfg.Replay, filepath.FromSlash(name)) + "-*.json.gz")
+	if err != nil || len(matches) == 0 {
+		slog.Debug("no archived response to replay", "name", name)
+		return resp, true, err
+	}
+	sort.Strings(matches)
+	fileName := matches[len(matches)-1]
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, true, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, true, fmt.Errorf("%s: %w", fileName, err)
+	}
+	slog.Debug("replaying archived response", "file", fileName)
+	resp.Status, resp.StatusCode = "200 OK", http.StatusOK
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Body = &replayBody{Reader: gz, file: file}
+	return resp, true, nil
+}
+
+// replayBody is the decompressed content of an archived response.
+type replayBody struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (b *replayBody) Close() error {
+	b.Reader.Close()
+	return b.file.Close()
+}
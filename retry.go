@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRetryAfter caps the pause a Retry-After header can impose.
+const maxRetryAfter = 10 * time.Minute
+
+// statusError reports an HTTP response with an unexpected status code.
+type statusError struct {
+	code   int
+	status string
+	// retryAfter is the wait the server asked for with a 429 or 503.
+	retryAfter time.Duration
+}
+
+// statusError returns the error for the unexpected status of resp. If the
+// server asks to back off with Retry-After, every request of the run is
+// paused for the advised duration, so that no worker burns its retries
+// in the meantime.
+func (s *Scraper) statusError(resp *http.Response) *statusError {
+	err := &statusError{code: resp.StatusCode, status: resp.Status}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	if err.retryAfter > 0 {
+		slog.Warn("server asked to back off, pausing requests", "url", resp.Request.URL.Redacted(),
+			"status", resp.StatusCode, "pause", err.retryAfter)
+		s.pause.pause(err.retryAfter)
+	}
+	return err
+}
+
+// parseRetryAfter returns the wait of a Retry-After header, given either in
+// seconds or as an HTTP date, capped at maxRetryAfter.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	var d time.Duration
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		d = date.Sub(now)
+	}
+	return max(0, min(d, maxRetryAfter))
+}
+
+func (e *statusError) Error() string {
+	return "status code error: " + e.status
+}
+
+// retryable reports whether a failed download may succeed when it is
+// attempted again. Deliberate skips, client errors, invalid archives and
+// local file system errors are permanent; network errors, server errors and
+// interrupted transfers are not.
+func retryable(err error) bool {
+	var skip *skipError
+	var status *statusError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &skip):
+		return false
+	case errors.As(err, &status):
+		return status.code >= 500 || status.code == http.StatusRequestTimeout || status.code == http.StatusTooManyRequests
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, errInvalidArchive), errors.Is(err, errTarStream):
+		return false
+	case errors.As(err, &pathErr):
+		return false
+	}
+	return true
+}
+
+// sleep pauses for d, or returns early with the error of ctx once it is
+// done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns how long to wait before the next attempt after the given
+// number of failed attempts: --retry-backoff doubled for every further
+// attempt, capped at --retry-backoff-max, with up to half of it randomized
+// so that workers that failed together do not retry together.
+func (s *Scraper) backoff(attempt int) time.Duration {
+	delay := time.Duration(s.cfg.RetryBackoff)
+	maxDelay := time.Duration(s.cfg.RetryBackoffMax)
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
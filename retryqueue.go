@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// retryQueueFile lists the downloads that failed in earlier runs, so that
+// the retry-failed command can attempt just those again.
+const retryQueueFile = "retry-queue.json"
+
+// retryEntry is a failed download in the retry queue. The full plugin
+// metadata is kept so that a retry names and verifies the archive the same
+// way as the original run.
+type retryEntry struct {
+	Plugin Plugin    `json:"plugin"`
+	URL    string    `json:"url"`
+	Error  string    `json:"error"`
+	Failed time.Time `json:"failed"`
+	// Runs counts the runs in which the download failed.
+	Runs int `json:"runs"`
+}
+
+// retryQueue is the retry queue of an output directory. It is safe for
+// concurrent use by the download workers.
+type retryQueue struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*retryEntry
+	changed bool
+}
+
+// readRetryQueue loads the retry queue of dir. A missing queue is empty.
+func readRetryQueue(dir string) (*retryQueue, error) {
+	q := &retryQueue{path: filepath.Join(dir, retryQueueFile), entries: map[string]*retryEntry{}}
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*retryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", q.path, err)
+	}
+	for _, entry := range entries {
+		q.entries[releaseKey(entry.Plugin.Slug, entry.Plugin.Version)] = entry
+	}
+	return q, nil
+}
+
+// record updates the queue with the outcome of the download of plugin: a
+// failure is added, anything else takes the plugin off the queue.
+func (q *retryQueue) record(plugin Plugin, err error) {
+	var skip *skipError
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := releaseKey(plugin.Slug, plugin.Version)
+	if err == nil || errors.As(err, &skip) {
+		if _, ok := q.entries[key]; ok {
+			delete(q.entries, key)
+			q.changed = true
+		}
+		return
+	}
+	entry, ok := q.entries[key]
+	if !ok {
+		entry = &retryEntry{}
+		q.entries[key] = entry
+	}
+	entry.Plugin = plugin
+	entry.URL = plugin.DownloadLink
+	entry.Error = err.Error()
+	entry.Failed = time.Now()
+	entry.Runs++
+	q.changed = true
+}
+
+// list returns the entries sorted by slug and version.
+func (q *retryQueue) list() []*retryEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]*retryEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Plugin, entries[j].Plugin
+		if a.Slug != b.Slug {
+			return a.Slug < b.Slug
+		}
+		return a.Version < b.Version
+	})
+	return entries
+}
+
+// write stores the queue if it changed. An empty queue is removed.
+func (q *retryQueue) write() error {
+	q.mu.Lock()
+	changed := q.changed
+	q.mu.Unlock()
+	if !changed {
+		return nil
+	}
+
+	entries := q.list()
+	if len(entries) == 0 {
+		err := os.Remove(q.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, data)
+}
+
+// runRetryFailed downloads the plugins of the retry queue again. Downloads
+// that succeed leave the queue; the others stay with their new error.
+func runRetryFailed(ctx context.Context, s *Scraper) error {
+	queue, err := readRetryQueue(s.cfg.OutputDir)
+	if err != nil {
+		return fmt.Errorf("read retry queue: %w", err)
+	}
+	entries := queue.list()
+	if len(entries) == 0 {
+		slog.Info("no failed downloads to retry", "file", queue.path)
+		return nil
+	}
+	slog.Info("retrying failed downloads", "plugins", len(entries), "file", queue.path)
+
+	source := func(fn func(Plugin) error) error {
+		for _, entry := range entries {
+			if err := fn(entry.Plugin); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s.cfg.DryRun {
+		return dryRun(source, "downloaded")
+	}
+	return s.downloadAll(ctx, source)
+}
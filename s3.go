@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// minS3PartSize and maxS3Parts are the limits S3 sets for multipart
+	// uploads.
+	minS3PartSize = 5 << 20
+	maxS3Parts    = 10000
+
+	defaultS3Region = "us-east-1"
+
+	awsTimeFormat = "20060102T150405Z"
+	// emptySHA256 is the payload hash of requests without a body, and
+	// unsignedPayload that of uploads, whose content is not hashed twice.
+	emptySHA256     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// s3Storage stores files in an Amazon S3 bucket, or a bucket of an S3
+// compatible service with --s3-endpoint. Files larger than --s3-part-size
+// are sent as multipart uploads.
+type s3Storage struct {
+	client *http.Client
+	creds  awsCredentials
+	// endpoint is the bucket URL of virtual-hosted requests, or the service
+	// URL of path-style requests to --s3-endpoint.
+	endpoint     url.URL
+	pathStyle    bool
+	bucket       string
+	prefix       string
+	region       string
+	storageClass string
+	partSize     int64
+}
+
+// newS3Storage returns the storage of an s3://bucket/prefix target.
+func newS3Storage(cfg Config, client *http.Client, target *url.URL) (*s3Storage, error) {
+	creds, err := loadAWSCredentials()
+	if err != nil {
+		return nil, err
+	}
+	s := &s3Storage{
+		client:       client,
+		creds:        creds,
+		bucket:       target.Host,
+		prefix:       strings.Trim(target.Path, "/"),
+		region:       cfg.S3Region,
+		storageClass: cfg.S3StorageClass,
+		partSize:     int64(cfg.S3PartSize),
+	}
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if s.region == "" {
+			s.region = os.Getenv(name)
+		}
+	}
+	if s.region == "" {
+		s.region = defaultS3Region
+	}
+	if cfg.S3Endpoint != "" {
+		endpoint, err := url.Parse(cfg.S3Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("s3-endpoint: %w", err)
+		}
+		s.endpoint, s.pathStyle = *endpoint, true
+	} else {
+		s.endpoint = url.URL{Scheme: "https", Host: s.bucket + ".s3." + s.region + ".amazonaws.com"}
+	}
+	return s, nil
+}
+
+// bucketURL returns the URL of the bucket with the given query parameters.
+func (s *s3Storage) bucketURL(query url.Values) *url.URL {
+	target := s.endpoint
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/"
+	if s.pathStyle {
+		target.Path += s.bucket
+	}
+	target.RawPath = awsEscape(target.Path, false)
+	target.RawQuery = canonicalQuery(query)
+	return &target
+}
+
+// objectURL returns the URL of the object name below the prefix, with the
+// given query parameters.
+func (s *s3Storage) objectURL(name string, query url.Values) *url.URL {
+	key := "/" + s.key(name)
+	if s.pathStyle {
+		key = "/" + s.bucket + key
+	}
+	target := s.endpoint
+	target.Path = strings.TrimSuffix(target.Path, "/") + key
+	target.RawPath = awsEscape(target.Path, false)
+	target.RawQuery = canonicalQuery(query)
+	return &target
+}
+
+// key returns the object key of name.
+func (s *s3Storage) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// Put stores r as name, with meta as user-defined object metadata.
+func (s *s3Storage) Put(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error {
+	if size > s.partSize {
+		return s.putMultipart(ctx, name, r, size, meta)
+	}
+	req, err := s.newRequest(ctx, http.MethodPut, s.objectURL(name, nil), section(r, 0, size), size)
+	if err != nil {
+		return err
+	}
+	s.setObjectHeaders(req, name, meta)
+	resp, err := s.send(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Exists(ctx context.Context, name string) (bool, error) {
+	return exists(s.Stat(ctx, name))
+}
+
+func (s *s3Storage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, s.objectURL(name, nil), nil, 0)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp, err := s.send(req, emptySHA256)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return FileInfo{Name: name, Size: resp.ContentLength, Modified: modified}, nil
+}
+
+// Delete removes the object name. S3 answers deletes of missing objects
+// with success as well.
+func (s *s3Storage) Delete(ctx context.Context, name string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, s.objectURL(name, nil), nil, 0)
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(req, emptySHA256)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List pages through the objects below the prefix with ListObjectsV2.
+func (s *s3Storage) List(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	root := ""
+	if s.prefix != "" {
+		root = s.prefix + "/"
+	}
+	query := url.Values{"list-type": {"2"}, "prefix": {root + prefix}}
+	for {
+		req, err := s.newRequest(ctx, http.MethodGet, s.bucketURL(query), nil, 0)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := s.sendXML(req, emptySHA256, &page); err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(object.Key, root)
+			if err := fn(FileInfo{Name: name, Size: object.Size, Modified: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// putMultipart sends r in parts of --s3-part-size, enlarged if the file
+// would otherwise take more than maxS3Parts parts. A failed upload is
+// aborted so that the bucket does not keep paying for its parts.
+func (s *s3Storage) putMultipart(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error {
+	partSize := max(s.partSize, (size+maxS3Parts-1)/maxS3Parts)
+
+	req, err := s.newRequest(ctx, http.MethodPost, s.objectURL(name, url.Values{"uploads": {""}}), nil, 0)
+	if err != nil {
+		return err
+	}
+	s.setObjectHeaders(req, name, meta)
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.sendXML(req, emptySHA256, &created); err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+
+	completed := s3CompletedUpload{}
+	for number, offset := 1, int64(0); offset < size; number, offset = number+1, offset+partSize {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {created.UploadID}}
+		n := min(partSize, size-offset)
+		req, err := s.newRequest(ctx, http.MethodPut, s.objectURL(name, query), section(r, offset, n), n)
+		if err != nil {
+			return err
+		}
+		resp, err := s.send(req, unsignedPayload)
+		if err != nil {
+			s.abortMultipart(ctx, name, created.UploadID)
+			return fmt.Errorf("upload part %d: %w", number, err)
+		}
+		resp.Body.Close()
+		completed.Parts = append(completed.Parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(completed)
+	if err != nil {
+		return err
+	}
+	req, err = s.newRequest(ctx, http.MethodPost, s.objectURL(name, url.Values{"uploadId": {created.UploadID}}),
+		bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	// S3 may report a failed completion with a 200 and an error document.
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	err = s.sendXML(req, hex.EncodeToString(sum[:]), &result)
+	if err == nil && result.XMLName.Local == "Error" {
+		err = fmt.Errorf("%s: %s", result.Code, result.Message)
+	}
+	if err != nil {
+		s.abortMultipart(ctx, name, created.UploadID)
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// abortMultipart discards the parts of a failed upload, even if ctx was
+// cancelled.
+func (s *s3Storage) abortMultipart(ctx context.Context, name, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+	req, err := s.newRequest(ctx, http.MethodDelete, s.objectURL(name, url.Values{"uploadId": {uploadID}}), nil, 0)
+	if err != nil {
+		return
+	}
+	if resp, err := s.send(req, emptySHA256); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// s3CompletedUpload is the body of a CompleteMultipartUpload request.
+type s3CompletedUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+// newRequest returns a request of target with the size bytes of body, if
+// any, as its content.
+func (s *s3Storage) newRequest(ctx context.Context, method string, target *url.URL, body io.Reader, size int64) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	setBody(req, body, size)
+	return req, nil
+}
+
+// setObjectHeaders sets the content type, storage class and metadata of an
+// upload.
+func (s *s3Storage) setObjectHeaders(req *http.Request, name string, meta map[string]string) {
+	req.Header.Set("Content-Type", contentType(name))
+	if s.storageClass != "" {
+		req.Header.Set("X-Amz-Storage-Class", s.storageClass)
+	}
+	for key, value := range meta {
+		req.Header.Set("X-Amz-Meta-"+key, value)
+	}
+}
+
+// send signs and sends req, whose body has the hex encoded SHA-256
+// payloadHash. Responses other than 2xx are returned as errors.
+func (s *s3Storage) send(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.creds.sign(req, payloadHash, s.region, "s3", time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, xmlError(resp)
+	}
+	return resp, nil
+}
+
+// sendXML is send for requests that answer with an XML document, which is
+// decoded into v.
+func (s *s3Storage) sendXML(req *http.Request, payloadHash string, v any) error {
+	resp, err := s.send(req, payloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// awsCredentials are the keys requests to AWS are signed with.
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// loadAWSCredentials reads the credentials from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or else from the AWS_PROFILE
+// or default profile of the shared credentials file.
+func loadAWSCredentials() (awsCredentials, error) {
+	creds := awsCredentials{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKey != "" && creds.secretKey != "" {
+		return creds, nil
+	}
+
+	fileName := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if fileName == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return creds, fmt.Errorf("no AWS credentials: %w", err)
+		}
+		fileName = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return creds, fmt.Errorf("no AWS credentials in the environment or %s: %w", fileName, err)
+	}
+	defer file.Close()
+
+	creds = awsCredentials{}
+	var section string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch value = strings.TrimSpace(value); strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.accessKey = value
+		case "aws_secret_access_key":
+			creds.secretKey = value
+		case "aws_session_token":
+			creds.sessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return creds, err
+	}
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return creds, fmt.Errorf("no AWS credentials for profile %q in %s", profile, fileName)
+	}
+	return creds, nil
+}
+
+// sign adds the AWS Signature Version 4 authorization of service in region
+// to req, whose body has the hex encoded SHA-256 payloadHash. All headers
+// set on req so far are signed.
+func (c awsCredentials) sign(req *http.Request, payloadHash, region, service string, now time.Time) {
+	amzDate := now.UTC().Format(awsTimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "authorization" || name == "user-agent" {
+			continue
+		}
+		for i, value := range values {
+			values[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[name] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	signedHeaders := strings.Join(names, ";")
+
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, uri, req.URL.RawQuery)
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, headers[name])
+	}
+	fmt.Fprintf(&canonical, "\n%s\n%s", signedHeaders, payloadHash)
+
+	date := amzDate[:8]
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalSum := sha256.Sum256([]byte(canonical.String()))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+
+	key := []byte("AWS4" + c.secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes every byte of s except the unreserved
+// characters, and slashes unless all is set, as the canonical request
+// requires.
+func awsEscape(s string, all bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !all:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by name, as the canonical request
+// requires.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(name, true)+"="+awsEscape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
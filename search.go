@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/char/html"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// searchIndexDir in the output directory holds the full-text index that
+// --search-index builds and the search command queries.
+const searchIndexDir = "search.bleve"
+
+// searchAnalyzer strips the HTML of descriptions and readmes and stems
+// English words, so that "two factor" finds "two-factor authentication".
+const searchAnalyzer = "wordpress"
+
+// indexedPlugin is the document of a plugin in the full-text index. Readme
+// holds the text of all readme sections.
+type indexedPlugin struct {
+	Kind             string   `json:"kind"`
+	Slug             string   `json:"slug"`
+	Name             string   `json:"name"`
+	Version          string   `json:"version"`
+	Author           string   `json:"author"`
+	ShortDescription string   `json:"short_description"`
+	Readme           string   `json:"readme"`
+	Tags             []string `json:"tags"`
+	ActiveInstalls   int      `json:"active_installs"`
+	Rating           int      `json:"rating"`
+}
+
+func newIndexedPlugin(kind string, plugin Plugin) indexedPlugin {
+	var readme []string
+	for _, name := range sortedKeys(plugin.Sections) {
+		readme = append(readme, string(plugin.Sections[name]))
+	}
+	if plugin.Description != "" {
+		readme = append(readme, string(plugin.Description))
+	}
+	tags := make([]string, 0, len(plugin.Tags))
+	for _, slug := range sortedKeys(plugin.Tags) {
+		tags = append(tags, plugin.Tags[slug])
+	}
+	return indexedPlugin{
+		Kind:             kind,
+		Slug:             plugin.Slug,
+		Name:             string(plugin.Name),
+		Version:          plugin.Version,
+		Author:           string(plugin.Author),
+		ShortDescription: string(plugin.ShortDescription),
+		Readme:           strings.Join(readme, "\n"),
+		Tags:             tags,
+		ActiveInstalls:   plugin.ActiveInstalls,
+		Rating:           plugin.Rating,
+	}
+}
+
+// searchMapping returns the mapping of the full-text index. The slug and
+// kind are matched exactly, the texts with searchAnalyzer, and the fields
+// the search command prints are stored.
+func searchMapping() (mapping.IndexMapping, error) {
+	m := bleve.NewIndexMapping()
+	err := m.AddCustomAnalyzer(searchAnalyzer, map[string]any{
+		"type":          custom.Name,
+		"char_filters":  []string{html.Name},
+		"tokenizer":     unicode.Name,
+		"token_filters": []string{en.PossessiveName, lowercase.Name, en.StopName, en.SnowballStemmerName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.DefaultAnalyzer = searchAnalyzer
+
+	keyword := bleve.NewKeywordFieldMapping()
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = searchAnalyzer
+	stored := bleve.NewTextFieldMapping()
+	stored.Analyzer = searchAnalyzer
+	stored.Store = true
+	storedKeyword := bleve.NewKeywordFieldMapping()
+	storedKeyword.Store = true
+	number := bleve.NewNumericFieldMapping()
+	number.Store = true
+
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("kind", keyword)
+	doc.AddFieldMappingsAt("slug", storedKeyword)
+	doc.AddFieldMappingsAt("name", stored)
+	doc.AddFieldMappingsAt("version", storedKeyword)
+	doc.AddFieldMappingsAt("author", text)
+	doc.AddFieldMappingsAt("short_description", text)
+	doc.AddFieldMappingsAt("readme", text)
+	doc.AddFieldMappingsAt("tags", text)
+	doc.AddFieldMappingsAt("active_installs", number)
+	doc.AddFieldMappingsAt("rating", number)
+	m.DefaultMapping = doc
+	return m, nil
+}
+
+// openSearchIndex opens the full-text index in dir, creating it if needed.
+func openSearchIndex(dir string) (bleve.Index, error) {
+	index, err := bleve.Open(dir)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		var m mapping.IndexMapping
+		if m, err = searchMapping(); err == nil {
+			index, err = bleve.New(dir, m)
+		}
+	}
+	return index, err
+}
+
+// indexExporter indexes the plugins of a download run with --search-index.
+// Plugins have one document, by kind and slug, which later runs replace,
+// and are indexed in batches like those of searchExporter.
+type indexExporter struct {
+	index bleve.Index
+	kind  string
+	size  int
+	batch *bleve.Batch
+}
+
+func newIndexExporter(s *Scraper) (exporter, error) {
+	index, err := openSearchIndex(filepath.Join(s.cfg.OutputDir, searchIndexDir))
+	if err != nil {
+		return nil, fmt.Errorf("open search index: %w", err)
+	}
+	e := &indexExporter{index: index, kind: s.dir.name, size: s.cfg.Query.PerPage, batch: index.NewBatch()}
+	if e.size == 0 {
+		e.size = defaultBulkSize
+	}
+	return e, nil
+}
+
+func (e *indexExporter) export(rec MetadataRecord) error {
+	// Plugins that come without metadata, such as those of --from-manifest,
+	// keep the document of earlier runs.
+	if rec.Plugin.Name == "" {
+		return nil
+	}
+	if err := e.batch.Index(e.kind+":"+rec.Plugin.Slug, newIndexedPlugin(e.kind, rec.Plugin)); err != nil {
+		return err
+	}
+	if e.batch.Size() < e.size {
+		return nil
+	}
+	return e.flush()
+}
+
+func (e *indexExporter) flush() error {
+	if e.batch.Size() == 0 {
+		return nil
+	}
+	err := e.index.Batch(e.batch)
+	e.batch.Reset()
+	return err
+}
+
+func (e *indexExporter) close() error {
+	err := e.flush()
+	if cerr := e.index.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runSearch prints the plugins of --kind in the full-text index of the
+// output directory that match the query given as arguments, best first.
+// The query takes the syntax of bleve query strings, such as
+// "two factor", +name:backup or tags:seo -author:yoast.
+func runSearch(ctx context.Context, s *Scraper) error {
+	query := strings.Join(s.cfg.Args, " ")
+	if query == "" {
+		return fmt.Errorf("search needs a query, as in wpscraper search \"two factor\"")
+	}
+	dir := filepath.Join(s.cfg.OutputDir, searchIndexDir)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("no search index in %s, build it with download --search-index: %w", s.cfg.OutputDir, err)
+	}
+	index, err := bleve.OpenUsing(dir, map[string]any{"read_only": true})
+	if err != nil {
+		return fmt.Errorf("open search index: %w", err)
+	}
+	defer index.Close()
+
+	kind := bleve.NewTermQuery(s.dir.name)
+	kind.SetField("kind")
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(kind, bleve.NewQueryStringQuery(query)), s.cfg.SearchResults, 0, false)
+	req.Fields = []string{"slug", "name", "version", "active_installs"}
+	res, err := index.SearchInContext(ctx, req)
+	if err != nil {
+		return err
+	}
+	for _, hit := range res.Hits {
+		installs, _ := hit.Fields["active_installs"].(float64)
+		fmt.Printf("%-50s %-15s %10d  %s\n", hit.Fields["slug"], hit.Fields["version"], int(installs), hit.Fields["name"])
+	}
+	slog.Info("search finished", "shown", len(res.Hits), "matches", res.Total)
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// segmented reports whether the archive of resp is downloaded in parallel
+// segments: --segments must be above one, the archive must reach
+// --segment-threshold and the server must accept byte ranges.
+func (s *Scraper) segmented(resp *http.Response) bool {
+	return s.cfg.Segments > 1 &&
+		resp.StatusCode == http.StatusOK &&
+		resp.ContentLength > 0 &&
+		resp.ContentLength >= int64(s.cfg.SegmentThreshold) &&
+		resp.Header.Get("Accept-Ranges") == "bytes"
+}
+
+// transferSegments downloads the size bytes at rawURL into fileName with
+// --segments parallel Range requests, each writing its part of the
+// preallocated .part file. Unlike a sequential transfer the .part file has
+// holes until every segment is done, so it is removed on failure instead of
+// being kept for a resume.
+func (s *Scraper) transferSegments(ctx context.Context, rawURL, fileName string, size int64, validate func(string) error) (int64, string, error) {
+	partName := fileName + partSuffix
+	file, err := os.Create(partName)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		os.Remove(partName)
+		return 0, "", err
+	}
+
+	segments := int64(s.cfg.Segments)
+	segmentSize := (size + segments - 1) / segments
+	slog.Debug("downloading in segments", "file", fileName, "bytes", size, "segments", segments)
+
+	var wg sync.WaitGroup
+	errs := make([]error, segments)
+	for i := int64(0); i < segments; i++ {
+		first := i * segmentSize
+		last := min(first+segmentSize, size) - 1
+		if first > last {
+			break
+		}
+		wg.Add(1)
+		go func(i, first, last int64) {
+			defer wg.Done()
+			errs[i] = s.fetchSegment(ctx, rawURL, io.NewOffsetWriter(file, first), first, last)
+		}(i, first, last)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			file.Close()
+			os.Remove(partName)
+			return 0, "", fmt.Errorf("segment %d: %w", i+1, err)
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return 0, "", err
+	}
+	if err := file.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := s.finishArchive(partName, fileName, validate); err != nil {
+		return size, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fetchSegment requests the bytes first to last of rawURL and writes them
+// to w.
+func (s *Scraper) fetchSegment(parent context.Context, rawURL string, w io.Writer, first, last int64) error {
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+
+	resp, err := s.do(s.downloadLimiter, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return s.statusError(resp)
+	}
+	if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != first {
+		return fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), first)
+	}
+
+	want := last - first + 1
+	release, err := s.inFlight.acquire(ctx, want)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	shaped, release := s.shape(ctx, resp.Body)
+	defer release()
+	body, stop := s.watchTransfer(shaped, cancel)
+	n, err := copyArchive(w, io.LimitReader(body, want))
+	stop()
+	if err != nil {
+		return transferError(ctx, err)
+	}
+	if n != want {
+		return fmt.Errorf("%w: received %d of %d bytes", errTruncated, n, want)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// selectTop buffers every plugin produced by source and passes the n with
+// the most active installs to fn, most popular first. Buffering makes the
+// selection global instead of per page.
+func selectTop(source func(func(Plugin) error) error, n int, fn func(Plugin) error) error {
+	var plugins []Plugin
+	err := source(func(plugin Plugin) error {
+		plugins = append(plugins, plugin)
+		return nil
+	})
+	if err != nil && !isPartial(err) {
+		return err
+	}
+
+	sort.SliceStable(plugins, func(i, j int) bool {
+		return plugins[i].ActiveInstalls > plugins[j].ActiveInstalls
+	})
+	if len(plugins) > n {
+		plugins = plugins[:n]
+	}
+	slog.Info("selected most installed plugins", "plugins", len(plugins))
+
+	for _, plugin := range plugins {
+		if ferr := fn(plugin); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+// selectSample passes a uniform random sample of n plugins produced by
+// source to fn, in the order source produced them. The sample is drawn with
+// reservoir sampling, so the same seed and source yield the same sample.
+func selectSample(source func(func(Plugin) error) error, n int, seed int64, fn func(Plugin) error) error {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	type sampled struct {
+		index  int
+		plugin Plugin
+	}
+	var reservoir []sampled
+	var seen int
+	err := source(func(plugin Plugin) error {
+		if len(reservoir) < n {
+			reservoir = append(reservoir, sampled{seen, plugin})
+		} else if j := rng.Intn(seen + 1); j < n {
+			reservoir[j] = sampled{seen, plugin}
+		}
+		seen++
+		return nil
+	})
+	if err != nil && !isPartial(err) {
+		return err
+	}
+
+	sort.Slice(reservoir, func(i, j int) bool {
+		return reservoir[i].index < reservoir[j].index
+	})
+	slog.Info("selected random sample", "plugins", len(reservoir), "candidates", seen, "seed", seed)
+
+	for _, s := range reservoir {
+		if ferr := fn(s.plugin); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
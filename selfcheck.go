@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// selfCheckTimeout bounds the API probe of the self-check.
+const selfCheckTimeout = 30 * time.Second
+
+// selfCheck looks for problems that would otherwise only surface deep into
+// a run of cmd: an unreachable API, an output directory that cannot be
+// written, too little free disk space or settings that fail at run time.
+// It reports every problem it finds at once.
+func (s *Scraper) selfCheck(ctx context.Context, cmd command) error {
+	var problems []error
+	if cmd.writes && !s.cfg.DryRun {
+		if err := checkWritable(s.cfg.OutputDir); err != nil {
+			problems = append(problems, fmt.Errorf("output directory %s is not writable: %w; pick another --output-dir or fix its permissions", s.cfg.OutputDir, err))
+		} else if err := s.checkFreeSpace(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if s.cfg.SlugsFile != "" && s.cfg.SlugsFile != "-" {
+		if _, err := os.Stat(s.cfg.SlugsFile); err != nil {
+			problems = append(problems, fmt.Errorf("slugs-file: %w", err))
+		}
+	}
+	if _, err := s.archivePath(Plugin{Slug: "example", Version: "1.0"}); err != nil {
+		problems = append(problems, fmt.Errorf("%w; see the Archive layout section of the README for the available fields", err))
+	}
+	switch {
+	case s.cfg.Replay != "":
+		if info, err := os.Stat(s.cfg.Replay); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Errorf("replay: %s is not a directory of saved responses", s.cfg.Replay))
+		}
+	case s.cfg.FromManifest != "" && cmd.name == "download":
+		if _, err := os.Stat(s.cfg.FromManifest); err != nil {
+			problems = append(problems, fmt.Errorf("from-manifest: %w", err))
+		}
+	case cmd.name == "download" || cmd.name == "fetch" || cmd.name == "list":
+		if err := s.probeAPI(ctx); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("self-check failed (disable it with --self-check=false): %w", errors.Join(problems...))
+	}
+	slog.Debug("self-check passed")
+	return nil
+}
+
+// checkWritable creates dir if needed and writes a temporary file to it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".wpscraper-check-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkFreeSpace fails if the file system of the output directory has less
+// than --min-free-space available.
+func (s *Scraper) checkFreeSpace() error {
+	if s.cfg.MinFreeSpace <= 0 {
+		return nil
+	}
+	free, err := freeSpace(s.cfg.OutputDir)
+	if err != nil {
+		return fmt.Errorf("check free disk space: %w", err)
+	}
+	if free >= 0 && free < int64(s.cfg.MinFreeSpace) {
+		return fmt.Errorf("only %.1fGB free in %s, less than --min-free-space %s; free up space or lower the limit",
+			float64(free)/1e9, s.cfg.OutputDir, s.cfg.MinFreeSpace)
+	}
+	return nil
+}
+
+// probeAPI requests a single plugin from the first page of the directory.
+func (s *Scraper) probeAPI(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+	defer cancel()
+	query := s.listQuery(s.cfg.StartPage)
+	query.Set("request[per_page]", "1")
+	var list PluginList
+	if err := s.getJSONOnce(ctx, s.limiter, s.apiURL(query), &list); err != nil {
+		return fmt.Errorf("cannot reach the %s API at %s: %w; check the network, the proxy settings (HTTPS_PROXY) and --dns-server",
+			s.dir.name, s.dir.apiURL, err)
+	}
+	return nil
+}
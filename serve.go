@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// serveModes are the APIs the serve command can serve.
+var serveModes = []string{"graphql"}
+
+// runServe serves the API named by its argument, such as
+// `serve graphql`, on --listen until it is interrupted.
+func runServe(ctx context.Context, s *Scraper) error {
+	if len(s.cfg.Args) != 1 || !slices.Contains(serveModes, s.cfg.Args[0]) {
+		return fmt.Errorf("serve takes one of %s, as in wpscraper serve graphql", strings.Join(serveModes, ", "))
+	}
+	store, err := s.openMetadataStore()
+	if err != nil {
+		return err
+	}
+	defer store.close()
+	handler, err := s.newGraphQLHandler(store)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", handler)
+	return s.listenAndServe(ctx, mux, "/graphql")
+}
+
+// listenAndServe serves handler on --listen until ctx is done, and then
+// waits for the requests in flight. path is logged as the address to open.
+func (s *Scraper) listenAndServe(ctx context.Context, handler http.Handler, path string) error {
+	ln, err := net.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	slog.Info("serving", "url", "http://"+ln.Addr().String()+path)
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
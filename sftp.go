@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultSSHKeys are the private keys below ~/.ssh that are tried when
+// neither --sftp-key nor an SSH agent is available.
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// sftpStorage stores files in a directory of a remote host over SFTP.
+// All workers share one SSH connection, which is opened with the first
+// request and opened again after it broke.
+type sftpStorage struct {
+	addr        string
+	config      *ssh.ClientConfig
+	root        string
+	dialTimeout time.Duration
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// newSFTPStorage returns the storage of an sftp://user@host:port/path
+// target. The host key must be listed in --sftp-known-hosts. The user
+// authenticates with the password of the URL, the key of --sftp-key, the
+// keys of the SSH agent or the default keys below ~/.ssh.
+func newSFTPStorage(cfg Config, target *url.URL) (*sftpStorage, error) {
+	knownHosts := cfg.SFTPKnownHosts
+	home, _ := os.UserHomeDir()
+	if knownHosts == "" {
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("sftp-known-hosts: %w", err)
+	}
+
+	user := target.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	var auth []ssh.AuthMethod
+	if password, ok := target.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+	keys := []string{cfg.SFTPKey}
+	if cfg.SFTPKey == "" {
+		keys = nil
+		if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+			if conn, err := net.Dial("unix", socket); err == nil {
+				auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			}
+		}
+		for _, name := range defaultSSHKeys {
+			keys = append(keys, filepath.Join(home, ".ssh", name))
+		}
+	}
+	var signers []ssh.Signer
+	for _, keyFile := range keys {
+		data, err := os.ReadFile(keyFile)
+		if errors.Is(err, os.ErrNotExist) && cfg.SFTPKey == "" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sftp-key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			if cfg.SFTPKey != "" {
+				return nil, fmt.Errorf("sftp-key %s: %w", keyFile, err)
+			}
+			// Default keys protected by a passphrase are left to the agent.
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("output: no password, SSH agent or key to log in to %s with", target.Host)
+	}
+
+	addr := target.Host
+	if target.Port() == "" {
+		addr = net.JoinHostPort(target.Hostname(), "22")
+	}
+	root := target.Path
+	if root == "" {
+		root = "."
+	}
+	return &sftpStorage{
+		addr:        addr,
+		config:      &ssh.ClientConfig{User: user, Auth: auth, HostKeyCallback: hostKeys},
+		root:        root,
+		dialTimeout: time.Duration(cfg.DialTimeout),
+	}, nil
+}
+
+// connect returns the SFTP session, connecting first if there is none.
+func (s *sftpStorage) connect(ctx context.Context) (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	dialer := net.Dialer{Timeout: s.dialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(netConn, s.addr, s.config)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("ssh %s: %w", s.addr, err)
+	}
+	s.conn = ssh.NewClient(conn, chans, reqs)
+	s.client, err = sftp.NewClient(s.conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return nil, fmt.Errorf("sftp %s: %w", s.addr, err)
+	}
+	return s.client, nil
+}
+
+// disconnect drops client after it failed, so that the next request
+// connects again.
+func (s *sftpStorage) disconnect(client *sftp.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != client {
+		return
+	}
+	s.client.Close()
+	s.conn.Close()
+	s.client, s.conn = nil, nil
+}
+
+// Put writes r to name+".tmp" on the host and renames it once it is
+// complete. SFTP has no object metadata, so meta is not stored.
+func (s *sftpStorage) Put(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error {
+	return s.run(ctx, func(client *sftp.Client) error {
+		return s.write(ctx, client, path.Join(s.root, name), section(r, 0, size))
+	})
+}
+
+// run calls fn with the SFTP session, and drops the connection if fn
+// found it broken.
+func (s *sftpStorage) run(ctx context.Context, fn func(*sftp.Client) error) error {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(client)
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) {
+		s.disconnect(client)
+	}
+	return err
+}
+
+func (s *sftpStorage) write(ctx context.Context, client *sftp.Client, target string, r io.Reader) error {
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("create %s: %w", path.Dir(target), err)
+	}
+	tmpName := target + tmpSuffix
+	remote, err := client.Create(tmpName)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tmpName, err)
+	}
+	if _, err := remote.ReadFrom(contextReader{ctx, r}); err != nil {
+		remote.Close()
+		client.Remove(tmpName)
+		return fmt.Errorf("write %s: %w", tmpName, err)
+	}
+	if err := remote.Close(); err != nil {
+		client.Remove(tmpName)
+		return fmt.Errorf("write %s: %w", tmpName, err)
+	}
+	if err := client.PosixRename(tmpName, target); err != nil {
+		// Servers without the posix-rename extension do not replace an
+		// existing file.
+		client.Remove(target)
+		if err := client.Rename(tmpName, target); err != nil {
+			return fmt.Errorf("rename %s: %w", tmpName, err)
+		}
+	}
+	return nil
+}
+
+func (s *sftpStorage) Exists(ctx context.Context, name string) (bool, error) {
+	return exists(s.Stat(ctx, name))
+}
+
+func (s *sftpStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	var info FileInfo
+	err := s.run(ctx, func(client *sftp.Client) error {
+		stat, err := client.Stat(path.Join(s.root, name))
+		if err != nil {
+			return err
+		}
+		info = FileInfo{Name: name, Size: stat.Size(), Modified: stat.ModTime()}
+		return nil
+	})
+	return info, err
+}
+
+func (s *sftpStorage) Delete(ctx context.Context, name string) error {
+	return s.run(ctx, func(client *sftp.Client) error {
+		err := client.Remove(path.Join(s.root, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	})
+}
+
+// List walks the remote directory the prefix points into, skipping the
+// files that are still being written.
+func (s *sftpStorage) List(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	return s.run(ctx, func(client *sftp.Client) error {
+		dir := s.root
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			dir = path.Join(s.root, prefix[:i])
+		}
+		walker := client.Walk(dir)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				if errors.Is(err, fs.ErrNotExist) && walker.Path() == dir {
+					return nil
+				}
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			stat := walker.Stat()
+			if stat.IsDir() || strings.HasSuffix(walker.Path(), tmpSuffix) {
+				continue
+			}
+			name := walker.Path()
+			if s.root != "." {
+				name = strings.TrimPrefix(strings.TrimPrefix(name, s.root), "/")
+			}
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if err := fn(FileInfo{Name: name, Size: stat.Size(), Modified: stat.ModTime()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// contextReader is an io.Reader that fails once ctx is done, to stop
+// transfers that have no context of their own.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is how long an interrupted run may take to abort its
+// requests and write the run report before the process exits anyway.
+const shutdownGrace = 10 * time.Second
+
+// interruptContext returns a context that is cancelled on the first SIGINT
+// or SIGTERM. The process exits right away on a second signal, or once
+// shutdownGrace has passed since the first one. stop releases the signal
+// handler.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("interrupted, stopping the run", "signal", sig.String(), "grace", shutdownGrace)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			slog.Error("interrupted again, exiting")
+		case <-time.After(shutdownGrace):
+			slog.Error("run did not stop within the grace period, exiting")
+		case <-done:
+			return
+		}
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
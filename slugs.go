@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// readSlugList reads slugs from path, one per line. Blank lines and
+// everything after a '#' are ignored. A path of "-" reads standard input.
+func readSlugList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var slugs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if slug := strings.TrimSpace(line); slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return slugs, nil
+}
+
+// slugSet is a set of plugin slugs.
+type slugSet map[string]bool
+
+// readSlugSet reads the slugs listed in path into a set.
+func readSlugSet(path string) (slugSet, error) {
+	slugs, err := readSlugList(path)
+	if err != nil {
+		return nil, err
+	}
+	set := make(slugSet, len(slugs))
+	for _, slug := range slugs {
+		set[slug] = true
+	}
+	return set, nil
+}
+
+// listed reports whether slug passes the allowlist and blocklist. The
+// blocklist always wins, and an allowlist admits only its own entries.
+func (s *Scraper) listed(slug string) bool {
+	if s.block[slug] {
+		return false
+	}
+	return s.allow == nil || s.allow[slug]
+}
+
+// requestedSlugs returns the slugs named by --slug and --slugs-file, in
+// order and without duplicates.
+func (s *Scraper) requestedSlugs() ([]string, error) {
+	var slugs []string
+	if s.cfg.Slug != "" {
+		slugs = append(slugs, s.cfg.Slug)
+	}
+	if s.cfg.SlugsFile != "" {
+		fromFile, err := readSlugList(s.cfg.SlugsFile)
+		if err != nil {
+			return nil, fmt.Errorf("slugs file: %w", err)
+		}
+		slugs = append(slugs, fromFile...)
+	}
+
+	seen := make(map[string]bool, len(slugs))
+	unique := slugs[:0]
+	for _, slug := range slugs {
+		if !seen[slug] {
+			seen[slug] = true
+			unique = append(unique, slug)
+		}
+	}
+	return unique, nil
+}
+
+// resolveSlugs looks up every slug with the plugin_information action and
+// calls fn for each one found. Slugs that cannot be resolved are reported
+// and skipped.
+func (s *Scraper) resolveSlugs(ctx context.Context, slugs []string, fn func(Plugin) error) error {
+	var failed int
+	for _, slug := range slugs {
+		plugin, err := s.fetchPluginInfo(ctx, slug)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			failed++
+			slog.Error("failed to resolve slug", "slug", slug, "error", err)
+			continue
+		}
+		if err := fn(plugin); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return partialFailure(fmt.Errorf("%d of %d slugs could not be resolved", failed, len(slugs)))
+	}
+	return nil
+}
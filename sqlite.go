@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations bring the schema of a SQLite database written by
+// --export sqlite=FILE up to date, in order, like postgresMigrations, with
+// which they are kept in step. The number of migrations applied is the
+// user_version of the database. The schema only ever grows, so that queries
+// written against it keep working.
+var sqliteMigrations = []string{
+	// 1: the tables of the metadata database:
+	//
+	//   - runs has a row per download run, with its kind and when it
+	//     started and finished.
+	//   - plugins has the latest metadata of every plugin or theme by kind
+	//     and slug, with the full API response in metadata.
+	//   - versions lists every release the API named, with its download
+	//     URL.
+	//   - downloads has a row per plugin and run with the outcome and the
+	//     stored archive.
+	`
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	kind TEXT NOT NULL,
+	started TEXT NOT NULL,
+	finished TEXT
+);
+CREATE TABLE IF NOT EXISTS plugins (
+	kind TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	name TEXT NOT NULL,
+	version TEXT NOT NULL,
+	author TEXT NOT NULL,
+	active_installs INTEGER NOT NULL,
+	downloaded INTEGER NOT NULL,
+	rating INTEGER NOT NULL,
+	num_ratings INTEGER NOT NULL,
+	requires TEXT NOT NULL,
+	tested TEXT NOT NULL,
+	requires_php TEXT NOT NULL,
+	last_updated TEXT,
+	added TEXT,
+	metadata TEXT NOT NULL,
+	seen TEXT NOT NULL,
+	PRIMARY KEY (kind, slug)
+);
+CREATE TABLE IF NOT EXISTS versions (
+	kind TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	version TEXT NOT NULL,
+	download_link TEXT NOT NULL,
+	PRIMARY KEY (kind, slug, version)
+);
+CREATE TABLE IF NOT EXISTS downloads (
+	id INTEGER PRIMARY KEY,
+	run_id INTEGER NOT NULL REFERENCES runs (id),
+	kind TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	version TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	file TEXT NOT NULL,
+	cid TEXT NOT NULL,
+	started TEXT NOT NULL,
+	finished TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS downloads_release ON downloads (kind, slug, version);
+CREATE INDEX IF NOT EXISTS downloads_sha256 ON downloads (sha256);
+`,
+	// 2: the recompressed archive of --repack, and indexes for the latest
+	// download and the most installed plugins.
+	`
+ALTER TABLE downloads ADD COLUMN repacked TEXT NOT NULL DEFAULT '';
+CREATE INDEX downloads_plugin ON downloads (kind, slug, id);
+CREATE INDEX plugins_installs ON plugins (kind, active_installs);
+`,
+}
+
+// newSQLiteExporter opens the database of --export sqlite=FILE, which
+// successive runs add to.
+func newSQLiteExporter(s *Scraper, fileName string) (exporter, error) {
+	db, err := openSQLite(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLExporter(db, sqliteDialect, s.dir.name)
+}
+
+// openSQLite opens the metadata database in fileName, creating or migrating
+// its schema as needed.
+func openSQLite(fileName string) (*sql.DB, error) {
+	// A busy timeout lets tools read the database during the run, and
+	// immediate transactions take the write lock up front, so that two
+	// scrapers opening the database take turns at migrating it.
+	db, err := sql.Open("sqlite", "file:"+fileName+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer.
+	db.SetMaxOpenConns(1)
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrateSQLite applies the migrations the database lacks, each in its own
+// transaction together with the new user_version.
+func migrateSQLite(db *sql.DB) error {
+	for {
+		done, err := migrateSQLiteStep(db)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// migrateSQLiteStep applies the next migration the database lacks, and
+// reports whether it had them all.
+func migrateSQLiteStep(db *sql.DB) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return false, err
+	}
+	if version > len(sqliteMigrations) {
+		return false, fmt.Errorf("database has schema version %d, this build knows %d", version, len(sqliteMigrations))
+	}
+	if version == len(sqliteMigrations) {
+		return true, nil
+	}
+	_, err = tx.Exec(sqliteMigrations[version])
+	if err == nil {
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return false, fmt.Errorf("migrate schema to version %d: %w", version+1, err)
+	}
+	if version > 0 {
+		slog.Info("migrated metadata database", "version", version+1)
+	}
+	return false, nil
+}
+
+// sqliteDialect stores timestamps as RFC 3339 text in UTC, which sorts and
+// compares in time order.
+var sqliteDialect = sqlDialect{
+	bind: func(query string) string { return query },
+	time: func(t time.Time) any { return t.UTC().Format(time.RFC3339) },
+}
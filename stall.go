@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// minSpeedWindow is the period over which --min-speed is measured.
+const minSpeedWindow = 30 * time.Second
+
+// errStalled reports a transfer that was aborted by --stall-timeout or
+// --min-speed. Such transfers are worth retrying.
+var errStalled = errors.New("transfer stalled")
+
+// countingReader counts the bytes read through it, and the time the
+// transfer spent waiting for its destination, see waitFor.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+	// waited is the total of the finished waits and waiting the start of
+	// the one under way, if any, in nanoseconds.
+	waited  atomic.Int64
+	waiting atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// active returns the time since start that the transfer did not wait for
+// its destination.
+func (c *countingReader) active(start, now time.Time) time.Duration {
+	d := now.Sub(start) - time.Duration(c.waited.Load())
+	if since := c.waiting.Load(); since != 0 {
+		d -= now.Sub(time.Unix(0, since))
+	}
+	return d
+}
+
+// waitingWriter reports the time its writes take to a countingReader.
+type waitingWriter struct {
+	w io.Writer
+	c *countingReader
+}
+
+func (w *waitingWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	w.c.waiting.Store(start.UnixNano())
+	n, err := w.w.Write(p)
+	w.c.waited.Add(int64(time.Since(start)))
+	w.c.waiting.Store(0)
+	return n, err
+}
+
+// waitFor wraps the destination w of the transfer read through src, as
+// returned by watchTransfer, so that the watchdog does not count the time
+// its writes block: a destination slower than the server, such as an
+// upload that the archive is streamed to, makes the transfer neither
+// stalled nor slow.
+func waitFor(src io.Reader, w io.Writer) io.Writer {
+	if c, ok := src.(*countingReader); ok {
+		return &waitingWriter{w: w, c: c}
+	}
+	return w
+}
+
+// watchTransfer returns a reader for body that aborts the transfer through
+// cancel when no data arrives for --stall-timeout or the throughput stays
+// below --min-speed for minSpeedWindow. Only the time the transfer is not
+// waiting for its destination counts. The returned stop function must be
+// called once the transfer is done.
+func (s *Scraper) watchTransfer(body io.Reader, cancel context.CancelCauseFunc) (io.Reader, func()) {
+	stallTimeout := time.Duration(s.cfg.StallTimeout)
+	minSpeed := int64(s.cfg.MinSpeed)
+	if stallTimeout <= 0 && minSpeed <= 0 {
+		return body, func() {}
+	}
+
+	tick := time.Second
+	if stallTimeout > 0 && stallTimeout/4 < tick {
+		tick = stallTimeout / 4
+	}
+	// samples holds the byte count and active time of the ticks of the
+	// last window.
+	type sample struct {
+		n  int64
+		at time.Duration
+	}
+	samples := make([]sample, 0, int(minSpeedWindow/tick)+1)
+
+	counter := &countingReader{r: body}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		start := time.Now()
+		var last int64
+		var lastProgress time.Duration
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				at := counter.active(start, now)
+				n := counter.n.Load()
+				if n != last {
+					last, lastProgress = n, at
+				}
+				if stallTimeout > 0 && at-lastProgress >= stallTimeout {
+					cancel(fmt.Errorf("%w: no data for %s", errStalled, stallTimeout))
+					return
+				}
+
+				samples = append(samples, sample{n, at})
+				for len(samples) > 1 && at-samples[1].at >= minSpeedWindow {
+					samples = samples[1:]
+				}
+				if minSpeed <= 0 || at-samples[0].at < minSpeedWindow {
+					continue
+				}
+				if rate := (n - samples[0].n) * int64(time.Second) / int64(at-samples[0].at); rate < minSpeed {
+					cancel(fmt.Errorf("%w: %d bytes/s is below min-speed %s/s", errStalled, rate, s.cfg.MinSpeed))
+					return
+				}
+			}
+		}
+	}()
+	return counter, func() { close(done) }
+}
+
+// transferError returns the reason the watchdog cancelled ctx with in place
+// of err, so that aborted transfers report why they were aborted.
+func transferError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); cause != nil {
+		return cause
+	}
+	return err
+}
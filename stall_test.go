@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// slowIO sleeps for delay before every read or write.
+type slowIO struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s slowIO) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.r.Read(p)
+}
+
+func (s slowIO) Write(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return len(p), nil
+}
+
+func TestWatchTransferWaits(t *testing.T) {
+	const stall = 100 * time.Millisecond
+	tests := []struct {
+		name    string
+		src     func(io.Reader) io.Reader
+		dst     io.Writer
+		stalled bool
+	}{
+		{"slow server", func(r io.Reader) io.Reader { return slowIO{r: r, delay: 3 * stall} }, io.Discard, true},
+		{"slow destination", func(r io.Reader) io.Reader { return r }, slowIO{delay: 3 * stall}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scraper{cfg: Config{StallTimeout: Duration(stall)}}
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			src, stop := s.watchTransfer(tt.src(bytes.NewReader(make([]byte, 2))), cancel)
+			defer stop()
+			buf := make([]byte, 1)
+			if _, err := io.CopyBuffer(struct{ io.Writer }{waitFor(src, tt.dst)}, struct{ io.Reader }{src}, buf); err != nil {
+				t.Fatal(err)
+			}
+			if stalled := errors.Is(context.Cause(ctx), errStalled); stalled != tt.stalled {
+				t.Fatalf("stalled = %v (%v), want %v", stalled, context.Cause(ctx), tt.stalled)
+			}
+		})
+	}
+}
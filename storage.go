@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Storage is where the files of a run are kept: the output directory by
+// default, or the target of --output. Names are slash-separated paths
+// relative to the root of the storage, such as akismet/akismet.5.3.zip.
+type Storage interface {
+	// Put stores the size bytes read from r as name, with the given object
+	// metadata where the storage supports it. A file that is only partly
+	// written is never visible as name. Storages send parts of r again
+	// after a failed request if r is an io.ReaderAt, such as an *os.File.
+	Put(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error
+	// Exists reports whether name is stored.
+	Exists(ctx context.Context, name string) (bool, error)
+	// Stat describes the stored file name, or returns an error wrapping
+	// fs.ErrNotExist if there is none.
+	Stat(ctx context.Context, name string) (FileInfo, error)
+	// Delete removes name. Removing a file that does not exist is not an
+	// error.
+	Delete(ctx context.Context, name string) error
+	// List calls fn for every stored file whose name starts with prefix,
+	// in no particular order, and stops at the first error of fn.
+	List(ctx context.Context, prefix string, fn func(FileInfo) error) error
+}
+
+// FileInfo describes a stored file.
+type FileInfo struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// newStorage returns the storage of --output, or the output directory
+// without one.
+func newStorage(cfg Config, client *http.Client) (Storage, error) {
+	if cfg.Output == "" {
+		return newLocalStorage(cfg.OutputDir), nil
+	}
+	target, err := url.Parse(cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("output: %w", err)
+	}
+	switch target.Scheme {
+	case "file":
+		return newLocalStorage(filepath.FromSlash(target.Path)), nil
+	case "tar":
+		return newTarStorage(target), nil
+	case "s3":
+		return newS3Storage(cfg, client, target)
+	case "gs":
+		return newGCSStorage(context.Background(), cfg, client, target)
+	case "azure":
+		return newAzureStorage(cfg, client, target)
+	case "sftp":
+		return newSFTPStorage(cfg, target)
+	case "webdav", "webdav+http":
+		return newWebDAVStorage(cfg, client, target)
+	}
+	return nil, fmt.Errorf("output: unsupported scheme %q", target.Scheme)
+}
+
+// closeStorage finishes a storage that is written as a stream.
+func (s *Scraper) closeStorage() error {
+	if closer, ok := s.storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// storageName returns the name fileName of the output directory has in
+// the storage, which is its path relative to the output directory.
+func (s *Scraper) storageName(fileName string) (string, error) {
+	rel, err := filepath.Rel(s.cfg.OutputDir, fileName)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s is not below the output directory %s", fileName, s.cfg.OutputDir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// stat describes the stored file that fileName of the output directory
+// becomes once it is published.
+func (s *Scraper) stat(ctx context.Context, fileName string) (FileInfo, error) {
+	name, err := s.storageName(fileName)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return s.storage.Stat(ctx, name)
+}
+
+// publish stores fileName of the output directory with the object metadata
+// meta, retried like a download, and removes the local copy unless keep is
+// set. Without --output the output directory is the storage, and the file
+// is already in place.
+func (s *Scraper) publish(ctx context.Context, fileName string, keep bool, meta map[string]string) error {
+	if s.cfg.Output == "" {
+		return nil
+	}
+	name, err := s.storageName(fileName)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = s.putFile(ctx, name, fileName, meta)
+		if err == nil || attempt >= s.cfg.DownloadRetries || !retryable(err) {
+			break
+		}
+		delay := s.backoff(attempt)
+		slog.Warn("upload failed", "file", name, "attempt", attempt, "attempts", s.cfg.DownloadRetries,
+			"retry_in", delay, "error", err)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", name, err)
+	}
+	slog.Debug("uploaded file", "file", name)
+	if keep {
+		return nil
+	}
+	return os.Remove(fileName)
+}
+
+// putFile stores the local file fileName as name.
+func (s *Scraper) putFile(ctx context.Context, name, fileName string, meta map[string]string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.storage.Put(ctx, name, file, info.Size(), meta)
+}
+
+// outputSchemes are the targets --output supports.
+var outputSchemes = []string{"file", "tar", "s3", "gs", "azure", "sftp", "webdav", "webdav+http"}
+
+// validateOutput checks that target is a URL --output supports, other than
+// the output directory outputDir it stages files in.
+func validateOutput(target, outputDir string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("output: %w", err)
+	}
+	if !slices.Contains(outputSchemes, u.Scheme) {
+		return fmt.Errorf("output must be a URL with one of the schemes %s, got %q", strings.Join(outputSchemes, ", "), u.Redacted())
+	}
+	if u.Scheme == "tar" {
+		if (u.Host != "-" || u.Path != "") && (u.Host != "" || u.Path == "") {
+			return fmt.Errorf("output: %q is neither tar://- nor a tar:///path URL", u.Redacted())
+		}
+		return nil
+	}
+	if u.Scheme == "file" {
+		if u.Host != "" || u.Path == "" {
+			return fmt.Errorf("output: %q is not a file:///path URL", u.Redacted())
+		}
+		dir, err := filepath.Abs(outputDir)
+		if err == nil && filepath.Clean(filepath.FromSlash(u.Path)) == dir {
+			return fmt.Errorf("output: %q is the output directory, leave out output to keep the files there", u.Redacted())
+		}
+		return nil
+	}
+	if u.Host == "" {
+		return fmt.Errorf("output: %q names no bucket or host", u.Redacted())
+	}
+	if u.Scheme == "azure" && strings.Trim(u.Path, "/") == "" {
+		return fmt.Errorf("output: %q names no container, use azure://account/container/prefix", u.Redacted())
+	}
+	return nil
+}
+
+// releaseMeta returns the object metadata of the files of a plugin release
+// whose archive has the hex encoded SHA-256 sum, if known.
+func releaseMeta(plugin Plugin, sum string) map[string]string {
+	meta := map[string]string{"slug": plugin.Slug, "version": plugin.Version}
+	if sum != "" {
+		meta["sha256"] = sum
+	}
+	return meta
+}
+
+// section returns the n bytes of r at offset: a section that can be read
+// again if r is an io.ReaderAt, and otherwise the next n bytes of the
+// stream, whose offset is then up to the caller.
+func section(r io.Reader, offset, n int64) io.Reader {
+	if at, ok := r.(io.ReaderAt); ok {
+		return io.NewSectionReader(at, offset, n)
+	}
+	return io.LimitReader(r, n)
+}
+
+// setBody sets the size bytes of body as the content of req. A body that
+// is an io.ReadSeeker, such as a section of a file or a buffer, is read
+// again when the request is retried or redirected.
+func setBody(req *http.Request, body io.Reader, size int64) {
+	if body == nil {
+		return
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+		return
+	}
+	req.Body = io.NopCloser(body)
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(seeker), nil
+		}
+	}
+}
+
+// exists is Storage.Exists on top of the result of Storage.Stat.
+func exists(_ FileInfo, err error) (bool, error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// contentType returns the media type of a file named name.
+func contentType(name string) string {
+	if mediaType := mime.TypeByExtension(path.Ext(name)); mediaType != "" {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// xmlError returns the error of a failed request to S3 or Azure, including
+// the code and message of the XML error document they send in the body.
+// The query is left out of the URL, because it may hold a SAS token.
+// Files that do not exist are reported as fs.ErrNotExist.
+func xmlError(resp *http.Response) error {
+	where := *resp.Request.URL
+	where.RawQuery = ""
+	method := resp.Request.Method
+	if resp.StatusCode == http.StatusNotFound && (method == http.MethodHead || method == http.MethodDelete) {
+		return fmt.Errorf("%s %s: %w", method, where.Redacted(), fs.ErrNotExist)
+	}
+	status := &statusError{code: resp.StatusCode, status: resp.Status}
+	var doc struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &doc) != nil || doc.Code == "" {
+		return fmt.Errorf("%s %s: %w", method, where.Redacted(), status)
+	}
+	return fmt.Errorf("%s %s: %w: %s: %s", method, where.Redacted(), status, doc.Code, doc.Message)
+}
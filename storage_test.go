@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoragePutIsAtomic(t *testing.T) {
+	broken := errors.New("connection reset")
+	tests := []struct {
+		name    string
+		r       io.Reader
+		size    int64
+		wantErr bool
+	}{
+		{"complete", strings.NewReader("archive"), 7, false},
+		{"failed read", io.MultiReader(strings.NewReader("arch"), iotestErr{broken}), 7, true},
+		{"short read", strings.NewReader("arch"), 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			l := newLocalStorage(root)
+			err := l.Put(context.Background(), "akismet/akismet.5.3.1.zip", tt.r, tt.size, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Put() = %v, want error %v", err, tt.wantErr)
+			}
+
+			var files []string
+			filepath.WalkDir(root, func(fileName string, entry os.DirEntry, err error) error {
+				if err == nil && !entry.IsDir() {
+					files = append(files, filepath.Base(fileName))
+				}
+				return nil
+			})
+			if tt.wantErr {
+				if len(files) > 0 {
+					t.Fatalf("failed Put() left %v behind", files)
+				}
+				return
+			}
+			data, err := os.ReadFile(filepath.Join(root, "akismet", "akismet.5.3.1.zip"))
+			if err != nil || !bytes.Equal(data, []byte("archive")) || len(files) != 1 {
+				t.Fatalf("stored %q (%v), files %v", data, err, files)
+			}
+			if info, err := l.Stat(context.Background(), "akismet/akismet.5.3.1.zip"); err != nil || info.Size != 7 {
+				t.Fatalf("Stat() = %+v, %v", info, err)
+			}
+		})
+	}
+}
+
+// iotestErr fails every read with err.
+type iotestErr struct{ err error }
+
+func (r iotestErr) Read([]byte) (int, error) { return 0, r.err }
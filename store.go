@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// storeFormats open the metadata databases --metadata-store can name, which
+// are those of the sqlite and postgres exports.
+var storeFormats = map[string]struct {
+	open    func(target string) (*sql.DB, error)
+	dialect sqlDialect
+}{
+	"sqlite":   {openSQLite, sqliteDialect},
+	"postgres": {openPostgres, postgresDialect},
+}
+
+// metadataStoreSpec returns --metadata-store, or else the first sqlite or
+// postgres entry of --export, so that a configuration file that exports to
+// a database serves it too.
+func (c Config) metadataStoreSpec() string {
+	if c.MetadataStore != "" {
+		return c.MetadataStore
+	}
+	for _, spec := range c.Export {
+		format, _, _ := strings.Cut(spec, "=")
+		if _, ok := storeFormats[format]; ok {
+			return spec
+		}
+	}
+	return ""
+}
+
+// metadataStore reads the metadata database the sqlite and postgres
+// exports write, for the commands that query the corpus.
+type metadataStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (s *Scraper) openMetadataStore() (*metadataStore, error) {
+	spec := s.cfg.metadataStoreSpec()
+	if spec == "" {
+		return nil, errors.New("no metadata store, name one with --metadata-store sqlite=FILE or postgres=URL")
+	}
+	format, target, _ := strings.Cut(spec, "=")
+	// Opening a SQLite database creates it, which a mistyped name should not.
+	if format == "sqlite" {
+		if _, err := os.Stat(target); err != nil {
+			return nil, fmt.Errorf("metadata store: %w", err)
+		}
+	}
+	f := storeFormats[format]
+	db, err := f.open(target)
+	if err != nil {
+		return nil, fmt.Errorf("metadata store %s: %w", redactExport(spec), err)
+	}
+	return &metadataStore{db: db, dialect: f.dialect}, nil
+}
+
+func (m *metadataStore) close() error {
+	return m.db.Close()
+}
+
+// sqlTime scans a timestamp column, which SQLite stores as RFC 3339 text
+// and PostgreSQL as TIMESTAMPTZ. Valid is false for NULL.
+type sqlTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (t *sqlTime) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*t = sqlTime{}
+		return nil
+	case time.Time:
+		t.Time = v
+	case string:
+		t.Time, err = time.Parse(time.RFC3339, v)
+	case []byte:
+		t.Time, err = time.Parse(time.RFC3339, string(v))
+	default:
+		err = fmt.Errorf("cannot scan %T into a timestamp", src)
+	}
+	t.Valid = err == nil
+	return err
+}
+
+// ptr returns the time, or nil for NULL.
+func (t sqlTime) ptr() *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// storedPlugin is a row of plugins, with the outcome of the latest download
+// of the plugin.
+type storedPlugin struct {
+	Kind           string
+	Slug           string
+	Name           string
+	Version        string
+	Author         string
+	ActiveInstalls int64
+	Downloaded     int64
+	Rating         int32
+	NumRatings     int32
+	Requires       string
+	Tested         string
+	RequiresPHP    string
+	LastUpdated    *time.Time
+	Added          *time.Time
+	Metadata       string
+	Seen           time.Time
+	Outcome        string
+}
+
+// storedVersion is a row of versions.
+type storedVersion struct {
+	Kind         string
+	Slug         string
+	Version      string
+	DownloadLink string
+}
+
+// storedDownload is a row of downloads.
+type storedDownload struct {
+	ID       int64
+	RunID    int64
+	Kind     string
+	Slug     string
+	Version  string
+	Outcome  string
+	Reason   string
+	Size     int64
+	SHA256   string
+	File     string
+	CID      string
+	Repacked string
+	Started  time.Time
+	Finished time.Time
+}
+
+// storedRun is a row of runs. Finished is nil for runs that are still going
+// or were killed.
+type storedRun struct {
+	ID       int64
+	Kind     string
+	Started  time.Time
+	Finished *time.Time
+}
+
+// pluginFilter selects plugins of a kind, best installed first. Query
+// matches slugs and names case-insensitively, and Outcome the outcome of
+// the latest download.
+type pluginFilter struct {
+	Kind        string
+	Query       string
+	Outcome     string
+	MinInstalls int64
+	Limit       int
+	Offset      int
+}
+
+// downloadFilter selects downloads, latest first. Empty fields match
+// everything.
+type downloadFilter struct {
+	Kind    string
+	Slug    string
+	Version string
+	Outcome string
+	RunID   int64
+	Limit   int
+	Offset  int
+}
+
+// sqlWhere collects the conditions and arguments of a query.
+type sqlWhere struct {
+	conds []string
+	args  []any
+}
+
+func (w *sqlWhere) add(cond string, args ...any) {
+	w.conds = append(w.conds, cond)
+	w.args = append(w.args, args...)
+}
+
+func (w *sqlWhere) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// latestOutcome is the outcome of the latest download of plugin p.
+// latestOutcome leaves out the releases removed by prune, which say
+// nothing about the plugin.
+const latestOutcome = "COALESCE((SELECT d.outcome FROM downloads d WHERE d.kind = p.kind AND d.slug = p.slug AND d.outcome <> 'pruned' ORDER BY d.id DESC LIMIT 1), '')"
+
+const pluginColumns = `p.kind, p.slug, p.name, p.version, p.author, p.active_installs, p.downloaded, p.rating, p.num_ratings,
+	p.requires, p.tested, p.requires_php, p.last_updated, p.added, p.metadata, p.seen, ` + latestOutcome
+
+func scanPlugin(row interface{ Scan(...any) error }) (storedPlugin, error) {
+	var p storedPlugin
+	var lastUpdated, added, seen sqlTime
+	err := row.Scan(&p.Kind, &p.Slug, &p.Name, &p.Version, &p.Author, &p.ActiveInstalls, &p.Downloaded, &p.Rating,
+		&p.NumRatings, &p.Requires, &p.Tested, &p.RequiresPHP, &lastUpdated, &added, &p.Metadata, &seen, &p.Outcome)
+	p.LastUpdated, p.Added, p.Seen = lastUpdated.ptr(), added.ptr(), seen.Time
+	return p, err
+}
+
+// plugin returns the plugin of kind with slug, or nil if the store has none.
+func (m *metadataStore) plugin(ctx context.Context, kind, slug string) (*storedPlugin, error) {
+	row := m.db.QueryRowContext(ctx, m.dialect.bind("SELECT "+pluginColumns+" FROM plugins p WHERE p.kind = ? AND p.slug = ?"), kind, slug)
+	p, err := scanPlugin(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// plugins returns the plugins f selects and how many there are in all.
+func (m *metadataStore) plugins(ctx context.Context, f pluginFilter) ([]storedPlugin, int, error) {
+	var w sqlWhere
+	w.add("p.kind = ?", f.Kind)
+	if f.Query != "" {
+		pattern := "%" + strings.ToLower(f.Query) + "%"
+		w.add("(lower(p.slug) LIKE ? OR lower(p.name) LIKE ?)", pattern, pattern)
+	}
+	if f.Outcome != "" {
+		w.add(latestOutcome+" = ?", f.Outcome)
+	}
+	if f.MinInstalls > 0 {
+		w.add("p.active_installs >= ?", f.MinInstalls)
+	}
+	var total int
+	if err := m.db.QueryRowContext(ctx, m.dialect.bind("SELECT count(*) FROM plugins p"+w.String()), w.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := m.db.QueryContext(ctx, m.dialect.bind("SELECT "+pluginColumns+" FROM plugins p"+w.String()+
+		" ORDER BY p.active_installs DESC, p.slug LIMIT ? OFFSET ?"), append(w.args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var plugins []storedPlugin
+	for rows.Next() {
+		p, err := scanPlugin(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, total, rows.Err()
+}
+
+// versions returns the releases of the plugin of kind with slug. They are
+// sorted as text, since version numbers do not follow a single scheme.
+func (m *metadataStore) versions(ctx context.Context, kind, slug string) ([]storedVersion, error) {
+	rows, err := m.db.QueryContext(ctx, m.dialect.bind("SELECT kind, slug, version, download_link FROM versions WHERE kind = ? AND slug = ? ORDER BY version"), kind, slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var versions []storedVersion
+	for rows.Next() {
+		var v storedVersion
+		if err := rows.Scan(&v.Kind, &v.Slug, &v.Version, &v.DownloadLink); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// downloads returns the downloads f selects.
+func (m *metadataStore) downloads(ctx context.Context, f downloadFilter) ([]storedDownload, error) {
+	var w sqlWhere
+	for _, c := range []struct{ column, value string }{{"kind", f.Kind}, {"slug", f.Slug}, {"version", f.Version}, {"outcome", f.Outcome}} {
+		if c.value != "" {
+			w.add(c.column+" = ?", c.value)
+		}
+	}
+	if f.RunID != 0 {
+		w.add("run_id = ?", f.RunID)
+	}
+	rows, err := m.db.QueryContext(ctx, m.dialect.bind(`SELECT id, run_id, kind, slug, version, outcome, reason, size, sha256, file, cid, repacked,
+	started, finished
+FROM downloads`+w.String()+" ORDER BY id DESC LIMIT ? OFFSET ?"), append(w.args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var downloads []storedDownload
+	for rows.Next() {
+		var d storedDownload
+		var started, finished sqlTime
+		err := rows.Scan(&d.ID, &d.RunID, &d.Kind, &d.Slug, &d.Version, &d.Outcome, &d.Reason, &d.Size, &d.SHA256,
+			&d.File, &d.CID, &d.Repacked, &started, &finished)
+		if err != nil {
+			return nil, err
+		}
+		d.Started, d.Finished = started.Time, finished.Time
+		downloads = append(downloads, d)
+	}
+	return downloads, rows.Err()
+}
+
+// runs returns the runs of kind, or of every kind for an empty kind,
+// latest first.
+func (m *metadataStore) runs(ctx context.Context, kind string, limit, offset int) ([]storedRun, error) {
+	var w sqlWhere
+	if kind != "" {
+		w.add("kind = ?", kind)
+	}
+	rows, err := m.db.QueryContext(ctx, m.dialect.bind("SELECT id, kind, started, finished FROM runs"+w.String()+
+		" ORDER BY id DESC LIMIT ? OFFSET ?"), append(w.args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []storedRun
+	for rows.Next() {
+		var r storedRun
+		var started, finished sqlTime
+		if err := rows.Scan(&r.ID, &r.Kind, &started, &finished); err != nil {
+			return nil, err
+		}
+		r.Started, r.Finished = started.Time, finished.ptr()
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// run returns the run with id, or nil if the store has none.
+func (m *metadataStore) run(ctx context.Context, id int64) (*storedRun, error) {
+	var r storedRun
+	var started, finished sqlTime
+	err := m.db.QueryRowContext(ctx, m.dialect.bind("SELECT id, kind, started, finished FROM runs WHERE id = ?"), id).
+		Scan(&r.ID, &r.Kind, &started, &finished)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Started, r.Finished = started.Time, finished.ptr()
+	return &r, nil
+}
+
+// storedRelease is a release whose archive the store says is in the output
+// directory: the latest of its downloads with a file, unless prune removed
+// it since. Stored is when its archive was first stored and Current reports
+// whether it is the current release of the plugin.
+type storedRelease struct {
+	Slug     string
+	Version  string
+	File     string
+	Repacked string
+	SHA256   string
+	Stored   time.Time
+	Current  bool
+}
+
+// storedReleases returns the stored releases of kind, by slug.
+func (m *metadataStore) storedReleases(ctx context.Context, kind string) ([]storedRelease, error) {
+	rows, err := m.db.QueryContext(ctx, m.dialect.bind(`SELECT d.slug, d.version, d.file, d.repacked, d.sha256,
+	(SELECT MIN(e.finished) FROM downloads e WHERE e.kind = d.kind AND e.slug = d.slug AND e.version = d.version AND e.file <> ''),
+	COALESCE(p.version = d.version, FALSE)
+FROM downloads d LEFT JOIN plugins p ON p.kind = d.kind AND p.slug = d.slug
+WHERE d.kind = ? AND d.outcome <> 'pruned' AND d.id = (SELECT MAX(e.id) FROM downloads e
+	WHERE e.kind = d.kind AND e.slug = d.slug AND e.version = d.version AND (e.file <> '' OR e.outcome = 'pruned'))
+ORDER BY d.slug`), kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var releases []storedRelease
+	for rows.Next() {
+		var r storedRelease
+		var stored sqlTime
+		if err := rows.Scan(&r.Slug, &r.Version, &r.File, &r.Repacked, &r.SHA256, &stored, &r.Current); err != nil {
+			return nil, err
+		}
+		r.Stored = stored.Time
+		releases = append(releases, r)
+	}
+	return releases, rows.Err()
+}
+
+// referenced reports whether a release of any kind whose archive has the
+// SHA-256 sum is still stored, that is, was not pruned since.
+func (m *metadataStore) referenced(ctx context.Context, sum string) (bool, error) {
+	var n int
+	err := m.db.QueryRowContext(ctx, m.dialect.bind(`SELECT COUNT(*) FROM downloads d
+WHERE d.sha256 = ? AND d.file <> '' AND NOT EXISTS (SELECT 1 FROM downloads e
+	WHERE e.kind = d.kind AND e.slug = d.slug AND e.version = d.version AND e.outcome = 'pruned' AND e.id > d.id)`), sum).Scan(&n)
+	return n > 0, err
+}
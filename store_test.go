@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreAfterPrune(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "metadata.db")
+	db, err := openSQLite(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != len(sqliteMigrations) {
+		t.Fatalf("user_version = %d (%v), want %d", version, err, len(sqliteMigrations))
+	}
+	e, err := newSQLExporter(db, sqliteDialect, "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := func(c string) string { return strings.Repeat(c, 64) }
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	stored := func(slug, version, c string) MetadataRecord {
+		at = at.Add(time.Hour)
+		return MetadataRecord{Plugin: Plugin{Slug: slug, Version: version}, Outcome: "downloaded", Size: 64,
+			SHA256: sum(c), File: slug + "." + version + ".zip", Started: at, Finished: at}
+	}
+	outcome := func(slug, version, outcome string) MetadataRecord {
+		at = at.Add(time.Hour)
+		return MetadataRecord{Plugin: Plugin{Slug: slug, Version: version}, Outcome: outcome, Started: at, Finished: at}
+	}
+	current := stored("akismet", "5.3.1", "b")
+	current.Plugin.Name = "Akismet Anti-spam"
+	current.Repacked = "akismet.5.3.1.tar.zst"
+	records := []MetadataRecord{
+		stored("akismet", "5.3.0", "a"),
+		current,
+		outcome("akismet", "5.3.0", "pruned"),
+		// jetpack 13.0 has the same archive as the pruned akismet 5.3.0.
+		stored("jetpack", "13.0", "a"),
+		stored("jetpack", "13.1", "c"),
+		outcome("jetpack", "13.1", "failed"),
+		// hello-dolly 1.7.2 was stored again after it was pruned.
+		stored("hello-dolly", "1.7.2", "d"),
+		outcome("hello-dolly", "1.7.2", "pruned"),
+		stored("hello-dolly", "1.7.2", "e"),
+		// classic-editor is pruned for good.
+		stored("classic-editor", "1.6.3", "f"),
+		outcome("classic-editor", "1.6.3", "pruned"),
+	}
+	for _, rec := range records {
+		if err := e.export(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	cfg.MetadataStore = "sqlite=" + fileName
+	s, err := newScraper(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.openMetadataStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+	ctx := context.Background()
+
+	releases, err := m.storedReleases(ctx, "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range releases {
+		if r.Stored.IsZero() {
+			t.Errorf("%s %s has no stored time", r.Slug, r.Version)
+		}
+		got = append(got, strings.Join([]string{r.Slug, r.Version, r.SHA256[:1], r.Repacked}, " "))
+		if r.Current != (r.Slug == "akismet") {
+			t.Errorf("%s %s current = %v", r.Slug, r.Version, r.Current)
+		}
+	}
+	want := []string{"akismet 5.3.1 b akismet.5.3.1.tar.zst", "hello-dolly 1.7.2 e ", "jetpack 13.0 a ", "jetpack 13.1 c "}
+	// The releases of a plugin come in no particular order.
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("storedReleases() = %q, want %q", got, want)
+	}
+	if releases, err := m.storedReleases(ctx, "themes"); err != nil || len(releases) > 0 {
+		t.Fatalf("storedReleases() of themes = %v, %v", releases, err)
+	}
+
+	for c, want := range map[string]bool{"a": true, "b": true, "c": true, "d": false, "e": true, "f": false, "0": false} {
+		if got, err := m.referenced(ctx, sum(c)); err != nil || got != want {
+			t.Errorf("referenced(%s...) = %v, %v, want %v", c, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// stopError carries an error returned by the callback of a streamed page
+// out of the decoder, so that it is neither retried nor wrapped.
+type stopError struct{ err error }
+
+func (e *stopError) Error() string { return e.err.Error() }
+
+// streamPluginList requests a page of the directory and calls fn for each of
+// its plugins while the response is still being decoded, so that large pages
+// are never held in memory as a whole. It returns the page info and the
+// number of plugins on the page. Failed requests are retried like getJSON;
+// plugins passed to fn by an earlier attempt are not passed again.
+func (s *Scraper) streamPluginList(ctx context.Context, pageNumber int, fn func(Plugin) error) (PageInfo, int, error) {
+	rawURL := s.apiURL(s.listQuery(pageNumber))
+	var delivered int
+	var err error
+	for attempt := 1; attempt <= s.cfg.Retries; attempt++ {
+		var info PageInfo
+		var seen int
+		info, err = s.streamOnce(ctx, rawURL, func(plugin Plugin) error {
+			seen++
+			if seen <= delivered {
+				return nil
+			}
+			delivered++
+			if err := fn(plugin); err != nil {
+				return &stopError{err}
+			}
+			return nil
+		})
+		var stop *stopError
+		if errors.As(err, &stop) {
+			return info, seen, stop.err
+		}
+		if err == nil {
+			return info, seen, nil
+		}
+		if errors.Is(err, errNotFound) || ctx.Err() != nil {
+			break
+		}
+		if attempt < s.cfg.Retries {
+			slog.Warn("API request failed", "url", rawURL, "attempt", attempt, "attempts", s.cfg.Retries, "error", err)
+			if err := sleep(ctx, retryDelay); err != nil {
+				return PageInfo{}, 0, err
+			}
+		}
+	}
+	return PageInfo{}, 0, fmt.Errorf("fetch page %d: %w", pageNumber, err)
+}
+
+// errConsumerStopped ends the decoding of a page whose consumer stopped.
+var errConsumerStopped = errors.New("consumer stopped")
+
+// streamOnce requests the page at rawURL and passes its plugins to fn. The
+// body is decoded in its own goroutine into a buffer of up to maxPerPage
+// plugins and closed once it is decoded, so that the connection is not held
+// open while fn waits for the downloads of the page.
+func (s *Scraper) streamOnce(ctx context.Context, rawURL string, fn func(Plugin) error) (PageInfo, error) {
+	resp, err := s.getList(ctx, rawURL)
+	if err != nil {
+		return PageInfo{}, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return PageInfo{}, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return PageInfo{}, s.statusError(resp)
+	}
+
+	plugins := make(chan Plugin, maxPerPage)
+	stopped := make(chan struct{})
+	var info PageInfo
+	var decodeErr error
+	go func() {
+		defer close(plugins)
+		defer resp.Body.Close()
+		info, decodeErr = decodePluginList(resp.Body, func(plugin Plugin) error {
+			select {
+			case plugins <- plugin:
+				return nil
+			case <-stopped:
+				return errConsumerStopped
+			}
+		})
+	}()
+	for plugin := range plugins {
+		if err := fn(plugin); err != nil {
+			close(stopped)
+			for range plugins {
+			}
+			return info, err
+		}
+	}
+	return info, decodeErr
+}
+
+// decodePluginList reads a query_plugins or query_themes response from r
+// token by token and calls fn for every element of its plugins or themes
+// array as soon as it is decoded.
+func decodePluginList(r io.Reader, fn func(Plugin) error) (PageInfo, error) {
+	var info PageInfo
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return info, err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return info, err
+		}
+		switch key, _ := tok.(string); key {
+		case "info":
+			if err := dec.Decode(&info); err != nil {
+				return info, fmt.Errorf("info: %w", err)
+			}
+		case "plugins", "themes":
+			if err := decodeArray(dec, fn); err != nil {
+				return info, fmt.Errorf("%s: %w", key, err)
+			}
+		case "error":
+			// Unknown requests are sometimes reported as {"error": "..."}
+			// with a 200.
+			var msg LooseString
+			if err := dec.Decode(&msg); err != nil {
+				return info, err
+			}
+			if msg != "" {
+				return info, fmt.Errorf("%w: %s", errNotFound, msg)
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return info, err
+			}
+		}
+	}
+	return info, expectDelim(dec, '}')
+}
+
+// decodeArray calls fn for every element of the array at the position of
+// dec. Objects keyed by index contribute their values, and empty values
+// such as false or null stand for an empty array.
+func decodeArray(dec *json.Decoder, fn func(Plugin) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	end := json.Delim(']')
+	if delim == '{' {
+		end = '}'
+	}
+	for dec.More() {
+		if end == '}' {
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+		}
+		var plugin Plugin
+		if err := dec.Decode(&plugin); err != nil {
+			return err
+		}
+		if err := fn(plugin); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, end)
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// svnDir in the output directory holds the working copies of the svn
+// command, below a directory per kind.
+const svnDir = "svn"
+
+// runSVN checks out the Subversion repository of every matching plugin, or
+// updates the working copy of an earlier run, with up to --workers svn
+// processes at a time. Plugins get trunk and tags, which hold the
+// development history and every release, and themes, whose repositories
+// only have a directory per release, the whole repository. A repository
+// that fails is logged and left for the next run.
+func runSVN(ctx context.Context, s *Scraper) error {
+	source := func(fn func(Plugin) error) error {
+		return s.each(ctx, fn)
+	}
+	if s.cfg.DryRun {
+		return dryRun(source, "mirrored")
+	}
+	if s.dir.svnURL == "" {
+		return fmt.Errorf("%s have no Subversion repositories", s.dir.name)
+	}
+	if _, err := exec.LookPath("svn"); err != nil {
+		return fmt.Errorf("svn needs the Subversion client: %w", err)
+	}
+	root := filepath.Join(s.cfg.OutputDir, svnDir, s.dir.name)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+
+	var mirrored, failed atomic.Int64
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(s.cfg.Workers)
+	err := source(func(plugin Plugin) error {
+		if err := groupCtx.Err(); err != nil {
+			return err
+		}
+		group.Go(func() error {
+			if err := s.mirrorSVN(groupCtx, root, plugin.Slug); err != nil {
+				if groupCtx.Err() != nil {
+					return groupCtx.Err()
+				}
+				failed.Add(1)
+				slog.Error("failed to mirror Subversion repository", "slug", plugin.Slug, "error", err)
+				return nil
+			}
+			mirrored.Add(1)
+			slog.Debug("mirrored Subversion repository", "slug", plugin.Slug)
+			return nil
+		})
+		return nil
+	})
+	if werr := group.Wait(); werr != nil {
+		err = werr
+	}
+	slog.Info("mirrored Subversion repositories", "mirrored", mirrored.Load(), "failed", failed.Load())
+	if err != nil && !isPartial(err) {
+		return err
+	}
+	if n := failed.Load(); n > 0 {
+		return partialFailure(fmt.Errorf("%d of %d Subversion repositories failed", n, n+mirrored.Load()))
+	}
+	return err
+}
+
+// mirrorSVN checks out the repository of slug below root, or updates it.
+// Plugins are checked out sparsely, so that branches and the assets of the
+// directory page are left out; the depth of svnPaths is set on every
+// update, which also completes a checkout that was interrupted.
+func (s *Scraper) mirrorSVN(ctx context.Context, root, slug string) error {
+	wc := filepath.Join(root, sanitizeName(slug))
+	update := []string{"update", wc}
+	if len(s.dir.svnPaths) > 0 {
+		update = []string{"update", "--set-depth", "infinity"}
+		for _, path := range s.dir.svnPaths {
+			update = append(update, filepath.Join(wc, path))
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(wc, ".svn")); err != nil {
+		repoURL := s.dir.svnURL + url.PathEscape(slug) + "/"
+		if len(s.dir.svnPaths) == 0 {
+			return svn(ctx, "checkout", repoURL, wc)
+		}
+		if err := svn(ctx, "checkout", "--depth", "empty", repoURL, wc); err != nil {
+			return err
+		}
+		return svn(ctx, update...)
+	}
+	err := svn(ctx, update...)
+	if err != nil {
+		// An interrupted run leaves the working copy locked.
+		if cerr := svn(ctx, "cleanup", wc); cerr == nil {
+			err = svn(ctx, update...)
+		}
+	}
+	return err
+}
+
+// svn runs the Subversion client with args, reporting its error output if
+// it fails.
+func svn(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "svn", append([]string{"--non-interactive", "--quiet"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("svn %s: %w: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
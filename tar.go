@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errTarStream reports that the tar stream broke, after which no further
+// file can be appended.
+var errTarStream = errors.New("tar stream failed")
+
+// tarStorage appends the files of a run to a single tar stream on stdout or
+// in a file, for pipelines such as | zstd | ssh backup-host. The stream is
+// only written, so Stat knows only the files of the run, and nothing can
+// be deleted. Archives are staged in the output directory in full before
+// they are appended, see archiveStream, because an entry cannot be taken
+// back once its header is written.
+type tarStorage struct {
+	open func() (io.WriteCloser, error)
+
+	mu    sync.Mutex
+	out   io.WriteCloser
+	tw    *tar.Writer
+	err   error
+	files map[string]FileInfo
+}
+
+// newTarStorage returns the storage of tar://- for stdout or
+// tar:///path/corpus.tar for a file. The stream is started with the first
+// file, so commands that store nothing write nothing.
+func newTarStorage(target *url.URL) *tarStorage {
+	open := func() (io.WriteCloser, error) {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	if target.Host != "-" {
+		fileName := filepath.FromSlash(target.Path)
+		open = func() (io.WriteCloser, error) {
+			return os.Create(fileName)
+		}
+	}
+	return &tarStorage{open: open, files: map[string]FileInfo{}}
+}
+
+// Put appends r as name. The stream cannot take back a file that failed
+// halfway, so the first error ends it.
+func (t *tarStorage) Put(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	if t.tw == nil {
+		out, err := t.open()
+		if err != nil {
+			t.err = fmt.Errorf("%w: %w", errTarStream, err)
+			return t.err
+		}
+		t.out, t.tw = out, tar.NewWriter(out)
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  time.Now().Truncate(time.Second),
+		Format:   tar.FormatPAX,
+	}
+	// The object metadata becomes vendor PAX records of the file.
+	if len(meta) > 0 {
+		header.PAXRecords = map[string]string{}
+		for key, value := range meta {
+			header.PAXRecords["WPSCRAPER."+key] = value
+		}
+	}
+	err := t.tw.WriteHeader(header)
+	if err == nil {
+		_, err = io.CopyN(t.tw, contextReader{ctx, r}, size)
+	}
+	if err != nil {
+		t.err = fmt.Errorf("%w after %d files: %w", errTarStream, len(t.files), err)
+		return t.err
+	}
+	t.files[name] = FileInfo{Name: name, Size: size, Modified: header.ModTime}
+	return nil
+}
+
+func (t *tarStorage) Exists(ctx context.Context, name string) (bool, error) {
+	return exists(t.Stat(ctx, name))
+}
+
+// Stat describes the files appended by this run; the stream cannot be
+// read back.
+func (t *tarStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info, ok := t.files[name]
+	if !ok {
+		return FileInfo{}, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return info, nil
+}
+
+func (t *tarStorage) Delete(ctx context.Context, name string) error {
+	return fmt.Errorf("delete %s from a tar stream: %w", name, errors.ErrUnsupported)
+}
+
+func (t *tarStorage) List(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	t.mu.Lock()
+	var infos []FileInfo
+	for name, info := range t.files {
+		if strings.HasPrefix(name, prefix) {
+			infos = append(infos, info)
+		}
+	}
+	t.mu.Unlock()
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close ends the stream with the tar trailer.
+func (t *tarStorage) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tw == nil {
+		return nil
+	}
+	err := t.tw.Close()
+	if cerr := t.out.Close(); err == nil {
+		err = cerr
+	}
+	t.tw = nil
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
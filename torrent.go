@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// torrentFile is the snapshot torrent, prefixed with the kind of the
+	// run like the manifest, as in plugins.torrent.
+	torrentFile = "torrent"
+
+	// minPieceSize and maxPieceSize bound the automatic piece size, which
+	// aims at maxPieces pieces per torrent.
+	minPieceSize = 256 << 10
+	maxPieceSize = 16 << 20
+	maxPieces    = 2000
+)
+
+// torrentModes are the values of --torrent: no torrents, one multi-file
+// torrent of the snapshot, or one torrent next to every archive.
+var torrentModes = []string{"off", "snapshot", "archives"}
+
+// torrentFileEntry is a file of a torrent, with the path below its root.
+type torrentFileEntry struct {
+	path   string
+	name   string
+	length int64
+}
+
+// writeTorrents builds the torrents of the archives listed in the manifest
+// after a download run. Archive torrents that are newer than their archive
+// are kept.
+func (s *Scraper) writeTorrents(entries []ManifestEntry) error {
+	switch s.cfg.Torrent {
+	case "snapshot":
+		root, err := filepath.Abs(s.cfg.OutputDir)
+		if err != nil {
+			return err
+		}
+		var files []torrentFileEntry
+		for _, entry := range entries {
+			files = append(files, torrentFileEntry{
+				path:   filepath.Join(s.cfg.OutputDir, filepath.FromSlash(entry.File)),
+				name:   entry.File,
+				length: entry.Size,
+			})
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		// Clients fetch the files of a multi-file torrent from the web seed
+		// URL followed by the name of the torrent, so seeds must serve the
+		// output directory under its own name.
+		name := filepath.Base(root)
+		var seeds []string
+		for _, seed := range s.cfg.TorrentWebSeeds {
+			parent, ok := strings.CutSuffix(strings.TrimSuffix(seed, "/"), "/"+name)
+			if !ok {
+				slog.Warn("web seed does not end with the name of the output directory, leaving it out", "seed", seed, "name", name)
+				continue
+			}
+			seeds = append(seeds, parent+"/")
+		}
+		fileName := filepath.Join(s.cfg.OutputDir, s.dir.name+"."+torrentFile)
+		if err := s.writeTorrent(fileName, name, files, seeds); err != nil {
+			return err
+		}
+		slog.Info("wrote snapshot torrent", "file", fileName, "archives", len(files))
+	case "archives":
+		for _, entry := range entries {
+			archive := filepath.Join(s.cfg.OutputDir, filepath.FromSlash(entry.File))
+			fileName := archive + "." + torrentFile
+			if info, err := os.Stat(fileName); err == nil {
+				if archiveInfo, err := os.Stat(archive); err == nil && !info.ModTime().Before(archiveInfo.ModTime()) {
+					continue
+				}
+			}
+			// For single-file torrents the web seed is the URL of the file.
+			var seeds []string
+			for _, seed := range s.cfg.TorrentWebSeeds {
+				seeds = append(seeds, strings.TrimSuffix(seed, "/")+"/"+entry.File)
+			}
+			files := []torrentFileEntry{{path: archive, name: path.Base(entry.File), length: entry.Size}}
+			if err := s.writeTorrent(fileName, path.Base(entry.File), files, seeds); err != nil {
+				return fmt.Errorf("%s: %w", entry.File, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeTorrent writes the torrent named name of files to fileName, with
+// the web seeds of BEP 19. A single file named name becomes a single-file
+// torrent, anything else a multi-file torrent of the directory name.
+func (s *Scraper) writeTorrent(fileName, name string, files []torrentFileEntry, seeds []string) error {
+	var total int64
+	for _, file := range files {
+		total += file.length
+	}
+	pieceSize := int64(s.cfg.TorrentPieceSize)
+	if pieceSize == 0 {
+		pieceSize = minPieceSize
+		for pieceSize < maxPieceSize && total/pieceSize > maxPieces {
+			pieceSize *= 2
+		}
+	}
+	pieces, err := hashPieces(files, pieceSize)
+	if err != nil {
+		return err
+	}
+
+	info := map[string]any{"name": name, "piece length": pieceSize, "pieces": pieces}
+	if len(files) == 1 && files[0].name == name {
+		info["length"] = files[0].length
+	} else {
+		var list []any
+		for _, file := range files {
+			var parts []any
+			for _, part := range strings.Split(file.name, "/") {
+				parts = append(parts, part)
+			}
+			list = append(list, map[string]any{"length": file.length, "path": parts})
+		}
+		info["files"] = list
+	}
+	torrent := map[string]any{
+		"info":          info,
+		"created by":    "wordpress-plugin-scraper",
+		"creation date": time.Now().Unix(),
+	}
+	if len(s.cfg.TorrentTrackers) > 0 {
+		torrent["announce"] = s.cfg.TorrentTrackers[0]
+		var tiers []any
+		for _, tracker := range s.cfg.TorrentTrackers {
+			tiers = append(tiers, []any{tracker})
+		}
+		torrent["announce-list"] = tiers
+	}
+	if len(seeds) > 0 {
+		var list []any
+		for _, seed := range seeds {
+			list = append(list, seed)
+		}
+		torrent["url-list"] = list
+	}
+
+	var b bytes.Buffer
+	if err := bencode(&b, torrent); err != nil {
+		return err
+	}
+	return writeFileAtomic(fileName, b.Bytes())
+}
+
+// hashPieces returns the concatenated SHA-1 sums of the pieces of the
+// files read one after the other, as the info dictionary stores them.
+func hashPieces(files []torrentFileEntry, pieceSize int64) ([]byte, error) {
+	w := &pieceWriter{hash: sha1.New(), size: pieceSize}
+	for _, file := range files {
+		f, err := os.Open(file.path)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if n != file.length {
+			return nil, fmt.Errorf("%s has %d bytes, the manifest lists %d", file.path, n, file.length)
+		}
+	}
+	if w.filled > 0 {
+		w.pieces = w.hash.Sum(w.pieces)
+	}
+	return w.pieces, nil
+}
+
+// pieceWriter hashes what is written to it into pieces of size bytes,
+// carrying the last incomplete piece over to the next file.
+type pieceWriter struct {
+	hash   hash.Hash
+	size   int64
+	filled int64
+	pieces []byte
+}
+
+func (w *pieceWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(int64(len(p)), w.size-w.filled)
+		w.hash.Write(p[:n])
+		p = p[n:]
+		if w.filled += n; w.filled == w.size {
+			w.pieces = w.hash.Sum(w.pieces)
+			w.hash.Reset()
+			w.filled = 0
+		}
+	}
+	return written, nil
+}
+
+// bencode writes v, made of strings, byte slices, integers, lists and
+// dictionaries with sorted keys, in the encoding of BitTorrent metainfo.
+func bencode(w *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case string:
+		fmt.Fprintf(w, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(w, "%d:", len(v))
+		w.Write(v)
+	case int64:
+		fmt.Fprintf(w, "i%de", v)
+	case []any:
+		w.WriteByte('l')
+		for _, item := range v {
+			if err := bencode(w, item); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w.WriteByte('d')
+		for _, key := range keys {
+			bencode(w, key)
+			if err := bencode(w, v[key]); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: unsupported type %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// languagePack is an entry of the translations API response.
+type languagePack struct {
+	Language string `json:"language"`
+	Version  string `json:"version"`
+	Package  string `json:"package"`
+}
+
+// downloadLanguagePacks stores the language packs selected by
+// --language-packs next to the archive at archivePath, as
+// <archive>.<language>.zip. Failures are logged and do not fail the plugin.
+func (s *Scraper) downloadLanguagePacks(ctx context.Context, plugin Plugin, archivePath string) {
+	query := url.Values{"slug": {plugin.Slug}, "version": {plugin.Version}}
+	var resp struct {
+		Translations []languagePack `json:"translations"`
+	}
+	if err := s.getJSON(ctx, s.limiter, s.dir.translationsURL+"?"+query.Encode(), &resp); err != nil {
+		slog.Warn("failed to list language packs", "slug", plugin.Slug, "version", plugin.Version, "error", err)
+		return
+	}
+
+	all := slices.Contains(s.cfg.LanguagePacks, "all")
+	base := strings.TrimSuffix(archivePath, ".zip")
+	for _, pack := range resp.Translations {
+		if !all && !slices.Contains(s.cfg.LanguagePacks, pack.Language) {
+			continue
+		}
+		fileName := base + "." + sanitizeName(pack.Language) + ".zip"
+		// Language packs are zip archives too, and get the same validated,
+		// resumable transfer as the plugin archive.
+		n, _, err := s.transfer(ctx, pack.Package, fileName, verifyArchive, nil)
+		if err != nil {
+			slog.Warn("failed to download language pack", "slug", plugin.Slug, "version", plugin.Version,
+				"language", pack.Language, "error", err)
+			continue
+		}
+		slog.Debug("downloaded language pack", "slug", plugin.Slug, "version", plugin.Version,
+			"language", pack.Language, "bytes", n)
+		meta := releaseMeta(plugin, "")
+		meta["language"] = pack.Language
+		if err := s.publish(ctx, fileName, false, meta); err != nil {
+			slog.Warn("failed to upload language pack", "slug", plugin.Slug, "version", plugin.Version,
+				"language", pack.Language, "error", err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uiPageSize is the number of plugins on a page of the plugin list.
+const uiPageSize = 50
+
+// uiHistory is the number of downloads the page of a plugin shows.
+const uiHistory = 20
+
+// uiLayout is shared by the pages of the web UI, which define title and
+// content.
+const uiLayout = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{template "title" .}} · wpscraper</title>
+<style>
+body { font: 14px/1.4 system-ui, sans-serif; margin: 1.5em auto; max-width: 70em; padding: 0 1em; color: #1d2327; }
+a { color: #2271b1; }
+table { border-collapse: collapse; width: 100%; margin: 1em 0; }
+th, td { text-align: left; padding: .3em .6em; border-bottom: 1px solid #dcdcde; vertical-align: top; }
+th { background: #f6f7f7; }
+td.num { text-align: right; }
+form input, form select, form button { font: inherit; margin-right: .5em; }
+.downloaded, .verified { color: #008a20; }
+.skipped { color: #996800; }
+.failed, .missing, .corrupt, .mismatch { color: #d63638; }
+code { font-size: 90%; }
+</style>
+</head>
+<body>
+<p><a href="/">{{.Kind}}</a></p>
+{{template "content" .}}
+</body>
+</html>`
+
+const uiListPage = `{{define "title"}}{{.Kind}}{{end}}
+{{define "content"}}
+<form>
+<input name="q" value="{{.Filter.Query}}" placeholder="slug or name">
+<select name="outcome">
+<option value="">any outcome</option>
+{{range .Outcomes}}<option{{if eq . $.Filter.Outcome}} selected{{end}}>{{.}}</option>{{end}}
+</select>
+<input name="min_installs" type="number" min="0" value="{{if .Filter.MinInstalls}}{{.Filter.MinInstalls}}{{end}}" placeholder="min. installs">
+<button>Filter</button>
+</form>
+<p>{{if .Plugins}}{{.First}}–{{.Last}} of {{.Total}}{{else}}No {{.Kind}} match.{{end}}</p>
+<table>
+<tr><th>Plugin</th><th>Version</th><th>Active installs</th><th>Rating</th><th>Last updated</th><th>Latest download</th></tr>
+{{range .Plugins}}<tr>
+<td><a href="/plugins/{{.Slug}}">{{.Name}}</a><br><code>{{.Slug}}</code></td>
+<td>{{.Version}}</td>
+<td class="num">{{.ActiveInstalls}}</td>
+<td class="num">{{.Rating}}</td>
+<td>{{timestamp .LastUpdated}}</td>
+<td class="{{.Outcome}}">{{.Outcome}}</td>
+</tr>{{end}}
+</table>
+<p>{{if .Prev}}<a href="{{.Prev}}">previous</a> {{end}}{{if .Next}}<a href="{{.Next}}">next</a>{{end}}</p>
+{{end}}`
+
+const uiPluginPage = `{{define "title"}}{{.Plugin.Name}}{{end}}
+{{define "content"}}
+<h1>{{.Plugin.Name}}</h1>
+{{with .Info.ShortDescription}}<p>{{.}}</p>{{end}}
+<table>
+<tr><th>Slug</th><td><code>{{.Plugin.Slug}}</code></td></tr>
+<tr><th>Version</th><td>{{.Plugin.Version}}</td></tr>
+<tr><th>Author</th><td>{{.Plugin.Author}}</td></tr>
+{{with .Info.Homepage}}<tr><th>Homepage</th><td><a href="{{.}}">{{.}}</a></td></tr>{{end}}
+<tr><th>Active installs</th><td>{{.Plugin.ActiveInstalls}}</td></tr>
+<tr><th>Downloaded</th><td>{{.Plugin.Downloaded}}</td></tr>
+<tr><th>Rating</th><td>{{.Plugin.Rating}} of 100 from {{.Plugin.NumRatings}} ratings</td></tr>
+<tr><th>Requires</th><td>WordPress {{.Plugin.Requires}}, PHP {{.Plugin.RequiresPHP}}, tested up to {{.Plugin.Tested}}</td></tr>
+<tr><th>Last updated</th><td>{{timestamp .Plugin.LastUpdated}}</td></tr>
+<tr><th>Added</th><td>{{timestamp .Plugin.Added}}</td></tr>
+<tr><th>Last seen</th><td>{{timestamp .Plugin.Seen}}</td></tr>
+{{with .Tags}}<tr><th>Tags</th><td>{{range $i, $tag := .}}{{if $i}}, {{end}}{{$tag}}{{end}}</td></tr>{{end}}
+</table>
+
+<h2>Download history</h2>
+<table>
+<tr><th>Finished</th><th>Version</th><th>Outcome</th><th>Size</th><th>SHA-256</th><th>Archive</th><th>Verification</th></tr>
+{{range .Downloads}}<tr>
+<td>{{timestamp .Finished}}</td>
+<td>{{.Version}}</td>
+<td class="{{.Outcome}}">{{.Outcome}}{{with .Reason}}<br>{{.}}{{end}}</td>
+<td class="num">{{if .Size}}{{size .Size}}{{end}}</td>
+<td>{{with .SHA256}}<code title="{{.}}">{{slice . 0 12}}…</code>{{end}}</td>
+<td>{{with .Link}}<a href="{{.}}">{{$.Plugin.Slug}}</a>{{end}}</td>
+<td class="{{.Status}}">{{.Status}}{{with .Problem}}<br>{{.}}{{end}}{{with .Quarantined}}<br>quarantined copy {{.}}{{end}}</td>
+</tr>{{end}}
+</table>
+
+<h2>Versions</h2>
+<table>
+<tr><th>Version</th><th>Download</th></tr>
+{{range .Versions}}<tr><td>{{.Version}}</td><td><a href="{{.DownloadLink}}">{{.DownloadLink}}</a></td></tr>{{end}}
+</table>
+{{end}}`
+
+var uiFuncs = template.FuncMap{
+	"timestamp": func(t any) string {
+		switch t := t.(type) {
+		case time.Time:
+			return t.UTC().Format("2006-01-02 15:04")
+		case *time.Time:
+			if t != nil {
+				return t.UTC().Format("2006-01-02 15:04")
+			}
+		}
+		return ""
+	},
+	"size": func(n int64) string {
+		switch {
+		case n >= 1e6:
+			return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+		case n >= 1e3:
+			return fmt.Sprintf("%.1f KB", float64(n)/1e3)
+		}
+		return strconv.FormatInt(n, 10) + " B"
+	},
+}
+
+var (
+	uiListTemplate   = template.Must(template.Must(template.New("layout").Funcs(uiFuncs).Parse(uiLayout)).Parse(uiListPage))
+	uiPluginTemplate = template.Must(template.Must(template.New("layout").Funcs(uiFuncs).Parse(uiLayout)).Parse(uiPluginPage))
+)
+
+// uiDownload is a download on the page of a plugin, with the state of its
+// archive in the output directory.
+type uiDownload struct {
+	storedDownload
+	archiveCheck
+	// Link serves the archive below /files/, if it is on disk.
+	Link string
+}
+
+// ui serves the web UI of the metadata store and the archives of the
+// output directory.
+type ui struct {
+	s     *Scraper
+	store *metadataStore
+}
+
+// runUI serves a web UI for browsing the plugins of the metadata store, with
+// their metadata, download history and the state of their archives, on
+// --listen until it is interrupted.
+func runUI(ctx context.Context, s *Scraper) error {
+	store, err := s.openMetadataStore()
+	if err != nil {
+		return err
+	}
+	defer store.close()
+	u := &ui{s: s, store: store}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", u.list)
+	mux.HandleFunc("/plugins/", u.plugin)
+	mux.Handle("/files/", http.StripPrefix("/files/", http.FileServer(http.Dir(s.cfg.OutputDir))))
+	return s.listenAndServe(ctx, mux, "/")
+}
+
+func (u *ui) render(w http.ResponseWriter, t *template.Template, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		slog.Error("failed to render page", "error", err)
+	}
+}
+
+func (u *ui) fail(w http.ResponseWriter, err error) {
+	slog.Error("failed to query the metadata store", "error", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// list shows the plugins of --kind, filtered by the q, outcome and
+// min_installs parameters and paged by offset.
+func (u *ui) list(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	f := pluginFilter{Kind: u.s.dir.name, Query: q.Get("q"), Outcome: q.Get("outcome"), Limit: uiPageSize}
+	f.MinInstalls, _ = strconv.ParseInt(q.Get("min_installs"), 10, 64)
+	f.Offset, _ = strconv.Atoi(q.Get("offset"))
+	f.Offset = max(f.Offset, 0)
+	plugins, total, err := u.store.plugins(r.Context(), f)
+	if err != nil {
+		u.fail(w, err)
+		return
+	}
+	pageURL := func(offset int) string {
+		q.Set("offset", strconv.Itoa(offset))
+		return "/?" + q.Encode()
+	}
+	data := struct {
+		Kind        string
+		Filter      pluginFilter
+		Outcomes    []string
+		Plugins     []storedPlugin
+		Total       int
+		First, Last int
+		Prev, Next  string
+	}{
+		Kind:     u.s.dir.name,
+		Filter:   f,
+		Outcomes: []string{"downloaded", "skipped", "failed"},
+		Plugins:  plugins,
+		Total:    total,
+		First:    f.Offset + 1,
+		Last:     f.Offset + len(plugins),
+	}
+	if f.Offset > 0 {
+		data.Prev = pageURL(max(f.Offset-uiPageSize, 0))
+	}
+	if f.Offset+len(plugins) < total {
+		data.Next = pageURL(f.Offset + uiPageSize)
+	}
+	u.render(w, uiListTemplate, data)
+}
+
+// plugin shows the plugin of /plugins/SLUG.
+func (u *ui) plugin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slug := strings.TrimPrefix(r.URL.Path, "/plugins/")
+	p, err := u.store.plugin(ctx, u.s.dir.name, slug)
+	if err != nil {
+		u.fail(w, err)
+		return
+	}
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	versions, err := u.store.versions(ctx, p.Kind, p.Slug)
+	if err != nil {
+		u.fail(w, err)
+		return
+	}
+	downloads, err := u.store.downloads(ctx, downloadFilter{Kind: p.Kind, Slug: p.Slug, Limit: uiHistory})
+	if err != nil {
+		u.fail(w, err)
+		return
+	}
+	data := struct {
+		Kind      string
+		Plugin    *storedPlugin
+		Info      Plugin
+		Tags      []string
+		Versions  []storedVersion
+		Downloads []uiDownload
+	}{Kind: u.s.dir.name, Plugin: p, Versions: versions}
+	// The metadata is shown as far as it parses.
+	json.Unmarshal([]byte(p.Metadata), &data.Info)
+	for _, slug := range sortedKeys(data.Info.Tags) {
+		data.Tags = append(data.Tags, data.Info.Tags[slug])
+	}
+	// Runs often download the same archive again, which is checked once.
+	checked := map[string]uiDownload{}
+	for _, d := range downloads {
+		ud, ok := checked[d.File+"\x00"+d.SHA256]
+		if !ok {
+			ud = u.check(d)
+			checked[d.File+"\x00"+d.SHA256] = ud
+		}
+		ud.storedDownload = d
+		data.Downloads = append(data.Downloads, ud)
+	}
+	u.render(w, uiPluginTemplate, data)
+}
+
+// check verifies the archive of d in the output directory and links it
+// below /files/ if it is on disk.
+func (u *ui) check(d storedDownload) uiDownload {
+	ud := uiDownload{storedDownload: d, archiveCheck: checkDownload(u.s.cfg.OutputDir, d)}
+	if ud.Path != "" {
+		ud.Link = "/files/" + (&url.URL{Path: ud.Path}).EscapedPath()
+	}
+	return ud
+}
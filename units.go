@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that additionally accepts day, week and year
+// suffixes such as "180d", "2w" or "1y". It can be used as a flag and in
+// configuration files.
+type Duration time.Duration
+
+var durationUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"y", 365 * 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+}
+
+func parseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	for _, u := range durationUnits {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return Duration(v * float64(u.unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return Duration(d), nil
+}
+
+func (d Duration) String() string {
+	if d != 0 && time.Duration(d)%(24*time.Hour) == 0 {
+		return strconv.FormatInt(int64(time.Duration(d)/(24*time.Hour)), 10) + "d"
+	}
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+// ByteSize is a number of bytes that accepts unit suffixes such as "50MB",
+// "1.5GiB" or "512K". Decimal units (KB, MB, GB, TB) are powers of 1000 and
+// binary units (KiB, MiB, GiB, TiB) powers of 1024.
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	unit   int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"K", 1e3},
+	{"B", 1},
+}
+
+func parseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	number, unit := s, int64(1)
+	upper := strings.ToUpper(s)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			number, unit = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.unit
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(v * float64(unit)), nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range []struct {
+		suffix string
+		unit   int64
+	}{{"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}} {
+		if b != 0 && int64(b)%u.unit == 0 {
+			return strconv.FormatInt(int64(b)/u.unit, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+func (b *ByteSize) Set(s string) error {
+	v, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	return b.Set(string(text))
+}
+
+// Bandwidth is a transfer rate in bytes per second. It is written like a
+// ByteSize with an optional "/s" suffix, such as "20MB/s".
+type Bandwidth int64
+
+func (b Bandwidth) String() string {
+	if b == 0 {
+		return "0"
+	}
+	return ByteSize(b).String() + "/s"
+}
+
+func (b *Bandwidth) Set(s string) error {
+	v, err := parseByteSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil {
+		return fmt.Errorf("invalid bandwidth %q", s)
+	}
+	*b = Bandwidth(v)
+	return nil
+}
+
+func (b *Bandwidth) UnmarshalText(text []byte) error {
+	return b.Set(string(text))
+}
+
+// FailureLimit is a number of failures, either absolute such as "50" or as
+// a percentage of the attempts such as "5%". The zero value sets no limit.
+type FailureLimit struct {
+	Count   int
+	Percent float64
+}
+
+// minFailureSample is the number of attempts before a percentage limit
+// applies, so that a run does not stop after a single early failure.
+const minFailureSample = 20
+
+// exceeded reports whether failed of attempted exceed the limit.
+func (l FailureLimit) exceeded(failed, attempted int) bool {
+	switch {
+	case l.Count > 0:
+		return failed > l.Count
+	case l.Percent > 0:
+		return attempted >= minFailureSample && float64(failed) > l.Percent/100*float64(attempted)
+	}
+	return false
+}
+
+func (l FailureLimit) String() string {
+	if l.Percent > 0 {
+		return strconv.FormatFloat(l.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.Itoa(l.Count)
+}
+
+func (l *FailureLimit) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if n, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(n, 64)
+		if err != nil || v < 0 || v > 100 {
+			return fmt.Errorf("invalid failure limit %q", s)
+		}
+		*l = FailureLimit{Percent: v}
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid failure limit %q", s)
+	}
+	*l = FailureLimit{Count: v}
+	return nil
+}
+
+func (l *FailureLimit) UnmarshalText(text []byte) error {
+	return l.Set(string(text))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// updateFile keeps the state of the update command in the output
+// directory, prefixed with the kind of the run like the checkpoint, as in
+// plugins-update.json.
+const updateFile = "update.json"
+
+// updateState is the content of the update file.
+type updateState struct {
+	Kind string `json:"kind"`
+	// LastUpdated is the newest last_updated of the plugins the previous
+	// update run passed on. Older plugins were handled by that run.
+	LastUpdated time.Time `json:"last_updated"`
+	Checked     time.Time `json:"checked"`
+}
+
+// errUpToDate stops the walk at the first plugin that was not updated since
+// the previous update run.
+var errUpToDate = errors.New("reached plugins handled by the previous update run")
+
+// runUpdate downloads the new releases of the plugins updated since the
+// previous update run. The updated browse listing is sorted by last_updated,
+// newest first, so the walk stops at the first plugin that is not newer than
+// the one recorded by that run, or than --updated-within on the first run;
+// releases that are already in the output directory are skipped.
+// The update file is only advanced when the run was not interrupted, and
+// plugins whose download failed are left to retry-failed.
+func runUpdate(ctx context.Context, s *Scraper) error {
+	switch {
+	case s.cfg.Query.Browse != "" && s.cfg.Query.Browse != "updated":
+		return fmt.Errorf("update walks the updated listing and cannot be combined with --browse %s", s.cfg.Query.Browse)
+	case s.cfg.Slug != "" || s.cfg.SlugsFile != "":
+		return errors.New("update cannot be combined with --slug or --slugs-file, use download")
+	case s.cfg.FromManifest != "":
+		return errors.New("update cannot be combined with --from-manifest, use download")
+	case s.cfg.Top > 0 || s.cfg.Sample > 0:
+		return errors.New("update cannot be combined with --top or --sample, which need the whole listing")
+	case s.cfg.MaxDownloads > 0:
+		// The next run would start after the newest plugin and skip the
+		// ones the limit left out.
+		return errors.New("update cannot be combined with --max-downloads, which would lose the plugins it leaves out")
+	}
+	s.cfg.Query.Browse = "updated"
+	// Only the releases that are not in the output directory yet are new;
+	// --if-exists verify still checks the others.
+	if s.cfg.IfExists == "overwrite" {
+		s.cfg.IfExists = "skip"
+	}
+
+	path := filepath.Join(s.cfg.OutputDir, s.dir.name+"-"+updateFile)
+	state, err := readUpdateState(path, s.dir.name)
+	if err != nil {
+		return fmt.Errorf("read update state: %w", err)
+	}
+	since := state.LastUpdated
+	if within := time.Duration(s.cfg.Filters.UpdatedWithin); within > 0 {
+		if cutoff := time.Now().Add(-within); cutoff.After(since) {
+			since = cutoff
+		}
+		// The walk stops at since, while the filter would only skip the
+		// older plugins of the rest of the listing.
+		s.cfg.Filters.UpdatedWithin = 0
+	}
+	if since.IsZero() {
+		slog.Warn("no earlier update run, walking the whole updated listing", "file", path)
+	} else {
+		slog.Info("downloading plugins updated since", "since", since)
+	}
+
+	newest := state.LastUpdated
+	updated := func(fn func(Plugin) error) error {
+		err := s.each(ctx, func(plugin Plugin) error {
+			// Plugins updated within the same minute as the last one of the
+			// previous run may be new, so only older plugins stop the walk.
+			if !plugin.LastUpdated.IsZero() && plugin.LastUpdated.Before(since) {
+				return errUpToDate
+			}
+			if plugin.LastUpdated.After(newest) {
+				newest = plugin.LastUpdated.Time
+			}
+			return fn(plugin)
+		})
+		if errors.Is(err, errUpToDate) {
+			return nil
+		}
+		return err
+	}
+	source := updated
+	if s.cfg.AllVersions {
+		source = s.allVersions(source)
+	}
+	if s.cfg.DryRun {
+		return dryRun(source, "downloaded")
+	}
+
+	err = s.downloadAll(ctx, source)
+	if err != nil && !isPartial(err) {
+		return err
+	}
+	state = updateState{Kind: s.dir.name, LastUpdated: newest, Checked: time.Now().UTC()}
+	if werr := state.write(path); werr != nil {
+		slog.Error("failed to write update state", "error", werr)
+	} else if werr := s.publish(context.WithoutCancel(ctx), path, true, nil); werr != nil {
+		slog.Error("failed to upload run metadata", "file", path, "error", werr)
+	}
+	return err
+}
+
+// readUpdateState reads the update file at path. A missing file returns
+// the zero state of a first run.
+func readUpdateState(path, kind string) (updateState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return updateState{Kind: kind}, nil
+	}
+	if err != nil {
+		return updateState{}, err
+	}
+	var state updateState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return updateState{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if state.Kind != kind {
+		return updateState{}, fmt.Errorf("%s: state of %s, not %s", path, state.Kind, kind)
+	}
+	return state, nil
+}
+
+func (u updateState) write(path string) error {
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
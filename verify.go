@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// verifyArchive opens the zip archive at path and reads every entry so that
+// truncated files and CRC mismatches are detected.
+func verifyArchive(path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if len(r.File) == 0 {
+		return fmt.Errorf("archive is empty")
+	}
+
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// archiveCheck is the state of the archive of a recorded download in the
+// output directory, as the web UI and the GraphQL API report it.
+type archiveCheck struct {
+	// Status is verified, missing, corrupt or mismatch, with the details
+	// in Problem, and empty for downloads without an archive.
+	Status  string
+	Problem string
+	// Path is the archive, or its recompressed copy, relative to the output
+	// directory with forward slashes if it is on disk.
+	Path string
+	// Quarantined is the copy below the quarantine directory of an archive
+	// at the same path that failed validation, if there is one.
+	Quarantined string
+}
+
+// checkDownload verifies the archive of d in outputDir, or its recompressed
+// copy, against the SHA-256 the store recorded, and looks for a
+// quarantined copy.
+func checkDownload(outputDir string, d storedDownload) archiveCheck {
+	var c archiveCheck
+	if d.File == "" {
+		return c
+	}
+	// The paths come from the store, so they are not trusted to stay in
+	// outputDir.
+	for _, name := range []string{d.File, d.Repacked} {
+		if name != "" && !filepath.IsLocal(filepath.FromSlash(name)) {
+			c.Status, c.Problem = "missing", fmt.Sprintf("file %q is outside the output directory", name)
+			return c
+		}
+	}
+	for _, name := range []string{d.File, d.Repacked} {
+		if name == "" {
+			continue
+		}
+		rel := path.Join(quarantineDir, name)
+		if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(rel))); err == nil {
+			c.Quarantined = rel
+		}
+	}
+
+	name := filepath.FromSlash(d.File)
+	verify := verifyArchive
+	sum := fileSHA256
+	if _, err := os.Stat(filepath.Join(outputDir, name)); errors.Is(err, fs.ErrNotExist) {
+		// Downloads recorded before the store kept the recompressed
+		// archive have it next to where the zip was.
+		name = repackedName(name)
+		if d.Repacked != "" {
+			name = filepath.FromSlash(d.Repacked)
+		}
+		verify = verifyRepacked
+		sum = func(path string) (string, error) {
+			_, sum, err := repackedOrigin(path)
+			return sum, err
+		}
+	}
+	file := filepath.Join(outputDir, name)
+	if _, err := os.Stat(file); err != nil {
+		c.Status = "missing"
+		return c
+	}
+	c.Path = filepath.ToSlash(name)
+	if err := verify(file); err != nil {
+		c.Status, c.Problem = "corrupt", err.Error()
+		return c
+	}
+	if d.SHA256 != "" {
+		got, err := sum(file)
+		if err != nil {
+			c.Status, c.Problem = "corrupt", err.Error()
+			return c
+		}
+		if got != d.SHA256 {
+			c.Status, c.Problem = "mismatch", "SHA-256 is now "+got[:min(len(got), 12)]+"…"
+			return c
+		}
+	}
+	c.Status = "verified"
+	return c
+}
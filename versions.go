@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sort"
+)
+
+// trunkVersion is the key of the development version in the versions map
+// of the API, whose archive changes under the same name.
+const trunkVersion = "trunk"
+
+// releases returns the releases of plugin that --all-versions downloads:
+// the current one, with the metadata of plugin, and the others of its
+// versions map, newest first, at most limit in all unless limit is 0. The
+// others only carry their slug, version and download link, so that the
+// exports keep the metadata of the current release, as for --from-manifest.
+func releases(plugin Plugin, limit int) []Plugin {
+	var versions []string
+	for version, link := range plugin.Versions {
+		if version != plugin.Version && version != trunkVersion && link != "" {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if c := compareVersions(versions[i], versions[j]); c != 0 {
+			return c > 0
+		}
+		return versions[i] > versions[j]
+	})
+
+	list := []Plugin{plugin}
+	for _, version := range versions {
+		if limit > 0 && len(list) >= limit {
+			break
+		}
+		list = append(list, Plugin{Slug: plugin.Slug, Version: version, DownloadLink: string(plugin.Versions[version])})
+	}
+	return list
+}
+
+// allVersions passes every release of the plugins of source to fn, for
+// --all-versions.
+func (s *Scraper) allVersions(source func(func(Plugin) error) error) func(func(Plugin) error) error {
+	return func(fn func(Plugin) error) error {
+		return source(func(plugin Plugin) error {
+			for _, release := range releases(plugin, s.cfg.MaxVersions) {
+				if err := fn(release); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
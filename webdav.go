@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxWebDAVChunks is the most chunks Nextcloud accepts for one upload.
+const maxWebDAVChunks = 10000
+
+// webdavStorage stores files on a WebDAV server such as Nextcloud,
+// ownCloud or Apache mod_dav. Files larger than --webdav-chunk-size are
+// sent with the chunked upload protocol of Nextcloud.
+type webdavStorage struct {
+	client *http.Client
+	root   url.URL
+	auth   *webdavAuth
+	// uploads is the collection chunked uploads are assembled in; it is
+	// nil if chunking is off.
+	uploads   *url.URL
+	chunkSize int64
+
+	mu sync.Mutex
+	// created holds the collections that are known to exist.
+	created map[string]bool
+}
+
+// newWebDAVStorage returns the storage of a webdav://host/path target,
+// or webdav+http://host/path for a server without TLS.
+func newWebDAVStorage(cfg Config, client *http.Client, target *url.URL) (*webdavStorage, error) {
+	root := *target
+	root.Scheme = "https"
+	if target.Scheme == "webdav+http" {
+		root.Scheme = "http"
+	}
+	root.User = nil
+	root.Path = strings.TrimSuffix(root.Path, "/")
+
+	user, password := cfg.WebDAVUser, cfg.WebDAVPassword
+	if user == "" {
+		user = target.User.Username()
+	}
+	if p, ok := target.User.Password(); ok && password == "" {
+		password = p
+	}
+	w := &webdavStorage{
+		client:    client,
+		root:      root,
+		auth:      &webdavAuth{user: user, password: password},
+		chunkSize: int64(cfg.WebDAVChunkSize),
+		created:   map[string]bool{},
+	}
+	if w.chunkSize > 0 {
+		// Nextcloud serves the files of a user below
+		// remote.php/dav/files/<user> and assembles their chunked uploads
+		// below remote.php/dav/uploads/<user>.
+		before, after, ok := strings.Cut(root.Path, "/remote.php/dav/files/")
+		if !ok {
+			return nil, fmt.Errorf("webdav-chunk-size needs a Nextcloud URL below /remote.php/dav/files/<user>, got %s", root.Redacted())
+		}
+		owner, _, _ := strings.Cut(after, "/")
+		uploads := root
+		uploads.Path = before + "/remote.php/dav/uploads/" + owner
+		w.uploads = &uploads
+	}
+	return w, nil
+}
+
+func (w *webdavStorage) fileURL(name string) string {
+	target := w.root
+	target.Path += "/" + name
+	return target.String()
+}
+
+// Put stores r as name, creating the collections above it. WebDAV has no
+// object metadata, so meta is not stored.
+func (w *webdavStorage) Put(ctx context.Context, name string, r io.Reader, size int64, meta map[string]string) error {
+	if err := w.mkdirAll(ctx, path.Dir(name)); err != nil {
+		return err
+	}
+	if w.uploads != nil && size > w.chunkSize {
+		return w.putChunked(ctx, name, r, size)
+	}
+	resp, err := w.do(ctx, http.MethodPut, w.fileURL(name), section(r, 0, size), size, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// putChunked sends r as numbered chunks into a new upload collection and
+// has the server assemble them at name with a MOVE of its .file.
+func (w *webdavStorage) putChunked(ctx context.Context, name string, r io.Reader, size int64) error {
+	chunkSize := max(w.chunkSize, (size+maxWebDAVChunks-1)/maxWebDAVChunks)
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	collection := *w.uploads
+	collection.Path += "/wpscraper-" + hex.EncodeToString(id)
+	destination := http.Header{"Destination": {w.fileURL(name)}}
+
+	resp, err := w.do(ctx, "MKCOL", collection.String(), nil, 0, destination)
+	if err != nil {
+		return fmt.Errorf("create upload: %w", err)
+	}
+	resp.Body.Close()
+
+	err = func() error {
+		for number, offset := 1, int64(0); offset < size; number, offset = number+1, offset+chunkSize {
+			n := min(chunkSize, size-offset)
+			chunk := collection
+			chunk.Path += fmt.Sprintf("/%05d", number)
+			header := http.Header{"Destination": destination["Destination"], "Oc-Total-Length": {strconv.FormatInt(size, 10)}}
+			resp, err := w.do(ctx, http.MethodPut, chunk.String(), section(r, offset, n), n, header)
+			if err != nil {
+				return fmt.Errorf("upload chunk %d: %w", number, err)
+			}
+			resp.Body.Close()
+		}
+		assembled := collection
+		assembled.Path += "/.file"
+		header := http.Header{"Destination": destination["Destination"], "Oc-Total-Length": {strconv.FormatInt(size, 10)}}
+		resp, err := w.do(ctx, "MOVE", assembled.String(), nil, 0, header)
+		if err != nil {
+			return fmt.Errorf("assemble chunks: %w", err)
+		}
+		resp.Body.Close()
+		return nil
+	}()
+	if err != nil {
+		// Drop the chunks, even if ctx was cancelled.
+		if resp, derr := w.do(context.WithoutCancel(ctx), http.MethodDelete, collection.String(), nil, 0, nil); derr == nil {
+			resp.Body.Close()
+		}
+	}
+	return err
+}
+
+// mkdirAll creates the collection dir and its parents below the root.
+func (w *webdavStorage) mkdirAll(ctx context.Context, dir string) error {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	w.mu.Lock()
+	known := w.created[dir]
+	w.mu.Unlock()
+	if known {
+		return nil
+	}
+	if err := w.mkdirAll(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+	resp, err := w.do(ctx, "MKCOL", w.fileURL(dir)+"/", nil, 0, nil)
+	var status *statusError
+	// 405 Method Not Allowed answers MKCOL of a collection that exists.
+	if errors.As(err, &status) && status.code == http.StatusMethodNotAllowed {
+		err = nil
+	} else if err == nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("create %s: %w", dir, err)
+	}
+	w.mu.Lock()
+	w.created[dir] = true
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *webdavStorage) Exists(ctx context.Context, name string) (bool, error) {
+	return exists(w.Stat(ctx, name))
+}
+
+func (w *webdavStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	resp, err := w.do(ctx, http.MethodHead, w.fileURL(name), nil, 0, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return FileInfo{Name: name, Size: resp.ContentLength, Modified: modified}, nil
+}
+
+func (w *webdavStorage) Delete(ctx context.Context, name string) error {
+	resp, err := w.do(ctx, http.MethodDelete, w.fileURL(name), nil, 0, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// webdavPropfind asks for the properties List needs.
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// List walks the collection the prefix points into with PROPFIND requests
+// of depth 1, as servers need not support infinite depth.
+func (w *webdavStorage) List(ctx context.Context, prefix string, fn func(FileInfo) error) error {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+	err := w.list(ctx, dir, prefix, fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (w *webdavStorage) list(ctx context.Context, dir, prefix string, fn func(FileInfo) error) error {
+	target := w.fileURL(dir)
+	if dir == "" {
+		target = w.root.String()
+	}
+	header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := w.do(ctx, "PROPFIND", target+"/", strings.NewReader(webdavPropfind), int64(len(webdavPropfind)), header)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		Responses []struct {
+			Href     string `xml:"href"`
+			Propstat []struct {
+				Status string `xml:"status"`
+				Prop   struct {
+					ResourceType struct {
+						Collection *struct{} `xml:"collection"`
+					} `xml:"resourcetype"`
+					ContentLength int64  `xml:"getcontentlength"`
+					LastModified  string `xml:"getlastmodified"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("PROPFIND %s: %w", target, err)
+	}
+
+	for _, entry := range status.Responses {
+		href, err := url.Parse(entry.Href)
+		if err != nil {
+			return fmt.Errorf("PROPFIND %s: %w", target, err)
+		}
+		name := strings.Trim(strings.TrimPrefix(href.Path, w.root.Path), "/")
+		if name == dir || !strings.HasPrefix(name, dir) {
+			continue
+		}
+		info := FileInfo{Name: name}
+		var collection bool
+		for _, propstat := range entry.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			collection = collection || prop.ResourceType.Collection != nil
+			info.Size = prop.ContentLength
+			info.Modified, _ = http.ParseTime(prop.LastModified)
+		}
+		switch {
+		case collection:
+			// Collections outside the prefix hold no matching files.
+			if strings.HasPrefix(name+"/", prefix) || strings.HasPrefix(prefix, name+"/") {
+				if err := w.list(ctx, name, prefix, fn); err != nil {
+					return err
+				}
+			}
+		case strings.HasPrefix(name, prefix) && !strings.HasSuffix(name, tmpSuffix):
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// do sends a request with the given body of size bytes and header. A 401
+// is answered once with the credentials for the basic or digest challenge
+// of the server, which are then sent with every further request. A body
+// that cannot be read again is only sent once the scheme is known, which
+// an OPTIONS request finds out first. Responses other than 2xx are
+// returned as errors.
+func (w *webdavStorage) do(ctx context.Context, method, rawURL string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	seeker, seekable := body.(io.ReadSeeker)
+	if body != nil && !seekable && w.auth.probe() {
+		if resp, err := w.do(ctx, http.MethodOptions, rawURL, nil, 0, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if body != nil && size > 0 {
+			if seekable {
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					return nil, err
+				}
+			}
+			req.Body, req.ContentLength = io.NopCloser(body), size
+		}
+		w.auth.authorize(req)
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resend := body == nil || size == 0 || seekable
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 1 && w.auth.challenge(resp) && resend {
+			resp.Body.Close()
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusNotFound && (method == http.MethodHead || method == http.MethodDelete || method == "PROPFIND") {
+				return nil, fmt.Errorf("%s %s: %w", method, req.URL.Redacted(), fs.ErrNotExist)
+			}
+			return nil, fmt.Errorf("%s %s: %w", method, req.URL.Redacted(), &statusError{code: resp.StatusCode, status: resp.Status})
+		}
+		return resp, nil
+	}
+}
+
+// webdavAuth answers the authentication challenge of a WebDAV server with
+// basic or digest credentials.
+type webdavAuth struct {
+	user     string
+	password string
+
+	mu sync.Mutex
+	// scheme is "basic" or "digest" once the server sent a challenge.
+	scheme string
+	probed bool
+	params map[string]string
+	count  int
+}
+
+// challenge takes the credentials scheme from the WWW-Authenticate header
+// of resp, preferring digest, and reports whether the request is worth
+// sending again with credentials.
+func (a *webdavAuth) challenge(resp *http.Response) bool {
+	if a.user == "" {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var basic bool
+	for _, value := range resp.Header.Values("WWW-Authenticate") {
+		scheme, params, _ := strings.Cut(value, " ")
+		switch strings.ToLower(scheme) {
+		case "digest":
+			a.scheme, a.params, a.count = "digest", parseAuthParams(params), 0
+			return true
+		case "basic":
+			basic = true
+		}
+	}
+	if basic {
+		a.scheme = "basic"
+	}
+	return basic
+}
+
+// probe reports whether the scheme is still to be found out with a
+// request without a body, which is only tried once.
+func (a *webdavAuth) probe() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.scheme != "" || a.probed || a.user == "" {
+		return false
+	}
+	a.probed = true
+	return true
+}
+
+// authorize adds the credentials of the current scheme to req.
+func (a *webdavAuth) authorize(req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch a.scheme {
+	case "basic":
+		req.SetBasicAuth(a.user, a.password)
+	case "digest":
+		req.Header.Set("Authorization", a.digest(req.Method, req.URL.RequestURI()))
+	}
+}
+
+// digest returns the Authorization header of a request of method and uri
+// for the digest challenge, as described in RFC 7616.
+func (a *webdavAuth) digest(method, uri string) string {
+	newHash := md5.New
+	if strings.EqualFold(strings.TrimSuffix(a.params["algorithm"], "-sess"), "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(parts ...string) string {
+		return hexHash(newHash(), strings.Join(parts, ":"))
+	}
+
+	cnonceBytes := make([]byte, 8)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	a.count++
+	nc := fmt.Sprintf("%08x", a.count)
+
+	realm, nonce := a.params["realm"], a.params["nonce"]
+	ha1 := h(a.user, realm, a.password)
+	if strings.HasSuffix(strings.ToLower(a.params["algorithm"]), "-sess") {
+		ha1 = h(ha1, nonce, cnonce)
+	}
+	ha2 := h(method, uri)
+	var qop string
+	for _, option := range strings.Split(a.params["qop"], ",") {
+		if strings.TrimSpace(option) == "auth" {
+			qop = "auth"
+		}
+	}
+	var response string
+	if qop != "" {
+		response = h(ha1, nonce, nc, cnonce, qop, ha2)
+	} else {
+		response = h(ha1, nonce, ha2)
+	}
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		a.user, realm, nonce, uri, response)
+	if algorithm := a.params["algorithm"]; algorithm != "" {
+		header += ", algorithm=" + algorithm
+	}
+	if opaque := a.params["opaque"]; opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	if qop != "" {
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	return header
+}
+
+func hexHash(h hash.Hash, s string) string {
+	io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// parseAuthParams parses the comma-separated key=value parameters of a
+// challenge, whose values may be quoted strings.
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value, s = b.String(), rest[min(i+1, len(rest)):]
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+	}
+}
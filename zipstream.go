@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// errNotStreamable reports that an archive cannot be validated while it
+// streams, so it has to be staged in the output directory instead.
+var errNotStreamable = errors.New("archive cannot be validated as a stream")
+
+// Signatures of the zip records.
+const (
+	zipLocalHeader     = 0x04034b50
+	zipDataDescriptor  = 0x08074b50
+	zipCentralHeader   = 0x02014b50
+	zipDigitalSig      = 0x05054b50
+	zip64EndRecord     = 0x06064b50
+	zip64EndLocator    = 0x07064b50
+	zipEndOfCentralDir = 0x06054b50
+)
+
+// verifyZipStream reads the zip archive r front to back and checks what
+// verifyArchive checks of a file: every entry is decompressed and its CRC
+// compared, and the central directory has to list as many entries and be
+// followed by its end record. Entries that are stored with a data
+// descriptor cannot be delimited without the central directory and return
+// errNotStreamable, as does data in front of the first entry.
+func verifyZipStream(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var entries, listed int
+	for first := true; ; first = false {
+		var sig uint32
+		if err := binary.Read(br, binary.LittleEndian, &sig); err != nil {
+			return fmt.Errorf("read zip record: %w", noEOF(err))
+		}
+		if first && sig != zipLocalHeader && sig != zipEndOfCentralDir {
+			return errNotStreamable
+		}
+		switch sig {
+		case zipLocalHeader:
+			if err := verifyZipEntry(br); err != nil {
+				return err
+			}
+			entries++
+		case zipCentralHeader:
+			var fixed [42]byte
+			if _, err := io.ReadFull(br, fixed[:]); err != nil {
+				return fmt.Errorf("read central directory: %w", noEOF(err))
+			}
+			variable := int64(le16(fixed[24:])) + int64(le16(fixed[26:])) + int64(le16(fixed[28:]))
+			if err := skip(br, variable); err != nil {
+				return fmt.Errorf("read central directory: %w", err)
+			}
+			listed++
+		case zipDigitalSig:
+			var size uint16
+			if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+				return fmt.Errorf("read signature: %w", noEOF(err))
+			}
+			if err := skip(br, int64(size)); err != nil {
+				return fmt.Errorf("read signature: %w", err)
+			}
+		case zip64EndRecord:
+			var size uint64
+			if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+				return fmt.Errorf("read zip64 end record: %w", noEOF(err))
+			}
+			if err := skip(br, int64(size)); err != nil {
+				return fmt.Errorf("read zip64 end record: %w", err)
+			}
+		case zip64EndLocator:
+			if err := skip(br, 16); err != nil {
+				return fmt.Errorf("read zip64 end locator: %w", err)
+			}
+		case zipEndOfCentralDir:
+			var fixed [18]byte
+			if _, err := io.ReadFull(br, fixed[:]); err != nil {
+				return fmt.Errorf("read end of central directory: %w", noEOF(err))
+			}
+			if err := skip(br, int64(le16(fixed[16:]))); err != nil {
+				return fmt.Errorf("read archive comment: %w", err)
+			}
+			switch {
+			case entries == 0:
+				return fmt.Errorf("archive is empty")
+			case listed != entries:
+				return fmt.Errorf("central directory lists %d of %d entries", listed, entries)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unexpected zip record %#08x", sig)
+		}
+	}
+}
+
+// verifyZipEntry reads the entry whose local header follows in br and
+// checks its data against the CRC and size of the header, or of its data
+// descriptor.
+func verifyZipEntry(br *bufio.Reader) error {
+	var fixed [26]byte
+	if _, err := io.ReadFull(br, fixed[:]); err != nil {
+		return fmt.Errorf("read local header: %w", noEOF(err))
+	}
+	flags, method := le16(fixed[2:]), le16(fixed[4:])
+	crc := le32(fixed[10:])
+	compressed, size := int64(le32(fixed[14:])), int64(le32(fixed[18:]))
+	name := make([]byte, le16(fixed[22:]))
+	extra := make([]byte, le16(fixed[24:]))
+	if _, err := io.ReadFull(br, name); err != nil {
+		return fmt.Errorf("read local header: %w", noEOF(err))
+	}
+	if _, err := io.ReadFull(br, extra); err != nil {
+		return fmt.Errorf("%s: read local header: %w", name, noEOF(err))
+	}
+	zip64 := compressed == 0xffffffff || size == 0xffffffff
+	if zip64 {
+		if size, compressed = zip64Sizes(extra, size, compressed); size < 0 || compressed < 0 {
+			return fmt.Errorf("%s: missing zip64 sizes", name)
+		}
+	}
+	descriptor := flags&0x8 != 0
+	switch {
+	case flags&0x1 != 0:
+		return fmt.Errorf("%s: encrypted entry", name)
+	case method == 0 && descriptor:
+		return errNotStreamable
+	case method != 0 && method != 8:
+		return fmt.Errorf("%s: unsupported compression method %d", name, method)
+	}
+
+	sum := crc32.NewIEEE()
+	var n int64
+	var err error
+	switch {
+	case method == 0:
+		n, err = io.CopyN(sum, br, compressed)
+	case descriptor:
+		// The deflate stream ends itself, and flate reads no further than
+		// that from a ByteReader.
+		n, err = io.Copy(sum, flate.NewReader(br))
+	default:
+		data := &io.LimitedReader{R: br, N: compressed}
+		n, err = io.Copy(sum, flate.NewReader(bufio.NewReader(data)))
+		if err == nil {
+			err = skip(data, data.N)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, noEOF(err))
+	}
+	if descriptor {
+		// Like archive/zip, only the CRC of the descriptor is checked.
+		if crc, err = readDataDescriptor(br); err != nil {
+			return fmt.Errorf("%s: read data descriptor: %w", name, noEOF(err))
+		}
+		size = n
+	}
+	switch {
+	case n != size:
+		return fmt.Errorf("%s: %d bytes, the header lists %d", name, n, size)
+	case sum.Sum32() != crc:
+		return fmt.Errorf("%s: checksum error", name)
+	}
+	return nil
+}
+
+// zip64Sizes returns the sizes of the zip64 extra field in extra for those
+// of size and compressed that overflowed, or -1 if it is missing.
+func zip64Sizes(extra []byte, size, compressed int64) (int64, int64) {
+	for len(extra) >= 4 {
+		id, n := le16(extra), int(le16(extra[2:]))
+		extra = extra[4:]
+		if n > len(extra) {
+			break
+		}
+		field := extra[:n]
+		extra = extra[n:]
+		if id != 0x0001 {
+			continue
+		}
+		for _, v := range []*int64{&size, &compressed} {
+			if *v != 0xffffffff {
+				continue
+			}
+			if len(field) < 8 {
+				return -1, -1
+			}
+			*v = int64(binary.LittleEndian.Uint64(field))
+			field = field[8:]
+		}
+		return size, compressed
+	}
+	return -1, -1
+}
+
+// readDataDescriptor reads the data descriptor that follows the data of an
+// entry, with or without its optional signature, and returns its CRC. The
+// sizes are 8 bytes each in zip64 archives, which the local header does
+// not always tell, so they are 4 bytes each if a record follows them.
+func readDataDescriptor(br *bufio.Reader) (uint32, error) {
+	var crc uint32
+	if err := binary.Read(br, binary.LittleEndian, &crc); err != nil {
+		return 0, err
+	}
+	if crc == zipDataDescriptor {
+		if err := binary.Read(br, binary.LittleEndian, &crc); err != nil {
+			return 0, err
+		}
+	}
+	if err := skip(br, 8); err != nil {
+		return 0, err
+	}
+	if next, err := br.Peek(4); err == nil {
+		switch le32(next) {
+		case zipLocalHeader, zipCentralHeader, zipDigitalSig, zip64EndRecord, zipEndOfCentralDir:
+			return crc, nil
+		}
+	}
+	return crc, skip(br, 8)
+}
+
+func le16(b []byte) uint16 { return binary.LittleEndian.Uint16(b) }
+func le32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
+
+// skip discards the next n bytes of r.
+func skip(r io.Reader, n int64) error {
+	_, err := io.CopyN(io.Discard, r, n)
+	return noEOF(err)
+}
+
+// noEOF turns the end of the archive in the middle of a record into
+// io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// archiveGate passes an archive of size bytes on to w while it hashes it
+// and validates it with verifyZipStream. The storages read exactly size
+// bytes, so the last bytes are only passed on once the archive proved valid
+// and check accepted its size and SHA-256: an invalid archive fails the
+// write instead of completing the upload.
+type archiveGate struct {
+	w     io.Writer
+	size  int64
+	n     int64
+	hash  hash.Hash
+	check func(size int64, sum string) error
+
+	zip    *io.PipeWriter
+	result chan error
+	done   bool
+	err    error
+}
+
+func newArchiveGate(w io.Writer, size int64, check func(size int64, sum string) error) *archiveGate {
+	pr, pw := io.Pipe()
+	g := &archiveGate{w: w, size: size, hash: sha256.New(), check: check, zip: pw, result: make(chan error, 1)}
+	go func() {
+		err := verifyZipStream(pr)
+		g.result <- err
+		if err != nil {
+			// Fail the write under way, or else the next one.
+			pr.CloseWithError(err)
+			return
+		}
+		// Data after the end of the central directory is not checked.
+		io.Copy(io.Discard, pr)
+	}()
+	return g
+}
+
+func (g *archiveGate) Write(p []byte) (int, error) {
+	if g.done && g.err != nil {
+		return 0, g.err
+	}
+	if g.n+int64(len(p)) > g.size {
+		return 0, fmt.Errorf("archive is larger than its %d bytes", g.size)
+	}
+	g.hash.Write(p)
+	if _, err := g.zip.Write(p); err != nil {
+		return 0, g.finish()
+	}
+	g.n += int64(len(p))
+	if g.n == g.size {
+		if err := g.finish(); err != nil {
+			return 0, err
+		}
+		if g.check != nil {
+			if err := g.check(g.size, g.sum()); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return g.w.Write(p)
+}
+
+// finish ends the validation and returns its result as an invalid archive,
+// or errNotStreamable.
+func (g *archiveGate) finish() error {
+	if g.done {
+		return g.err
+	}
+	g.zip.Close()
+	err := <-g.result
+	g.done = true
+	if err != nil && !errors.Is(err, errNotStreamable) {
+		err = fmt.Errorf("%w: %w", errInvalidArchive, err)
+	}
+	g.err = err
+	return err
+}
+
+// abort stops the validation of an archive that did not arrive in full.
+func (g *archiveGate) abort(err error) {
+	if !g.done {
+		g.zip.CloseWithError(err)
+		<-g.result
+		g.done = true
+	}
+}
+
+// sum returns the hex encoded SHA-256 of the archive passed on so far.
+func (g *archiveGate) sum() string {
+	return hex.EncodeToString(g.hash.Sum(nil))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"testing"
+)
+
+// testEntry is an entry of an archive built by buildZip.
+type testEntry struct {
+	name       string
+	method     uint16
+	data       []byte
+	descriptor bool
+	zip64      bool
+	encrypted  bool
+}
+
+// buildZip writes the entries as a zip archive byte by byte, so that the
+// tests control the layouts archive/zip does not write, and lists the
+// first listed of them in the central directory.
+func buildZip(t *testing.T, entries []testEntry, listed int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	le := func(v any) { binary.Write(&b, binary.LittleEndian, v) }
+	offsets := make([]uint32, len(entries))
+	compressed := make([][]byte, len(entries))
+	for i, e := range entries {
+		offsets[i] = uint32(b.Len())
+		data := e.data
+		if e.method == zip.Deflate {
+			var c bytes.Buffer
+			w, _ := flate.NewWriter(&c, flate.DefaultCompression)
+			w.Write(e.data)
+			w.Close()
+			data = c.Bytes()
+		}
+		compressed[i] = data
+		crc := crc32.ChecksumIEEE(e.data)
+
+		var flags uint16
+		if e.descriptor {
+			flags |= 0x8
+		}
+		if e.encrypted {
+			flags |= 0x1
+		}
+		var extra []byte
+		headerCRC, csize, usize := crc, uint32(len(data)), uint32(len(e.data))
+		switch {
+		case e.descriptor:
+			headerCRC, csize, usize = 0, 0, 0
+		case e.zip64:
+			csize, usize = 0xffffffff, 0xffffffff
+			extra = binary.LittleEndian.AppendUint16(extra, 0x0001)
+			extra = binary.LittleEndian.AppendUint16(extra, 16)
+			extra = binary.LittleEndian.AppendUint64(extra, uint64(len(e.data)))
+			extra = binary.LittleEndian.AppendUint64(extra, uint64(len(data)))
+		}
+		le(uint32(zipLocalHeader))
+		le([]uint16{45, flags, e.method, 0, 0})
+		le([]uint32{headerCRC, csize, usize})
+		le([]uint16{uint16(len(e.name)), uint16(len(extra))})
+		b.WriteString(e.name)
+		b.Write(extra)
+		b.Write(data)
+		if e.descriptor {
+			le([]uint32{zipDataDescriptor, crc})
+			if e.zip64 {
+				le([]uint64{uint64(len(data)), uint64(len(e.data))})
+			} else {
+				le([]uint32{uint32(len(data)), uint32(len(e.data))})
+			}
+		}
+	}
+
+	start := b.Len()
+	for i, e := range entries[:listed] {
+		le(uint32(zipCentralHeader))
+		le([]uint16{45, 45, 0, e.method, 0, 0})
+		le([]uint32{crc32.ChecksumIEEE(e.data), uint32(len(compressed[i])), uint32(len(e.data))})
+		le([]uint16{uint16(len(e.name)), 0, 0, 0, 0})
+		le([]uint32{0, offsets[i]})
+		b.WriteString(e.name)
+	}
+	le(uint32(zipEndOfCentralDir))
+	le([]uint16{0, 0, uint16(listed), uint16(listed)})
+	le([]uint32{uint32(b.Len() - start), uint32(start)})
+	le(uint16(0))
+	return b.Bytes()
+}
+
+// writtenZip returns an archive as archive/zip writes it, with data
+// descriptors after its deflated entries.
+func writtenZip(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, name := range []string{"akismet/akismet.php", "akismet/readme.txt", "akismet/views/"} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(bytes.Repeat([]byte(name), 100))
+	}
+	w.SetComment("akismet 5.3.1")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func TestVerifyZipStream(t *testing.T) {
+	text := bytes.Repeat([]byte("<?php echo 'hello';\n"), 50)
+	stored := testEntry{name: "plugin/plugin.php", method: zip.Store, data: text}
+	deflated := testEntry{name: "plugin/readme.txt", method: zip.Deflate, data: text}
+	with := func(e testEntry, change func(*testEntry)) testEntry {
+		change(&e)
+		return e
+	}
+	valid := buildZip(t, []testEntry{stored, deflated}, 2)
+
+	corrupt := bytes.Clone(valid)
+	corrupt[30+len(stored.name)+10] ^= 0xff
+	centralFirst := valid[bytes.Index(valid, []byte{0x50, 0x4b, 0x01, 0x02}):]
+
+	tests := []struct {
+		name    string
+		archive []byte
+		// want is nil, errNotStreamable or errInvalidArchive for any other
+		// error.
+		want error
+	}{
+		{"stored and deflated", valid, nil},
+		{"written by archive/zip", writtenZip(t), nil},
+		{"deflated with data descriptor", buildZip(t, []testEntry{with(deflated, func(e *testEntry) { e.descriptor = true }), stored}, 2), nil},
+		{"zip64 sizes", buildZip(t, []testEntry{with(stored, func(e *testEntry) { e.zip64 = true }), with(deflated, func(e *testEntry) { e.zip64 = true })}, 2), nil},
+		{"zip64 data descriptor", buildZip(t, []testEntry{with(deflated, func(e *testEntry) { e.zip64, e.descriptor = true, true }), stored}, 2), nil},
+		{"trailing data", append(bytes.Clone(valid), "trailer"...), nil},
+		{"stored with data descriptor", buildZip(t, []testEntry{with(stored, func(e *testEntry) { e.descriptor = true })}, 1), errNotStreamable},
+		{"central directory first", centralFirst, errNotStreamable},
+		{"data before the first entry", append([]byte("#!/bin/sh\n"), valid...), errNotStreamable},
+		{"empty", buildZip(t, nil, 0), errInvalidArchive},
+		{"truncated", valid[:len(valid)-10], errInvalidArchive},
+		{"truncated entry", valid[:40], errInvalidArchive},
+		{"checksum mismatch", corrupt, errInvalidArchive},
+		{"entry missing from central directory", buildZip(t, []testEntry{stored, deflated}, 1), errInvalidArchive},
+		{"encrypted", buildZip(t, []testEntry{with(stored, func(e *testEntry) { e.encrypted = true })}, 1), errInvalidArchive},
+		{"unsupported method", buildZip(t, []testEntry{with(stored, func(e *testEntry) { e.method = 14 })}, 1), errInvalidArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyZipStream(bytes.NewReader(tt.archive))
+			switch {
+			case tt.want == nil && err != nil:
+				t.Fatalf("verifyZipStream() = %v, want nil", err)
+			case tt.want == errNotStreamable && !errors.Is(err, errNotStreamable):
+				t.Fatalf("verifyZipStream() = %v, want %v", err, errNotStreamable)
+			case tt.want == errInvalidArchive && (err == nil || errors.Is(err, errNotStreamable)):
+				t.Fatalf("verifyZipStream() = %v, want an invalid archive", err)
+			}
+		})
+	}
+}
+
+func TestArchiveGate(t *testing.T) {
+	// The large second entry leaves room for the gate to fail before it.
+	payload := testEntry{name: "plugin/assets.bin", method: zip.Store, data: randomData(256 << 10)}
+	php := testEntry{name: "plugin/plugin.php", method: zip.Deflate, data: bytes.Repeat([]byte("<?php\n"), 100)}
+	valid := buildZip(t, []testEntry{php, payload}, 2)
+	sum := sha256.Sum256(valid)
+	corrupt := bytes.Clone(valid)
+	corrupt[30+len(php.name)+5] ^= 0xff
+	unstreamable := buildZip(t, []testEntry{{name: "a", method: zip.Store, data: []byte("a"), descriptor: true}, payload}, 2)
+	mismatch := func(size int64, sum string) error { return matchPin(ManifestEntry{Size: size, SHA256: "0"}, size, sum) }
+
+	tests := []struct {
+		name    string
+		archive []byte
+		size    int64
+		check   func(size int64, sum string) error
+		fail    bool
+		// is, if set, is the error a failure wraps.
+		is error
+		// early is whether the gate has to fail while most of the archive
+		// is still to come.
+		early bool
+	}{
+		{name: "valid", archive: valid, size: int64(len(valid))},
+		{name: "pinned", archive: valid, size: int64(len(valid)), check: func(size int64, got string) error {
+			return matchPin(ManifestEntry{Size: int64(len(valid)), SHA256: hex.EncodeToString(sum[:])}, size, got)
+		}},
+		{name: "corrupt", archive: corrupt, size: int64(len(corrupt)), fail: true, is: errInvalidArchive, early: true},
+		{name: "not streamable", archive: unstreamable, size: int64(len(unstreamable)), fail: true, is: errNotStreamable, early: true},
+		{name: "pin mismatch", archive: valid, size: int64(len(valid)), check: mismatch, fail: true, is: errInvalidArchive},
+		{name: "oversize", archive: valid, size: int64(len(valid)) - 100, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			var checked bool
+			check := tt.check
+			if check == nil {
+				check = func(int64, string) error { return nil }
+			}
+			g := newArchiveGate(&out, tt.size, func(size int64, sum string) error {
+				checked = true
+				return check(size, sum)
+			})
+			var err error
+			for rest := tt.archive; len(rest) > 0 && err == nil; {
+				chunk := rest[:min(len(rest), 4096)]
+				rest = rest[len(chunk):]
+				_, err = g.Write(chunk)
+			}
+			if err != nil {
+				g.abort(err)
+			}
+
+			if !tt.fail {
+				if err != nil {
+					t.Fatalf("Write() = %v", err)
+				}
+				if !bytes.Equal(out.Bytes(), tt.archive) || g.sum() != hex.EncodeToString(sum[:]) || !checked {
+					t.Fatalf("passed on %d of %d bytes, sum %s, checked %v", out.Len(), len(tt.archive), g.sum(), checked)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Write() = nil, want an error")
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Fatalf("Write() = %v, want %v", err, tt.is)
+			}
+			if out.Len() >= int(tt.size) {
+				t.Fatalf("passed on all %d bytes of a rejected archive", out.Len())
+			}
+			if tt.early && out.Len() > len(tt.archive)/2 {
+				t.Fatalf("passed on %d of %d bytes before failing", out.Len(), len(tt.archive))
+			}
+		})
+	}
+}